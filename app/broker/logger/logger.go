@@ -8,6 +8,7 @@ package logger
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
@@ -41,6 +42,56 @@ const (
 	DebugLevel
 )
 
+// logrusLevels maps each LogLevel to logrus' equivalent.
+var logrusLevels = map[LogLevel]logrus.Level{
+	PanicLevel: logrus.PanicLevel,
+	FatalLevel: logrus.FatalLevel,
+	ErrorLevel: logrus.ErrorLevel,
+	WarnLevel:  logrus.WarnLevel,
+	InfoLevel:  logrus.InfoLevel,
+	DebugLevel: logrus.DebugLevel,
+}
+
+// ParseLogLevel returns the LogLevel with the given name, such as "info".
+func ParseLogLevel(name string) (LogLevel, error) {
+	lvl, err := logrus.ParseLevel(name)
+	if err != nil {
+		return InfoLevel, errors.Errorf("unknown log level %q", name)
+	}
+	for level, l := range logrusLevels {
+		if l == lvl {
+			return level, nil
+		}
+	}
+	return InfoLevel, errors.Errorf("unsupported log level %q", name)
+}
+
+// String returns the name of the log level, such as "info".
+func (level LogLevel) String() string {
+	if l, ok := logrusLevels[level]; ok {
+		return l.String()
+	}
+	return fmt.Sprintf("LogLevel(%d)", uint32(level))
+}
+
+// MarshalText encodes the log level as its name.
+func (level LogLevel) MarshalText() ([]byte, error) {
+	if _, ok := logrusLevels[level]; !ok {
+		return nil, errors.Errorf("invalid log level %d", uint32(level))
+	}
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText decodes the name of a log level.
+func (level *LogLevel) UnmarshalText(text []byte) error {
+	l, err := ParseLogLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*level = l
+	return nil
+}
+
 // ContextGoLogger structure holds logrus instance, tag and writer
 type ContextGoLogger struct {
 	logger *logrus.Logger
@@ -58,6 +109,17 @@ const (
 	JSONFormat
 )
 
+// ParseLogFormat returns the LogFormat with the given name: "text" or "json".
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return TextFormat, nil
+	case "json":
+		return JSONFormat, nil
+	}
+	return TextFormat, errors.Errorf("unknown log format %q", name)
+}
+
 // GlobalLogger instance that can be used by developer
 var GlobalLogger = New("global", TextFormat, os.Stdout, DebugLevel)
 
@@ -84,50 +146,62 @@ func New(tag string, logFormatter LogFormat, output io.Writer, logLevel LogLevel
 func (loggerInstance *ContextGoLogger) SetLogFormatter(logFormatter LogFormat) {
 	switch logFormatter {
 	case TextFormat:
-		loggerInstance.logger.Formatter = new(logrus.TextFormatter)
+		loggerInstance.logger.SetFormatter(tagFormatter{new(logrus.TextFormatter), loggerInstance.tag})
 	case JSONFormat:
-		loggerInstance.logger.Formatter = new(logrus.JSONFormatter)
+		loggerInstance.logger.SetFormatter(tagFormatter{new(logrus.JSONFormatter), loggerInstance.tag})
 	}
 }
 
 //SetOutput is a output type setter
 func (loggerInstance *ContextGoLogger) SetOutput(output io.Writer) {
-	loggerInstance.logger.Out = output
+	loggerInstance.logger.SetOutput(output)
 	loggerInstance.writer = output
 }
 
 //DisableLogging allows to disable logging
 func (loggerInstance *ContextGoLogger) DisableLogging() {
-	loggerInstance.logger.Out = ioutil.Discard
+	loggerInstance.logger.SetOutput(ioutil.Discard)
 }
 
 //EnableLogging allows to enable logging after disabling it
 func (loggerInstance *ContextGoLogger) EnableLogging() {
-	loggerInstance.logger.Out = loggerInstance.writer
+	loggerInstance.logger.SetOutput(loggerInstance.writer)
 }
 
 //SetLogLevel allows to change log level
 func (loggerInstance *ContextGoLogger) SetLogLevel(logLevel LogLevel) {
+	if level, ok := logrusLevels[logLevel]; ok {
+		loggerInstance.logger.SetLevel(level)
+	}
+}
 
-	switch logLevel {
-	case DebugLevel:
-		loggerInstance.logger.Level = logrus.DebugLevel
-	case InfoLevel:
-		loggerInstance.logger.Level = logrus.InfoLevel
-	case WarnLevel:
-		loggerInstance.logger.Level = logrus.WarnLevel
-	case ErrorLevel:
-		loggerInstance.logger.Level = logrus.ErrorLevel
-	case FatalLevel:
-		loggerInstance.logger.Level = logrus.FatalLevel
-	case PanicLevel:
-		loggerInstance.logger.Level = logrus.PanicLevel
+//LogLevel returns the minimum level of logs to save
+func (loggerInstance *ContextGoLogger) LogLevel() LogLevel {
+	level := loggerInstance.logger.GetLevel()
+	for logLevel, l := range logrusLevels {
+		if l == level {
+			return logLevel
+		}
 	}
+	return DebugLevel
 }
 
-//SetLogFile allows to set logger to log to file specified as parameter
-func (loggerInstance *ContextGoLogger) SetLogFile(logFile string) {
-	loggerInstance.logToFile(logFile)
+//Tag returns the tag added to each log message for the instance
+func (loggerInstance *ContextGoLogger) Tag() string {
+	return loggerInstance.tag
+}
+
+//SetLogFile allows to set logger to log to file specified as parameter,
+//which is rotated according to the given Rotation. If the file can't be
+//opened, the logger's output is unchanged, and the error is returned. The
+//caller should close the file once it's done logging.
+func (loggerInstance *ContextGoLogger) SetLogFile(logFile string, rotation Rotation) (*RotatingFile, error) {
+	file, err := OpenRotatingFile(logFile, rotation)
+	if err != nil {
+		return nil, err
+	}
+	loggerInstance.SetOutput(file)
+	return file, nil
 }
 
 //private method to convert input to logrus fields
@@ -140,6 +214,22 @@ func (loggerInstance *ContextGoLogger) getData(params Params) logrus.Fields {
 	return data
 }
 
+//WithField returns an entry with the instance's tag and the given field,
+//for logging with logrus' API, such as formatted messages
+func (loggerInstance *ContextGoLogger) WithField(key string, value interface{}) *logrus.Entry {
+	return loggerInstance.logger.WithFields(loggerInstance.getData(Params{key: value}))
+}
+
+//WithFields returns an entry with the instance's tag and the given params
+func (loggerInstance *ContextGoLogger) WithFields(params Params) *logrus.Entry {
+	return loggerInstance.logger.WithFields(loggerInstance.getData(params))
+}
+
+//WithError returns an entry with the instance's tag and the given error
+func (loggerInstance *ContextGoLogger) WithError(err error) *logrus.Entry {
+	return loggerInstance.WithField(logrus.ErrorKey, err)
+}
+
 //Debug level. Usually only enabled when debugging. Very verbose logging.
 //message : String
 //params: Params of Type maps that user can log any number of key value pairs
@@ -183,16 +273,6 @@ func (loggerInstance *ContextGoLogger) Panic(message string, params Params) {
 	loggerInstance.logger.WithFields(loggerInstance.getData(params)).Panic(message)
 }
 
-//Log to file will take input path to create/append logs to existing file
-func (loggerInstance *ContextGoLogger) logToFile(pathToFile string) {
-	file, err := os.OpenFile(pathToFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
-	if err == nil {
-		loggerInstance.SetOutput(file)
-	} else {
-		loggerInstance.Info("Failed to log to file, using default stdout", nil)
-	}
-}
-
 // StackTrace logs the current stack trace at Error level
 func (loggerInstance *ContextGoLogger) StackTrace(message string, err interface{}) {
 	loggerInstance.logger.Errorln(fmt.Sprintf("%s: %v", message, err))
@@ -214,3 +294,25 @@ func (loggerInstance *ContextGoLogger) StackTrace(message string, err interface{
 		}
 	}
 }
+
+// tagFormatter adds the logger's tag to entries that don't have one, such as
+// those logged with logrus' package-level functions.
+type tagFormatter struct {
+	logrus.Formatter
+	tag string
+}
+
+func (tf tagFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if _, ok := entry.Data["TAG"]; ok {
+		return tf.Formatter.Format(entry)
+	}
+	// the entry's data may be shared with other entries, so it's copied
+	data := make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	data["TAG"] = tf.tag
+	tagged := *entry
+	tagged.Data = data
+	return tf.Formatter.Format(&tagged)
+}
@@ -0,0 +1,350 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// backupTimeFormat is the format of the time in the names of rotated files.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// compressedSuffix is appended to the names of compressed backups.
+const compressedSuffix = ".gz"
+
+// Rotation determines when a RotatingFile is rotated, and which of its backups
+// are kept. Its zero value never rotates the file.
+type Rotation struct {
+	// MaxSize is the size in bytes at which the file is rotated; if it's
+	// zero, its size isn't limited.
+	MaxSize int64
+	// MaxAge is how long the file is written to before it's rotated; if it's
+	// zero, its age isn't limited.
+	MaxAge time.Duration
+	// MaxBackups is the most rotated files to keep, newest first; if it's
+	// zero, they're all kept.
+	MaxBackups int
+	// Compress rotated files with gzip.
+	Compress bool
+	// OnCleanupError, if it's set, is called with errors that occur while
+	// backups are compressed and removed, which happens in the background.
+	OnCleanupError func(error)
+}
+
+// RotatingFile is an io.Writer that appends to a file, and renames it when it
+// grows too large or old, so that the next write starts a new one. Each backup
+// is named after the file with the time it was rotated, such as
+// "service-2019-06-01T12-00-00.000.log". Backups are compressed and removed
+// by a goroutine, so writes aren't delayed while that happens.
+type RotatingFile struct {
+	path     string
+	rotation Rotation
+	now      func() time.Time
+	cleanups chan struct{} // tells the goroutine to clean the backups
+	cleaned  chan struct{} // closed when the goroutine returns
+
+	mux    sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+// OpenRotatingFile opens a file for appending, creating it if necessary, which
+// is rotated according to the given Rotation.
+func OpenRotatingFile(path string, rotation Rotation) (*RotatingFile, error) {
+	if rotation.MaxSize < 0 || rotation.MaxAge < 0 || rotation.MaxBackups < 0 {
+		return nil, errors.New("log rotation limits must not be negative")
+	}
+	rf := &RotatingFile{
+		path:     path,
+		rotation: rotation,
+		now:      time.Now,
+		cleanups: make(chan struct{}, 1),
+		cleaned:  make(chan struct{}),
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	go rf.cleanBackups()
+	// finish compressing and removing backups if the service stopped before
+	// it could do so
+	rf.requestCleanup()
+	return rf, nil
+}
+
+// Write appends p to the file, first rotating it if p wouldn't fit within its
+// MaxSize, or if it's older than its MaxAge. If rotating the file fails, p is
+// still written if possible, but the error is returned.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	if rf.closed || rf.file == nil {
+		return 0, errors.Errorf("log file %q is closed", rf.path)
+	}
+
+	var rotateErr error
+	if rf.shouldRotate(len(p)) {
+		rotateErr = rf.rotate()
+		rf.requestCleanup()
+	}
+	if rf.file == nil {
+		return 0, rotateErr
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Rotate renames the file and starts a new one, regardless of its size or age.
+func (rf *RotatingFile) Rotate() error {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	if rf.closed || rf.file == nil {
+		return errors.Errorf("log file %q is closed", rf.path)
+	}
+	err := rf.rotate()
+	rf.requestCleanup()
+	return err
+}
+
+// Reopen closes the file and opens it again, creating it if necessary. It's
+// meant for use after another program, such as logrotate, moves the file.
+func (rf *RotatingFile) Reopen() error {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+	if rf.closed {
+		return errors.Errorf("log file %q is closed", rf.path)
+	}
+	if rf.file != nil {
+		if err := rf.file.Close(); err != nil {
+			return errors.Wrapf(err, "unable to close log file %q", rf.path)
+		}
+		rf.file = nil
+	}
+	return rf.open()
+}
+
+// Close closes the file, then waits for backups that are being compressed or
+// removed; later writes fail.
+func (rf *RotatingFile) Close() error {
+	rf.mux.Lock()
+	if rf.closed {
+		rf.mux.Unlock()
+		return nil
+	}
+	rf.closed = true
+	close(rf.cleanups)
+	var err error
+	if rf.file != nil {
+		err = errors.Wrapf(rf.file.Close(), "unable to close log file %q", rf.path)
+		rf.file = nil
+	}
+	rf.mux.Unlock()
+
+	// not holding the lock, in case OnCleanupError logs
+	<-rf.cleaned
+	return err
+}
+
+// shouldRotate returns true if the file should be rotated before writing n
+// bytes to it; the caller must hold the lock.
+func (rf *RotatingFile) shouldRotate(n int) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.rotation.MaxSize > 0 && rf.size+int64(n) > rf.rotation.MaxSize {
+		return true
+	}
+	return rf.rotation.MaxAge > 0 && rf.now().Sub(rf.opened) >= rf.rotation.MaxAge
+}
+
+// rotate renames the file and opens a new one; the caller must hold the lock.
+// If the file can't be renamed, it's reopened so logging can continue.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return errors.Wrapf(err, "unable to close log file %q", rf.path)
+	}
+	rf.file = nil
+
+	renameErr := os.Rename(rf.path, rf.backupPath(rf.now()))
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return errors.Wrapf(renameErr, "unable to rotate log file %q", rf.path)
+}
+
+// open opens the file for appending; the caller must hold the lock.
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open log file %q", rf.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "unable to open log file %q", rf.path)
+	}
+	rf.file = f
+	rf.size = info.Size()
+	rf.opened = rf.now()
+	return nil
+}
+
+// backupPath returns an unused name for a backup rotated at the given time.
+func (rf *RotatingFile) backupPath(t time.Time) string {
+	prefix, ext := rf.backupPrefix()
+	stamp := t.UTC().Format(backupTimeFormat)
+	for i := 0; ; i++ {
+		name := prefix + stamp + ext
+		if i > 0 {
+			name = fmt.Sprintf("%s%s-%d%s", prefix, stamp, i, ext)
+		}
+		_, err := os.Stat(name)
+		_, gzErr := os.Stat(name + compressedSuffix)
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+	}
+}
+
+// backupPrefix returns the path of the file, without its extension, to which
+// the backups' times are appended, along with the extension that follows them.
+func (rf *RotatingFile) backupPrefix() (string, string) {
+	ext := filepath.Ext(rf.path)
+	return strings.TrimSuffix(rf.path, ext) + "-", ext
+}
+
+// backups returns the paths of the file's backups, newest first.
+func (rf *RotatingFile) backups() ([]string, error) {
+	prefix, ext := rf.backupPrefix()
+	infos, err := ioutil.ReadDir(filepath.Dir(rf.path))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list log backups")
+	}
+
+	var backups []string
+	for _, info := range infos {
+		path := filepath.Join(filepath.Dir(rf.path), info.Name())
+		if info.IsDir() || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(path, prefix)
+		stamp = strings.TrimSuffix(stamp, compressedSuffix)
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, ext)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)]); err != nil {
+			continue
+		}
+		backups = append(backups, path)
+	}
+
+	// the times sort lexically, so the newest come first in reverse order
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// requestCleanup tells the goroutine to clean the backups, unless it's already
+// been told to; the caller must hold the lock.
+func (rf *RotatingFile) requestCleanup() {
+	if rf.closed {
+		return
+	}
+	select {
+	case rf.cleanups <- struct{}{}:
+	default:
+	}
+}
+
+// cleanBackups cleans the backups each time it's requested, and reports errors
+// to the OnCleanupError callback, until the file is closed.
+func (rf *RotatingFile) cleanBackups() {
+	defer close(rf.cleaned)
+	for range rf.cleanups {
+		if err := rf.clean(); err != nil && rf.rotation.OnCleanupError != nil {
+			rf.rotation.OnCleanupError(err)
+		}
+	}
+}
+
+// clean compresses the backups, if they should be compressed, and removes any
+// beyond the MaxBackups.
+func (rf *RotatingFile) clean() error {
+	if !rf.rotation.Compress && rf.rotation.MaxBackups == 0 {
+		return nil
+	}
+
+	backups, err := rf.backups()
+	if err != nil {
+		return err
+	}
+	for i, path := range backups {
+		if rf.rotation.MaxBackups > 0 && i >= rf.rotation.MaxBackups {
+			if err := os.Remove(path); err != nil {
+				return errors.Wrap(err, "unable to remove old log backup")
+			}
+			continue
+		}
+		if rf.rotation.Compress && !strings.HasSuffix(path, compressedSuffix) {
+			if err := compress(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compress replaces a file with a gzipped copy.
+func compress(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to compress log backup")
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(path+compressedSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to compress log backup")
+	}
+	defer func() {
+		if err != nil {
+			_ = dest.Close()
+			_ = os.Remove(path + compressedSuffix)
+		}
+	}()
+
+	gz := gzip.NewWriter(dest)
+	if _, err = io.Copy(gz, src); err != nil {
+		return errors.Wrap(err, "unable to compress log backup")
+	}
+	if err = gz.Close(); err != nil {
+		return errors.Wrap(err, "unable to compress log backup")
+	}
+	if err = dest.Close(); err != nil {
+		return errors.Wrap(err, "unable to compress log backup")
+	}
+	return errors.Wrap(os.Remove(path), "unable to remove compressed log backup")
+}
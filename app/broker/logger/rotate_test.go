@@ -0,0 +1,173 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+// listDir returns the names of the files in a directory.
+func listDir(w *expect.TWrapper, dir string) []string {
+	w.Helper()
+	infos := w.ShouldHaveResult(ioutil.ReadDir(dir)).([]os.FileInfo)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names
+}
+
+// readFile returns the contents of a file, decompressing it if it's gzipped.
+func readFile(w *expect.TWrapper, path string) string {
+	w.Helper()
+	f := w.ShouldHaveResult(os.Open(path)).(*os.File)
+	defer f.Close()
+	if !strings.HasSuffix(path, compressedSuffix) {
+		return string(w.ShouldHaveResult(ioutil.ReadAll(f)).([]byte))
+	}
+	gz := w.ShouldHaveResult(gzip.NewReader(f)).(*gzip.Reader)
+	return string(w.ShouldHaveResult(ioutil.ReadAll(gz)).([]byte))
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "rotate")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	rf := w.ShouldHaveResult(OpenRotatingFile(path, Rotation{
+		MaxSize: 10, MaxBackups: 2, Compress: true,
+	})).(*RotatingFile)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time { return now }
+
+	// a file is rotated before a write that would exceed its size
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		w.ShouldHaveResult(rf.Write([]byte(line)))
+		now = now.Add(time.Second)
+	}
+	w.ShouldSucceed(rf.Close())
+	w.ShouldFail(rf.Write([]byte("closed\n")))
+
+	// only the newest backups are kept, compressed
+	w.ShouldBeEqual(listDir(w, dir), []string{
+		"service-2019-06-01T12-00-02.000.log.gz",
+		"service-2019-06-01T12-00-03.000.log.gz",
+		"service.log",
+	})
+	w.ShouldBeEqual(readFile(w, filepath.Join(dir, "service-2019-06-01T12-00-02.000.log.gz")), "second\n")
+	w.ShouldBeEqual(readFile(w, filepath.Join(dir, "service-2019-06-01T12-00-03.000.log.gz")), "third\n")
+	w.ShouldBeEqual(readFile(w, path), "fourth\n")
+
+	// a single write larger than the limit still goes to one file
+	rf = w.ShouldHaveResult(OpenRotatingFile(path, Rotation{MaxSize: 10})).(*RotatingFile)
+	rf.now = func() time.Time { return now }
+	w.ShouldHaveResult(rf.Write([]byte("a much longer line\n")))
+	w.ShouldSucceed(rf.Close())
+	w.ShouldBeEqual(readFile(w, path), "a much longer line\n")
+	w.ShouldHaveLength(listDir(w, dir), 4)
+}
+
+func TestRotatingFile_Age(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "rotate")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	rf := w.ShouldHaveResult(OpenRotatingFile(path, Rotation{MaxAge: time.Hour})).(*RotatingFile)
+	defer rf.Close()
+	rf.now = func() time.Time { return now }
+	rf.opened = now
+
+	w.ShouldHaveResult(rf.Write([]byte("first\n")))
+	now = now.Add(30 * time.Minute)
+	w.ShouldHaveResult(rf.Write([]byte("second\n")))
+	now = now.Add(30 * time.Minute)
+	w.ShouldHaveResult(rf.Write([]byte("third\n")))
+
+	w.ShouldBeEqual(listDir(w, dir), []string{
+		"service-2019-06-01T13-00-00.000.log",
+		"service.log",
+	})
+	w.ShouldBeEqual(readFile(w, filepath.Join(dir, "service-2019-06-01T13-00-00.000.log")), "first\nsecond\n")
+	w.ShouldBeEqual(readFile(w, path), "third\n")
+
+	// rotating again at the same time doesn't overwrite the backup
+	w.ShouldSucceed(rf.Rotate())
+	w.ShouldContain(listDir(w, dir), []string{"service-2019-06-01T13-00-00.000-1.log"})
+}
+
+func TestRotatingFile_CleanupError(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "rotate")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	// the compressed backup can't be created where a directory is in the way
+	backup := filepath.Join(dir, "service-2019-06-01T12-00-00.000.log")
+	w.ShouldSucceed(ioutil.WriteFile(backup, []byte("old\n"), 0600))
+	w.ShouldSucceed(os.Mkdir(backup+compressedSuffix, 0700))
+
+	errs := make(chan error, 1)
+	rf := w.ShouldHaveResult(OpenRotatingFile(path, Rotation{
+		Compress:       true,
+		OnCleanupError: func(err error) { errs <- err },
+	})).(*RotatingFile)
+	defer rf.Close()
+
+	select {
+	case err := <-errs:
+		w.ShouldContainStr(err.Error(), "unable to compress log backup")
+	case <-time.After(5 * time.Second):
+		w.Error("timed out waiting for the cleanup error")
+	}
+	w.ShouldHaveResult(rf.Write([]byte("still logging\n")))
+	w.ShouldBeEqual(readFile(w, backup), "old\n")
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "rotate")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "service.log")
+
+	rf := w.ShouldHaveResult(OpenRotatingFile(path, Rotation{})).(*RotatingFile)
+	defer rf.Close()
+	w.ShouldHaveResult(rf.Write([]byte("before\n")))
+
+	// another program moves the file, then signals the service to reopen it
+	w.ShouldSucceed(os.Rename(path, path+".1"))
+	w.ShouldSucceed(rf.Reopen())
+	w.ShouldHaveResult(rf.Write([]byte("after\n")))
+	w.ShouldBeEqual(readFile(w, path+".1"), "before\n")
+	w.ShouldBeEqual(readFile(w, path), "after\n")
+
+	w.ShouldFail(OpenRotatingFile(path, Rotation{MaxSize: -1}))
+}
+
+func TestSetLogFile(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "rotate")).(string)
+	defer os.RemoveAll(dir)
+
+	l := New("test", TextFormat, ioutil.Discard, InfoLevel)
+	w.ShouldFail(l.SetLogFile(filepath.Join(dir, "missing", "service.log"), Rotation{}))
+
+	rf := w.ShouldHaveResult(l.SetLogFile(filepath.Join(dir, "service.log"), Rotation{})).(*RotatingFile)
+	l.Info("logged", nil)
+	w.ShouldSucceed(rf.Close())
+	w.ShouldContainStr(readFile(w, filepath.Join(dir, "service.log")), "msg=logged")
+}
@@ -0,0 +1,161 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package logger
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ServiceTag is the tag of the service's own logger. Unlike other tagged
+// loggers, it's logrus' standard logger, so entries logged with the logrus
+// package's functions use its settings.
+const ServiceTag = "service"
+
+// tagged holds the loggers returned by Tagged, which share a format and output.
+// Each uses the global level, unless its tag's level has been set.
+var tagged = struct {
+	mux     sync.Mutex
+	format  LogFormat
+	output  io.Writer
+	level   LogLevel
+	loggers map[string]*ContextGoLogger
+	levels  map[string]LogLevel
+	hooks   []logrus.Hook
+}{
+	format:  TextFormat,
+	output:  os.Stderr,
+	level:   InfoLevel,
+	loggers: map[string]*ContextGoLogger{},
+	levels:  map[string]LogLevel{},
+}
+
+// Configure sets the format, output, and global level of the tagged loggers,
+// including the service's. Tags whose levels have been set keep them.
+func Configure(format LogFormat, output io.Writer, level LogLevel) {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	tagged.format = format
+	tagged.output = output
+	tagged.level = level
+	getTagged(ServiceTag)
+	for tag, l := range tagged.loggers {
+		l.SetLogFormatter(format)
+		l.SetOutput(output)
+		l.SetLogLevel(levelOf(tag))
+	}
+}
+
+// Tagged returns the logger for the given tag, creating it if necessary.
+func Tagged(tag string) *ContextGoLogger {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	return getTagged(tag)
+}
+
+// GlobalLevel returns the level of tagged loggers whose levels haven't been set.
+func GlobalLevel() LogLevel {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	return tagged.level
+}
+
+// SetGlobalLevel sets the level of tagged loggers whose levels haven't been set.
+func SetGlobalLevel(level LogLevel) {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	tagged.level = level
+	for tag, l := range tagged.loggers {
+		l.SetLogLevel(levelOf(tag))
+	}
+}
+
+// TagLevels returns the tags whose levels have been set, and their levels.
+func TagLevels() map[string]LogLevel {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	levels := make(map[string]LogLevel, len(tagged.levels))
+	for tag, level := range tagged.levels {
+		levels[tag] = level
+	}
+	return levels
+}
+
+// SetTagLevel sets the level of the tag's logger, regardless of the global level.
+func SetTagLevel(tag string, level LogLevel) {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	tagged.levels[tag] = level
+	getTagged(tag).SetLogLevel(level)
+}
+
+// ResetTagLevel returns the tag's logger to the global level.
+func ResetTagLevel(tag string) {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	delete(tagged.levels, tag)
+	if l, ok := tagged.loggers[tag]; ok {
+		l.SetLogLevel(tagged.level)
+	}
+}
+
+// RemoveTagged discards the tag's logger and level, such as when the pipeline
+// it's named after is removed. If the tag is used again, it gets a new logger,
+// which uses the global level. The service's logger can't be removed.
+func RemoveTagged(tag string) {
+	if tag == ServiceTag {
+		return
+	}
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	delete(tagged.loggers, tag)
+	delete(tagged.levels, tag)
+}
+
+// AddHook adds a hook to the tagged loggers, including those created later.
+func AddHook(hook logrus.Hook) {
+	tagged.mux.Lock()
+	defer tagged.mux.Unlock()
+	tagged.hooks = append(tagged.hooks, hook)
+	for _, l := range tagged.loggers {
+		l.logger.AddHook(hook)
+	}
+}
+
+// getTagged returns the logger for the tag, creating it if necessary; the
+// caller must hold the lock.
+func getTagged(tag string) *ContextGoLogger {
+	if l, ok := tagged.loggers[tag]; ok {
+		return l
+	}
+
+	var l *ContextGoLogger
+	if tag == ServiceTag {
+		l = &ContextGoLogger{logger: logrus.StandardLogger(), tag: tag}
+		l.SetLogFormatter(tagged.format)
+		l.SetOutput(tagged.output)
+		l.SetLogLevel(levelOf(tag))
+	} else {
+		l = New(tag, tagged.format, tagged.output, levelOf(tag))
+	}
+	for _, hook := range tagged.hooks {
+		l.logger.AddHook(hook)
+	}
+	tagged.loggers[tag] = l
+	return l
+}
+
+// levelOf returns the level of the tag's logger; the caller must hold the lock.
+func levelOf(tag string) LogLevel {
+	if level, ok := tagged.levels[tag]; ok {
+		return level
+	}
+	return tagged.level
+}
@@ -0,0 +1,103 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	for name, level := range map[string]LogLevel{
+		"debug": DebugLevel, "INFO": InfoLevel, "warn": WarnLevel,
+		"warning": WarnLevel, "error": ErrorLevel,
+	} {
+		w.As(name).ShouldBeEqual(w.ShouldHaveResult(ParseLogLevel(name)), level)
+	}
+	w.ShouldFail(ParseLogLevel("trace"))
+	w.ShouldFail(ParseLogLevel("loud"))
+
+	var level LogLevel
+	w.ShouldSucceed(json.Unmarshal([]byte(`"warn"`), &level))
+	w.ShouldBeEqual(level, WarnLevel)
+	w.ShouldBeEqual(string(w.ShouldHaveResult(json.Marshal(level)).([]byte)), `"warning"`)
+}
+
+// decodeLines decodes each line of JSON-formatted log output.
+func decodeLines(w *expect.TWrapper, buf *bytes.Buffer) []map[string]interface{} {
+	w.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		w.ShouldSucceed(json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	buf.Reset()
+	return entries
+}
+
+func TestTagged(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	buf := &bytes.Buffer{}
+	Configure(JSONFormat, buf, InfoLevel)
+	defer Configure(TextFormat, os.Stderr, InfoLevel)
+
+	sku := Tagged("SKU")
+	w.ShouldBeEqual(Tagged("SKU"), sku)
+	sku.Debug("hidden", nil)
+	sku.WithError(errors.New("oops")).Warnf("Pipeline %s failed.", "SKU")
+	logrus.WithField("Method", "main").Info("Starting Service...")
+
+	entries := decodeLines(w, buf)
+	w.ShouldHaveLength(entries, 2)
+	w.ShouldBeEqual(entries[0]["TAG"], "SKU")
+	w.ShouldBeEqual(entries[0]["msg"], "Pipeline SKU failed.")
+	w.ShouldBeEqual(entries[0]["error"], "oops")
+	w.ShouldBeEqual(entries[1]["TAG"], ServiceTag)
+	w.ShouldBeEqual(entries[1]["Method"], "main")
+
+	// a tag's level overrides the global level until it's reset
+	SetTagLevel("SKU", DebugLevel)
+	defer ResetTagLevel("SKU")
+	SetGlobalLevel(ErrorLevel)
+	w.ShouldBeEqual(GlobalLevel(), ErrorLevel)
+	w.ShouldBeEqual(TagLevels(), map[string]LogLevel{"SKU": DebugLevel})
+	sku.Debug("shown", Params{"run": "1"})
+	Tagged("ASN").Info("hidden", nil)
+	logrus.Info("hidden")
+
+	entries = decodeLines(w, buf)
+	w.ShouldHaveLength(entries, 1)
+	w.ShouldBeEqual(entries[0]["msg"], "shown")
+	w.ShouldBeEqual(entries[0]["run"], "1")
+
+	ResetTagLevel("SKU")
+	w.ShouldBeEqual(sku.LogLevel(), ErrorLevel)
+	w.ShouldBeEqual(len(TagLevels()), 0)
+	sku.Info("hidden", nil)
+	w.ShouldBeEmptyStr(buf.String())
+
+	// removed tags lose their loggers and levels
+	SetTagLevel("SKU", DebugLevel)
+	RemoveTagged("SKU")
+	w.ShouldBeEqual(len(TagLevels()), 0)
+	w.ShouldNotBeEqual(Tagged("SKU"), sku)
+	w.ShouldBeEqual(Tagged("SKU").LogLevel(), ErrorLevel)
+	RemoveTagged(ServiceTag)
+	w.ShouldBeEqual(Tagged(ServiceTag).logger, logrus.StandardLogger())
+}
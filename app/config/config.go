@@ -7,17 +7,46 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
 	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/configuration"
 	"github.com/pkg/errors"
 )
 
 // ServiceConfig holds the service configuration.
 type ServiceConfig struct {
-	ServiceName            string
-	LoggingLevel           string
+	ServiceName string
+	// LoggingLevel is the initial level of the service's and pipelines' logs:
+	// "debug", "info", "warn", or "error". It can be changed at runtime.
+	LoggingLevel string
+	// LogFormat is "text" (the default) or "json".
+	LogFormat string
+	// LogFile is a file to which logs are appended. If it's empty, they're
+	// written to stderr.
+	LogFile string
+	// LogMaxSizeMB is the size in megabytes at which the LogFile is rotated.
+	// If it's zero, its size isn't limited.
+	LogMaxSizeMB int
+	// LogMaxAge is how long the LogFile is written to before it's rotated. If
+	// it's zero, its age isn't limited.
+	LogMaxAge time.Duration
+	// LogMaxBackups is how many rotated log files are kept. If it's zero,
+	// they're all kept.
+	LogMaxBackups int
+	// LogCompress compresses rotated log files with gzip.
+	LogCompress            bool
 	TelemetryEndpoint      string
 	TelemetryDataStoreName string
-	Port                   string
+	// TelemetryUsername and TelemetryPassword are the InfluxDB credentials
+	// used to report metrics to the TelemetryEndpoint, if it requires them.
+	TelemetryUsername string
+	TelemetryPassword string
+	// TelemetryInterval is how often metrics are reported.
+	TelemetryInterval time.Duration
+	Port              string
 
 	// PipelinesDir is a directory containing pipeline configurations.
 	PipelinesDir string
@@ -35,6 +64,66 @@ type ServiceConfig struct {
 	SecretsPath string
 	// MQTTClients are files containing MQTT client configurations.
 	MQTTClients []string
+	// DataDir is a directory for data that should persist across restarts,
+	// such as which pipelines are paused. If empty, it's only kept in memory.
+	DataDir string
+	// KVStore selects the backend for the `get` and `put` tasks: "memory"
+	// (the default), "file", which stores data in the DataDir, or "consul".
+	KVStore string
+	// ConsulURL is the address of the Consul agent used by the consul KVStore.
+	ConsulURL string
+	// ConsulKVPrefix is prepended to keys stored in Consul; instances that
+	// should share data must use the same prefix.
+	ConsulKVPrefix string
+	// TLSCertFile and TLSKeyFile are the HTTP server's certificate and key.
+	// If both are set, the server uses HTTPS. Relative paths are relative to
+	// the SecretsPath.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile is a file of PEM-encoded CA certificates. If it's set,
+	// clients must present a certificate signed by one of them. A relative
+	// path is relative to the SecretsPath.
+	TLSClientCAFile string
+	// ReloadPollInterval is how often the configuration, pipelines, and
+	// templates are checked for changes, in case file system notifications
+	// aren't available or miss a change. If it's zero, they're only watched
+	// with notifications.
+	ReloadPollInterval time.Duration
+	// DrainTimeout is how long the service waits for running pipelines to
+	// complete when it's shutting down before canceling them.
+	DrainTimeout time.Duration
+	// MaxConcurrentPipelines limits how many pipelines may execute on their
+	// schedules at once. If it's zero, there's no limit.
+	MaxConcurrentPipelines int
+	// RunHistorySize is how many executions of each pipeline are kept in the
+	// run history, which is stored in the DataDir, if it's set. If it's zero,
+	// there's no limit.
+	RunHistorySize int
+	// RunHistoryMaxAge is how long executions are kept in the run history.
+	// If it's zero, they're kept until there are more than RunHistorySize.
+	RunHistoryMaxAge time.Duration
+	// PipelineLogSize is how many of the most recent log entries about each
+	// pipeline are kept in memory for the API. If it's zero, they aren't kept.
+	PipelineLogSize int
+	// ReadinessStaleAfter is how recently each pipeline that runs on a
+	// schedule must have succeeded for the service to be ready. If it's zero,
+	// readiness doesn't depend on when pipelines last succeeded.
+	ReadinessStaleAfter time.Duration
+	// TracingExporter selects where traces of pipeline executions are sent:
+	// "none" (the default) disables tracing, "otlp" sends them to the
+	// TracingEndpoint, and "file" appends them to the TracingFile.
+	TracingExporter string
+	// TracingEndpoint is the URL of an OpenTelemetry collector's OTLP/HTTP
+	// traces endpoint, such as http://otel-collector:4318/v1/traces.
+	TracingEndpoint string
+	// TracingFile is the file used by the file TracingExporter. If it's empty,
+	// traces are kept in the DataDir.
+	TracingFile string
+}
+
+// UseTLS returns true if the HTTP server should use HTTPS.
+func (sc ServiceConfig) UseTLS() bool {
+	return sc.TLSCertFile != "" && sc.TLSKeyFile != ""
 }
 
 // AppConfig exports a package-level configuration object.
@@ -42,45 +131,211 @@ var AppConfig = ServiceConfig{}
 
 // InitConfig loads package-level configuration.
 func InitConfig() error {
+	sc, err := Load()
+	if err != nil {
+		return err
+	}
+	AppConfig = sc
+	return nil
+}
+
+// Load reads the configuration without modifying AppConfig, so that it can be
+// used to check for changes while the service is running.
+func Load() (ServiceConfig, error) {
+	var sc ServiceConfig
+	var reloadPollInterval, drainTimeout, runHistoryMaxAge, telemetryInterval string
+	var readinessStaleAfter, logMaxAge string
 	config, err := configuration.NewConfiguration()
 	if err != nil {
-		return errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
+		return sc, errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
 	}
 
 	for _, required := range []struct {
 		v    *string
 		name string
 	}{
-		{v: &AppConfig.ServiceName, name: "serviceName"},
-		{v: &AppConfig.LoggingLevel, name: "loggingLevel"},
-		{v: &AppConfig.TelemetryEndpoint, name: "telemetryEndpoint"},
-		{v: &AppConfig.TelemetryDataStoreName, name: "telemetryDataStoreName"},
-		{v: &AppConfig.Port, name: "port"},
-		{v: &AppConfig.PipelinesDir, name: "pipelinesDir"},
-		{v: &AppConfig.TemplatesDir, name: "templatesDir"},
-		{v: &AppConfig.SecretsPath, name: "secretsPath"},
+		{v: &sc.ServiceName, name: "serviceName"},
+		{v: &sc.LoggingLevel, name: "loggingLevel"},
+		{v: &sc.TelemetryEndpoint, name: "telemetryEndpoint"},
+		{v: &sc.TelemetryDataStoreName, name: "telemetryDataStoreName"},
+		{v: &sc.Port, name: "port"},
+		{v: &sc.PipelinesDir, name: "pipelinesDir"},
+		{v: &sc.TemplatesDir, name: "templatesDir"},
+		{v: &sc.SecretsPath, name: "secretsPath"},
 	} {
 		s, err := config.GetString(required.name)
 		if err != nil {
-			return errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
+			return sc, errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
 		}
 		*required.v = s
 	}
 
-	AppConfig.PipelineNames, err = config.GetStringSlice("pipelineNames")
+	// optional values use their defaults when they're not set
+	for _, optional := range []struct {
+		v    *string
+		name string
+		def  string
+	}{
+		{v: &sc.LogFormat, name: "logFormat", def: "text"},
+		{v: &sc.LogFile, name: "logFile", def: ""},
+		{v: &logMaxAge, name: "logMaxAge", def: "0s"},
+		{v: &sc.TelemetryUsername, name: "telemetryUsername", def: ""},
+		{v: &sc.TelemetryPassword, name: "telemetryPassword", def: ""},
+		{v: &telemetryInterval, name: "telemetryInterval", def: "10s"},
+		{v: &sc.DataDir, name: "dataDir", def: ""},
+		{v: &sc.KVStore, name: "kvStore", def: "memory"},
+		{v: &sc.ConsulURL, name: "consulURL", def: "http://edgex-core-consul:8500"},
+		{v: &sc.ConsulKVPrefix, name: "consulKVPrefix", def: "data-provider/"},
+		{v: &sc.TLSCertFile, name: "tlsCertFile", def: ""},
+		{v: &sc.TLSKeyFile, name: "tlsKeyFile", def: ""},
+		{v: &sc.TLSClientCAFile, name: "tlsClientCAFile", def: ""},
+		{v: &reloadPollInterval, name: "reloadPollInterval", def: "10s"},
+		{v: &drainTimeout, name: "drainTimeout", def: "30s"},
+		{v: &runHistoryMaxAge, name: "runHistoryMaxAge", def: "168h"},
+		{v: &readinessStaleAfter, name: "readinessStaleAfter", def: "0s"},
+		{v: &sc.TracingExporter, name: "tracingExporter", def: "none"},
+		{v: &sc.TracingEndpoint, name: "tracingEndpoint", def: ""},
+		{v: &sc.TracingFile, name: "tracingFile", def: ""},
+	} {
+		s, err := config.GetString(optional.name)
+		if err != nil {
+			s = optional.def
+		}
+		*optional.v = s
+	}
+
+	if (sc.TLSCertFile == "") != (sc.TLSKeyFile == "") {
+		return sc, errors.New("tlsCertFile and tlsKeyFile must be set together")
+	}
+	if sc.TLSClientCAFile != "" && sc.TLSCertFile == "" {
+		return sc, errors.New("tlsClientCAFile requires tlsCertFile and tlsKeyFile")
+	}
+	for _, p := range []*string{
+		&sc.TLSCertFile, &sc.TLSKeyFile, &sc.TLSClientCAFile,
+	} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(sc.SecretsPath, *p)
+		}
+	}
+
+	switch sc.LogFormat {
+	case "text", "json":
+	default:
+		return sc, errors.Errorf("unknown logFormat %q", sc.LogFormat)
+	}
+
+	switch sc.TracingExporter {
+	case "none", "file":
+	case "otlp":
+		if sc.TracingEndpoint == "" {
+			return sc, errors.New("the otlp tracingExporter requires a tracingEndpoint")
+		}
+	default:
+		return sc, errors.Errorf("unknown tracingExporter %q", sc.TracingExporter)
+	}
+
+	for _, duration := range []struct {
+		v     *time.Duration
+		name  string
+		value string
+	}{
+		{v: &sc.ReloadPollInterval, name: "reloadPollInterval", value: reloadPollInterval},
+		{v: &sc.DrainTimeout, name: "drainTimeout", value: drainTimeout},
+		{v: &sc.RunHistoryMaxAge, name: "runHistoryMaxAge", value: runHistoryMaxAge},
+		{v: &sc.TelemetryInterval, name: "telemetryInterval", value: telemetryInterval},
+		{v: &sc.ReadinessStaleAfter, name: "readinessStaleAfter", value: readinessStaleAfter},
+		{v: &sc.LogMaxAge, name: "logMaxAge", value: logMaxAge},
+	} {
+		d, err := time.ParseDuration(duration.value)
+		if err != nil || d < 0 {
+			return sc, errors.Errorf("invalid %s %q", duration.name, duration.value)
+		}
+		*duration.v = d
+	}
+
+	// like the other optional values, this is unlimited if it's not set
+	if sc.MaxConcurrentPipelines, err = config.GetInt("maxConcurrentPipelines"); err != nil {
+		sc.MaxConcurrentPipelines = 0
+	}
+	if sc.MaxConcurrentPipelines < 0 {
+		return sc, errors.Errorf("invalid maxConcurrentPipelines %d", sc.MaxConcurrentPipelines)
+	}
+
+	if sc.TelemetryEndpoint != "" && sc.TelemetryInterval == 0 {
+		return sc, errors.New("telemetryInterval must be positive")
+	}
+
+	if sc.PipelineLogSize, err = config.GetInt("pipelineLogSize"); err != nil {
+		sc.PipelineLogSize = 200
+	}
+	if sc.PipelineLogSize < 0 {
+		return sc, errors.Errorf("invalid pipelineLogSize %d", sc.PipelineLogSize)
+	}
+
+	if sc.LogMaxSizeMB, err = config.GetInt("logMaxSizeMB"); err != nil {
+		sc.LogMaxSizeMB = 0
+	}
+	if sc.LogMaxBackups, err = config.GetInt("logMaxBackups"); err != nil {
+		sc.LogMaxBackups = 0
+	}
+	if sc.LogMaxSizeMB < 0 || sc.LogMaxBackups < 0 {
+		return sc, errors.New("logMaxSizeMB and logMaxBackups must not be negative")
+	}
+	if sc.LogCompress, err = config.GetBool("logCompress"); err != nil {
+		sc.LogCompress = false
+	}
+
+	if sc.RunHistorySize, err = config.GetInt("runHistorySize"); err != nil {
+		sc.RunHistorySize = 100
+	}
+	if sc.RunHistorySize < 0 {
+		return sc, errors.Errorf("invalid runHistorySize %d", sc.RunHistorySize)
+	}
+
+	sc.PipelineNames, err = config.GetStringSlice("pipelineNames")
 	if err != nil {
-		return errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
+		return sc, errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
 	}
 
-	AppConfig.CustomTaskTypes, err = config.GetStringSlice("customTaskTypes")
+	sc.CustomTaskTypes, err = config.GetStringSlice("customTaskTypes")
 	if err != nil {
-		return errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
+		return sc, errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
 	}
 
-	AppConfig.MQTTClients, err = config.GetStringSlice("mqttClients")
+	sc.MQTTClients, err = config.GetStringSlice("mqttClients")
 	if err != nil {
-		return errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
+		return sc, errors.Wrapf(err, "Unable to load config variables: %s", err.Error())
 	}
 
-	return nil
+	return sc, nil
+}
+
+// Path returns the path of the configuration file, or an empty string if the
+// configuration isn't loaded from a file.
+//
+// It uses the same search order as the configuration package: a
+// configuration.json next to this package's source, then the file named by the
+// runtimeConfigPath environment variable, or /run/secrets/configuration.json.
+func Path() string {
+	if _, urlOk := os.LookupEnv("consulUrl"); urlOk {
+		if _, keyOk := os.LookupEnv("consulConfigKey"); keyOk {
+			return ""
+		}
+	}
+
+	if _, filename, _, ok := runtime.Caller(0); ok {
+		local := filepath.Join(filepath.Dir(filename), "configuration.json")
+		if _, err := os.Stat(local); err == nil {
+			return local
+		}
+	}
+
+	path, ok := os.LookupEnv("runtimeConfigPath")
+	if !ok {
+		path = "/run/secrets/configuration.json"
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
 }
@@ -9,10 +9,13 @@ package config
 import (
 	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
 	"testing"
+	"time"
 )
 
 func TestInitConfig(t *testing.T) {
 	w := expect.WrapT(t).StopOnMismatch()
 	w.ShouldSucceed(InitConfig())
 	w.ShouldNotBeEmptyStr(AppConfig.ServiceName)
+	w.ShouldBeEqual(AppConfig.TelemetryInterval, 10*time.Second)
+	w.ShouldBeEqual(AppConfig.TracingExporter, "none")
 }
@@ -229,3 +229,73 @@ func TestASN(t *testing.T) {
 	w.ShouldBeEqual(ee.Readings[0].Name, "ASN_data")
 	w.ShouldNotBeEmptyStr(ee.Readings[0].Value)
 }
+
+func TestProvideEdgeXFile(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	// the file's contents come from a drop folder's "file" task; here, they
+	// come from the test data
+	const filePipeline = `{
+	  "name": "ASNFile",
+	  "tasks": {
+	    "contents": { "type": "secret", "raw": { "name": %q } },
+	    "doEdgeX": {
+	      "type": "provideEdgeXFile",
+	      "raw": {
+	        "inputs": {
+	          "deviceName": "ASN_Data_Device",
+	          "dataType": "ASN_data",
+	          "dataSchemaName": "ASNSchema.json"
+	        }
+	      },
+	      "links": { "data": { "from": "contents" } }
+	    }
+	  }
+	}`
+
+	coreData := `"ID":"3325fece-83ca-8736-bc88-bda1d9d56caf","Node":"edgex-core-consul","Address":"127.0.0.1","Datacenter":"dc1","TaggedAddresses":{"lan":"127.0.0.1","wan":"127.0.0.1"},"NodeMeta":{"consul-network-segment":""},"ServiceID":"edgex-core-data","ServiceName":"edgex-core-data","ServiceTags":[],"ServiceMeta":{},"ServiceEnableTagOverride":false,"CreateIndex":15,"ModifyIndex":15`
+	deliver := func(filename string) (map[string][]byte, error) {
+		plumber := getTestPlumber()
+		dataMap := map[string][]byte{
+			"/api/v1/event": []byte(``), // POSTed to
+		}
+		var err error
+		results := withDataServer(w, dataMap, func(addr string) {
+			serverURL := w.ShouldHaveResult(url.Parse(addr)).(*url.URL)
+			dataMap["/core-data"] = []byte(fmt.Sprintf(
+				`[{"ServicePort":"%s","ServiceAddress":"%s",%s}]`,
+				serverURL.Port(), serverURL.Hostname(), coreData))
+
+			addTaskType(w, plumber, "EdgeXEvent.json", func(config *goplumber.PipelineConfig) {
+				config.Tasks["coreDataConsulAddress"].Raw = []byte(fmt.Sprintf(`{"default": "%s/core-data"}`, addr))
+			})
+			addTaskType(w, plumber, "ProvideEdgeXFile.json", func(*goplumber.PipelineConfig) {})
+
+			pConf := &goplumber.PipelineConfig{}
+			w.ShouldSucceed(json.Unmarshal([]byte(fmt.Sprintf(filePipeline, filename)), pConf))
+			p := w.ShouldHaveResult(plumber.NewPipeline(pConf)).(*goplumber.Pipeline)
+			err = p.Execute(context.Background()).Err
+		})
+		return results, err
+	}
+
+	// data that doesn't match the schema isn't sent
+	results, err := deliver("skuData.json")
+	w.ShouldFail(err)
+	_, sent := results["/api/v1/event"]
+	w.ShouldBeFalse(sent)
+
+	results, err = deliver("asnData.json")
+	w.ShouldSucceed(err)
+
+	type edgexEvent struct {
+		Device   string
+		Readings []struct{ Name string }
+	}
+	var ee edgexEvent
+	w.ShouldContain(results, []string{"/api/v1/event"})
+	w.ShouldSucceed(json.Unmarshal(results["/api/v1/event"], &ee))
+	w.ShouldBeEqual(ee.Device, "ASN_Data_Device")
+	w.ShouldHaveLength(ee.Readings, 1)
+	w.ShouldBeEqual(ee.Readings[0].Name, "ASN_data")
+}
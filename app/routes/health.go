@@ -0,0 +1,102 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// dependencyTimeout limits how long the readiness check waits for its
+// dependencies, which are checked concurrently.
+const dependencyTimeout = 2 * time.Second
+
+// Dependency returns an error if something the pipelines rely on, such as the
+// key/value store, isn't available.
+type Dependency func(ctx context.Context) error
+
+// HealthChecks handles liveness and readiness checks.
+type HealthChecks struct {
+	Registry *scheduler.Registry
+	// StaleAfter is how recently each scheduled pipeline must have succeeded
+	// for the service to be ready; if it's zero, it isn't checked.
+	StaleAfter time.Duration
+	// Dependencies must be available for the service to be ready.
+	Dependencies map[string]Dependency
+}
+
+// DependencyStatus is the result of checking a Dependency.
+type DependencyStatus struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness describes whether the service is ready, with a breakdown by
+// pipeline and dependency.
+type Readiness struct {
+	scheduler.Readiness
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Live responds with 200 OK as long as the service can handle requests.
+//nolint: unparam
+func (h HealthChecks) Live(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	web.Respond(ctx, writer, map[string]bool{"live": true}, http.StatusOK)
+	return nil
+}
+
+// Ready responds with whether the service is ready: its pipelines must have
+// loaded, those with schedules must have succeeded recently, and its
+// dependencies must be available. If it isn't ready, the status is 503
+// Service Unavailable.
+//nolint: unparam
+func (h HealthChecks) Ready(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	rd := Readiness{
+		Readiness:    h.Registry.Readiness(h.StaleAfter, time.Now()),
+		Dependencies: h.checkDependencies(ctx),
+	}
+	for _, status := range rd.Dependencies {
+		rd.Ready = rd.Ready && status.Ready
+	}
+
+	if rd.Ready {
+		web.Respond(ctx, writer, rd, http.StatusOK)
+	} else {
+		web.Respond(ctx, writer, rd, http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+// checkDependencies checks the dependencies concurrently.
+func (h HealthChecks) checkDependencies(ctx context.Context) map[string]DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[string]DependencyStatus, len(h.Dependencies))
+	for name, check := range h.Dependencies {
+		wg.Add(1)
+		go func(name string, check Dependency) {
+			defer wg.Done()
+			status := DependencyStatus{Ready: true}
+			if err := check(ctx); err != nil {
+				status = DependencyStatus{Error: err.Error()}
+			}
+			mux.Lock()
+			statuses[name] = status
+			mux.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return statuses
+}
@@ -0,0 +1,64 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func TestHealth(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	var mqttErr error
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{
+		Dependencies: map[string]Dependency{
+			"mqtt":    func(ctx context.Context) error { return mqttErr },
+			"kvStore": func(ctx context.Context) error { return nil },
+		},
+	})
+
+	resp := doRequest(w, router, "GET", "/health/live", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+
+	// the service isn't ready until its pipelines have loaded
+	var rd Readiness
+	resp = doRequest(w, router, "GET", "/health/ready", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusServiceUnavailable)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &rd))
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeFalse(rd.Loaded)
+
+	registry.Update(nil, nil)
+	resp = doRequest(w, router, "GET", "/health/ready", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	rd = Readiness{}
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &rd))
+	w.ShouldBeTrue(rd.Ready)
+	w.ShouldBeEqual(rd.Dependencies, map[string]DependencyStatus{
+		"mqtt":    {Ready: true},
+		"kvStore": {Ready: true},
+	})
+
+	// or while a dependency is unavailable
+	mqttErr = errors.New("not connected")
+	resp = doRequest(w, router, "GET", "/health/ready", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusServiceUnavailable)
+	rd = Readiness{}
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &rd))
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeTrue(rd.Loaded)
+	w.ShouldBeEqual(rd.Dependencies["mqtt"], DependencyStatus{Error: "not connected"})
+}
@@ -0,0 +1,71 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// maxHookBodySize is the largest body accepted by Hooks.Post.
+const maxHookBodySize = 1 << 20
+
+// Hooks handles requests posted to the pipelines' webhooks.
+type Hooks struct {
+	Registry *scheduler.Registry
+}
+
+// Post executes the pipeline named in the request path with the request's body
+// and headers, and responds with the execution's ID as soon as it starts.
+//
+// Pipelines without a webhook are treated as if they don't exist, so the hooks
+// don't reveal which pipelines are loaded.
+func (h Hooks) Post(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	name := mux.Vars(request)["pipeline"]
+	e, ok := h.Registry.Get(name)
+	if !ok {
+		return errors.Wrapf(web.ErrNotFound, "no webhook for %q", name)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxHookBodySize+1))
+	if err != nil {
+		return errors.Wrap(web.ErrInvalidInput, err.Error())
+	}
+	if len(body) > maxHookBodySize {
+		return errors.Wrapf(web.ErrInvalidInput, "webhook bodies are limited to %d bytes", maxHookBodySize)
+	}
+
+	exec, err := e.Hook(scheduler.WebhookRequest{Body: body, Header: request.Header})
+	switch err {
+	case scheduler.ErrNoWebhook:
+		return errors.Wrapf(web.ErrNotFound, "no webhook for %q", name)
+	case scheduler.ErrInvalidSignature:
+		return errors.Wrap(web.ErrNotAuthorized, "the request's signature is invalid")
+	case scheduler.ErrPaused:
+		return errors.Wrapf(web.ErrConflict, "pipeline %q is paused", name)
+	case scheduler.ErrAlreadyRunning:
+		return errors.Wrapf(web.ErrConflict, "pipeline %q is already running", name)
+	case scheduler.ErrBreakerOpen:
+		return errors.Wrapf(web.ErrUnavailable, "pipeline %q's circuit breaker is open", name)
+	case scheduler.ErrDraining:
+		return errors.Wrap(web.ErrUnavailable, "the service is shutting down")
+	}
+	if err != nil {
+		return err
+	}
+
+	web.Respond(ctx, writer, exec, http.StatusAccepted)
+	return nil
+}
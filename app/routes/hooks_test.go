@@ -0,0 +1,82 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func addHookedPipeline(w *expect.TWrapper, registry *scheduler.Registry, name string, hook *scheduler.Webhook) {
+	w.Helper()
+	plumber := goplumber.NewPlumber()
+	plumber.SetClient("webhook", scheduler.WebhookClient())
+	conf := &goplumber.PipelineConfig{}
+	w.ShouldSucceed(json.Unmarshal([]byte(`{
+	  "name": "`+name+`",
+	  "tasks": { "body": { "type": "webhook" } }
+	}`), conf))
+	p := w.ShouldHaveResult(plumber.NewPipeline(conf)).(*goplumber.Pipeline)
+	w.ShouldHaveResult(registry.Add(scheduler.Definition{Config: conf, Pipeline: p, Webhook: hook}))
+}
+
+func TestHooks(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	addHookedPipeline(w, registry, "plain", nil)
+	addHookedPipeline(w, registry, "asn", &scheduler.Webhook{
+		Secret: "asnHook", Header: scheduler.DefaultSignatureHeader, Key: []byte("s3cret"),
+	})
+	addHookedPipeline(w, registry, "open", &scheduler.Webhook{})
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{})
+
+	body := `{"asn": "123"}`
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	_, _ = mac.Write([]byte(body))
+	signed := map[string]string{scheduler.DefaultSignatureHeader: "sha256=" + hex.EncodeToString(mac.Sum(nil))}
+
+	resp := doRequest(w, router, "POST", "/hooks/asn", body, signed)
+	w.ShouldBeEqual(resp.Code, http.StatusAccepted)
+	var exec struct {
+		ID string `json:"id"`
+	}
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &exec))
+	w.ShouldNotBeEmptyStr(exec.ID)
+
+	resp = doRequest(w, router, "POST", "/hooks/asn", body, nil)
+	w.ShouldBeEqual(resp.Code, http.StatusUnauthorized)
+	resp = doRequest(w, router, "POST", "/hooks/asn", `{"asn": "456"}`, signed)
+	w.ShouldBeEqual(resp.Code, http.StatusUnauthorized)
+
+	// hooks without a secret accept any request
+	resp = doRequest(w, router, "POST", "/hooks/open", body, nil)
+	w.ShouldBeEqual(resp.Code, http.StatusAccepted)
+
+	for _, missing := range []string{"/hooks/plain", "/hooks/missing"} {
+		resp = doRequest(w, router, "POST", missing, body, signed)
+		w.As(missing).ShouldBeEqual(resp.Code, http.StatusNotFound)
+	}
+
+	resp = doRequest(w, router, "POST", "/hooks/open", strings.Repeat("x", maxHookBodySize+1), nil)
+	w.ShouldBeEqual(resp.Code, http.StatusBadRequest)
+
+	e, _ := registry.Get("open")
+	w.ShouldSucceed(e.Pause())
+	resp = doRequest(w, router, "POST", "/hooks/open", body, nil)
+	w.ShouldBeEqual(resp.Code, http.StatusConflict)
+}
@@ -0,0 +1,153 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// maxValueSize is the largest value accepted by KV.Put.
+const maxValueSize = 1 << 20
+
+// KV handles requests to browse and edit the pipelines' key/value store.
+type KV struct {
+	Store store.Store
+}
+
+// KVPair is the JSON representation of a key/value store entry.
+type KVPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// List responds with the entries whose keys start with the "prefix" query
+// parameter, or every entry if it's not set, sorted by key.
+func (kv KV) List(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	entries, err := kv.Store.List(ctx, request.URL.Query().Get("prefix"))
+	if err != nil {
+		return err
+	}
+
+	pairs := make([]KVPair, 0, len(entries))
+	for k, v := range entries {
+		pairs = append(pairs, KVPair{Key: k, Value: string(v)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+	web.Respond(ctx, writer, pairs, http.StatusOK)
+	return nil
+}
+
+// Get responds with the value of the key in the request path.
+//
+// If the request accepts JSON (or any type), the response is a KVPair;
+// otherwise, the response body is the raw value.
+func (kv KV) Get(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	key := mux.Vars(request)["key"]
+	value, ok, err := kv.Store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Wrapf(web.ErrNotFound, "no value for key %q", key)
+	}
+
+	if acceptsJSON(request) {
+		web.Respond(ctx, writer, KVPair{Key: key, Value: string(value)}, http.StatusOK)
+		return nil
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.WriteHeader(http.StatusOK)
+	_, err = writer.Write(value)
+	return err
+}
+
+// Put sets the value of the key in the request path.
+//
+// If the request's Content-Type is application/json, its body must be an
+// object with a string "value"; otherwise, the body is the raw value.
+func (kv KV) Put(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	key := mux.Vars(request)["key"]
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxValueSize+1))
+	if err != nil {
+		return errors.Wrap(web.ErrInvalidInput, err.Error())
+	}
+	if len(body) > maxValueSize {
+		return errors.Wrapf(web.ErrInvalidInput, "values are limited to %d bytes", maxValueSize)
+	}
+
+	value := body
+	if mediaType(request.Header.Get("Content-Type")) == "application/json" {
+		var pair struct {
+			Value *string `json:"value"`
+		}
+		if err := json.Unmarshal(body, &pair); err != nil {
+			return errors.Wrap(web.ErrInvalidInput, err.Error())
+		}
+		if pair.Value == nil {
+			return errors.Wrap(web.ErrInvalidInput, `JSON bodies must have a string "value"`)
+		}
+		value = []byte(*pair.Value)
+	}
+
+	if err := kv.Store.Put(ctx, key, value); err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, nil, http.StatusNoContent)
+	return nil
+}
+
+// Delete removes the key in the request path.
+func (kv KV) Delete(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	key := mux.Vars(request)["key"]
+	if err := kv.Store.Delete(ctx, key); err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, nil, http.StatusNoContent)
+	return nil
+}
+
+// acceptsJSON returns true if the request's Accept header is missing or allows
+// a JSON response.
+func acceptsJSON(request *http.Request) bool {
+	accept := request.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, t := range strings.Split(accept, ",") {
+		switch mediaType(t) {
+		case "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the media type from a Content-Type or Accept value,
+// without its parameters.
+func mediaType(value string) string {
+	t, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return t
+}
@@ -0,0 +1,95 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func doRequest(w *expect.TWrapper, handler http.Handler, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	w.Helper()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestKV(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	ctx := context.Background()
+	kvStore := store.NewMemoryStore()
+	w.ShouldSucceed(kvStore.Put(ctx, "sku.lastUpdated", []byte("100")))
+	w.ShouldSucceed(kvStore.Put(ctx, "asn.lastUpdated", []byte("200")))
+	router := NewRouter(scheduler.NewRegistry(), kvStore, nil, HealthChecks{})
+
+	resp := doRequest(w, router, "GET", "/kv", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	var pairs []KVPair
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &pairs))
+	w.ShouldBeEqual(pairs, []KVPair{
+		{Key: "asn.lastUpdated", Value: "200"},
+		{Key: "sku.lastUpdated", Value: "100"},
+	})
+
+	resp = doRequest(w, router, "GET", "/kv?prefix=sku.", "", nil)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &pairs))
+	w.ShouldBeEqual(pairs, []KVPair{{Key: "sku.lastUpdated", Value: "100"}})
+
+	resp = doRequest(w, router, "GET", "/kv/sku.lastUpdated", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	var pair KVPair
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &pair))
+	w.ShouldBeEqual(pair, KVPair{Key: "sku.lastUpdated", Value: "100"})
+
+	resp = doRequest(w, router, "GET", "/kv/sku.lastUpdated", "",
+		map[string]string{"Accept": "application/octet-stream"})
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldBeEqual(resp.Body.String(), "100")
+
+	resp = doRequest(w, router, "GET", "/kv/missing", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+
+	resp = doRequest(w, router, "PUT", "/kv/nested/key", "raw value", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNoContent)
+	shouldHaveKV(w, kvStore, "nested/key", "raw value")
+
+	resp = doRequest(w, router, "PUT", "/kv/nested/key", `{"value": "0"}`,
+		map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	w.ShouldBeEqual(resp.Code, http.StatusNoContent)
+	shouldHaveKV(w, kvStore, "nested/key", "0")
+
+	resp = doRequest(w, router, "PUT", "/kv/nested/key", `0`,
+		map[string]string{"Content-Type": "application/json"})
+	w.ShouldBeEqual(resp.Code, http.StatusBadRequest)
+	shouldHaveKV(w, kvStore, "nested/key", "0")
+
+	resp = doRequest(w, router, "DELETE", "/kv/sku.lastUpdated", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNoContent)
+	_, ok, _ := kvStore.Get(ctx, "sku.lastUpdated")
+	w.ShouldBeFalse(ok)
+}
+
+func shouldHaveKV(w *expect.TWrapper, s store.Store, key, value string) {
+	w.Helper()
+	v, ok, err := s.Get(context.Background(), key)
+	w.ShouldSucceed(err)
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(string(v), value)
+}
@@ -0,0 +1,92 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// maxLogLevelSize limits the size of requests to change the log level.
+const maxLogLevelSize = 1 << 10
+
+// LogLevels handles requests to view and change the level of the service's
+// and pipelines' logs.
+type LogLevels struct {
+	Registry *scheduler.Registry
+}
+
+// LogLevelStatus is the global log level, and the levels of the pipelines
+// whose levels have been set.
+type LogLevelStatus struct {
+	Level     logger.LogLevel            `json:"level"`
+	Pipelines map[string]logger.LogLevel `json:"pipelines"`
+}
+
+// LogLevelChange sets the global log level, or the level of a pipeline's logs
+// if Pipeline is set. If a pipeline's Level is nil, it's reset to the global
+// level.
+type LogLevelChange struct {
+	Level    *logger.LogLevel `json:"level"`
+	Pipeline string           `json:"pipeline,omitempty"`
+}
+
+// Get responds with the current log levels.
+//nolint: unparam
+func (ll LogLevels) Get(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	web.Respond(ctx, writer, currentLogLevels(), http.StatusOK)
+	return nil
+}
+
+// Put changes the log level according to the LogLevelChange in the request
+// body, and responds with the resulting levels. The change lasts until the
+// service restarts, or, for the global level, until the configuration's
+// loggingLevel changes.
+func (ll LogLevels) Put(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	var change LogLevelChange
+	decoder := json.NewDecoder(io.LimitReader(request.Body, maxLogLevelSize))
+	if err := decoder.Decode(&change); err != nil {
+		return errors.Wrap(web.ErrInvalidInput, err.Error())
+	}
+
+	switch {
+	case change.Pipeline == "" && change.Level == nil:
+		return errors.Wrap(web.ErrInvalidInput, `the body must have a "level"`)
+	case change.Pipeline == "":
+		logger.SetGlobalLevel(*change.Level)
+	default:
+		e, ok := ll.Registry.Get(change.Pipeline)
+		if !ok {
+			return errors.Wrapf(web.ErrNotFound, "no pipeline named %q", change.Pipeline)
+		}
+		if change.Level == nil {
+			logger.ResetTagLevel(e.Name())
+		} else {
+			logger.SetTagLevel(e.Name(), *change.Level)
+		}
+	}
+
+	web.Respond(ctx, writer, currentLogLevels(), http.StatusOK)
+	return nil
+}
+
+// currentLogLevels returns the global log level and those of the pipelines,
+// whose loggers are tagged with their names.
+func currentLogLevels() LogLevelStatus {
+	return LogLevelStatus{
+		Level:     logger.GlobalLevel(),
+		Pipelines: logger.TagLevels(),
+	}
+}
@@ -0,0 +1,72 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func TestLogLevels(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	addHookedPipeline(w, registry, "asn", nil)
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{})
+	defer logger.SetGlobalLevel(logger.GlobalLevel())
+	defer logger.ResetTagLevel("asn")
+
+	put := func(body string) (int, LogLevelStatus) {
+		w.Helper()
+		resp := doRequest(w, router, "PUT", "/loglevel", body, nil)
+		var status LogLevelStatus
+		if resp.Code == http.StatusOK {
+			w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &status))
+		}
+		return resp.Code, status
+	}
+
+	code, status := put(`{"level": "warn"}`)
+	w.ShouldBeEqual(code, http.StatusOK)
+	w.ShouldBeEqual(status, LogLevelStatus{
+		Level: logger.WarnLevel, Pipelines: map[string]logger.LogLevel{},
+	})
+
+	code, status = put(`{"pipeline": "asn", "level": "debug"}`)
+	w.ShouldBeEqual(code, http.StatusOK)
+	w.ShouldBeEqual(status.Pipelines, map[string]logger.LogLevel{"asn": logger.DebugLevel})
+	w.ShouldBeEqual(logger.Tagged("asn").LogLevel(), logger.DebugLevel)
+
+	resp := doRequest(w, router, "GET", "/loglevel", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	var levels map[string]interface{}
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &levels))
+	w.ShouldBeEqual(levels, map[string]interface{}{
+		"level":     "warning",
+		"pipelines": map[string]interface{}{"asn": "debug"},
+	})
+
+	// a pipeline without a level uses the global level
+	code, status = put(`{"pipeline": "asn"}`)
+	w.ShouldBeEqual(code, http.StatusOK)
+	w.ShouldBeEqual(len(status.Pipelines), 0)
+	w.ShouldBeEqual(logger.Tagged("asn").LogLevel(), logger.WarnLevel)
+
+	code, _ = put(`{"pipeline": "sku", "level": "debug"}`)
+	w.ShouldBeEqual(code, http.StatusNotFound)
+	for _, invalid := range []string{`{}`, `{"level": "loud"}`, `debug`} {
+		code, _ = put(invalid)
+		w.As(invalid).ShouldBeEqual(code, http.StatusBadRequest)
+	}
+	w.ShouldBeEqual(logger.GlobalLevel(), logger.WarnLevel)
+}
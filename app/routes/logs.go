@@ -0,0 +1,173 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// streamKeepAlive is how often a comment is sent to log streams while no
+// entries are logged, so that idle connections aren't closed by proxies.
+var streamKeepAlive = 15 * time.Second
+
+// streamDuration is how long a log stream lasts before it's ended, which is
+// less than the HTTP server's WriteTimeout, so that the stream ends cleanly
+// rather than being cut off. Clients reconnect to continue it.
+var streamDuration = 10 * time.Minute
+
+// logFilter selects which captured log entries are in a response.
+type logFilter struct {
+	runID, task string
+}
+
+func newLogFilter(query url.Values) logFilter {
+	return logFilter{runID: query.Get("runId"), task: query.Get("task")}
+}
+
+func (lf logFilter) matches(entry scheduler.LogEntry) bool {
+	return (lf.runID == "" || entry.RunID == lf.runID) &&
+		(lf.task == "" || entry.Task == lf.task)
+}
+
+// Logs responds with the captured log entries of the pipeline named in the
+// request path, oldest first. The "runId" and "task" query parameters, if
+// they're set, only include entries of that execution or task, and "limit"
+// only includes that many of the newest entries.
+func (p Pipelines) Logs(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	logs, err := p.logs(request)
+	if err != nil {
+		return err
+	}
+
+	query := request.URL.Query()
+	filter := newLogFilter(query)
+	entries := logs.Entries(0)
+	matched := make([]scheduler.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return errors.Wrapf(web.ErrInvalidInput, "invalid value for limit: %q", v)
+		}
+		if limit < len(matched) {
+			matched = matched[len(matched)-limit:]
+		}
+	}
+	web.Respond(ctx, writer, matched, http.StatusOK)
+	return nil
+}
+
+// StreamLogs streams the log entries of the pipeline named in the request path
+// as Server-Sent Events, starting with those already captured, until the
+// client disconnects, the service starts shutting down, or the streamDuration
+// passes. Each event's ID is its entry's ID, so a client that reconnects with
+// a Last-Event-ID header only receives entries it missed, as long as they're
+// still captured; browsers' EventSource does so automatically. The "runId" and
+// "task" query parameters filter the entries, as they do for Logs.
+func (p Pipelines) StreamLogs(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	logs, err := p.logs(request)
+	if err != nil {
+		return err
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return errors.New("the response can't be streamed")
+	}
+
+	var after uint64
+	if v := request.Header.Get("Last-Event-ID"); v != "" {
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return errors.Wrapf(web.ErrInvalidInput, "invalid Last-Event-ID: %q", v)
+		}
+	}
+	filter := newLogFilter(request.URL.Query())
+
+	// subscribe first so no entries are missed between the backlog and the
+	// stream; those in both are skipped by their IDs
+	entries, cancel := logs.Subscribe()
+	defer cancel()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+
+	send := func(entry scheduler.LogEntry) error {
+		if entry.ID <= after {
+			return nil
+		}
+		after = entry.ID
+		if !filter.matches(entry) {
+			return nil
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "id: %d\nevent: log\ndata: %s\n\n", entry.ID, data)
+		return err
+	}
+
+	for _, entry := range logs.Entries(after) {
+		if err := send(entry); err != nil {
+			return nil // the client is gone
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	end := time.NewTimer(streamDuration)
+	defer end.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return nil
+		case <-p.Registry.Draining():
+			return nil
+		case <-end.C:
+			return nil
+		case entry := <-entries:
+			if err := send(entry); err != nil {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(writer, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// logs returns the captured logs of the pipeline named in the request path.
+func (p Pipelines) logs(request *http.Request) (*scheduler.LogBuffer, error) {
+	e, err := p.entry(request)
+	if err != nil {
+		return nil, err
+	}
+	logs := e.Logs()
+	if logs == nil {
+		return nil, errors.Wrap(web.ErrNotFound, "log capture isn't enabled")
+	}
+	return logs, nil
+}
@@ -0,0 +1,168 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func TestPipelines_Logs(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	addHookedPipeline(w, registry, "uncaptured", nil)
+	registry.CaptureLogs(10)
+	addHookedPipeline(w, registry, "asn", nil)
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{})
+
+	resp := doRequest(w, router, "GET", "/pipelines/uncaptured/logs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+	resp = doRequest(w, router, "GET", "/pipelines/sku/logs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+
+	e, _ := registry.Get("asn")
+	var runs []*scheduler.Execution
+	for i := 0; i < 2; i++ {
+		runs = append(runs, w.ShouldHaveResult(e.Run(true)).(*scheduler.Execution))
+	}
+
+	var entries []scheduler.LogEntry
+	resp = doRequest(w, router, "GET", "/pipelines/asn/logs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &entries))
+	w.ShouldHaveLength(entries, 2)
+	w.ShouldBeEqual(entries[0].RunID, runs[0].ID)
+	w.ShouldBeEqual(entries[1].RunID, runs[1].ID)
+	w.ShouldBeEqual(entries[1].Message, "Pipeline completed successfully.")
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/logs?runId="+runs[0].ID, "", nil)
+	entries = nil
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &entries))
+	w.ShouldHaveLength(entries, 1)
+	w.ShouldBeEqual(entries[0].RunID, runs[0].ID)
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/logs?limit=1", "", nil)
+	entries = nil
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &entries))
+	w.ShouldHaveLength(entries, 1)
+	w.ShouldBeEqual(entries[0].RunID, runs[1].ID)
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/logs?limit=-1", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusBadRequest)
+}
+
+func TestPipelines_StreamLogs(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	registry.CaptureLogs(10)
+	addHookedPipeline(w, registry, "asn", nil)
+	server := httptest.NewServer(NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{}))
+	defer server.Close()
+
+	e, _ := registry.Get("asn")
+	first := w.ShouldHaveResult(e.Run(true)).(*scheduler.Execution)
+
+	request := w.ShouldHaveResult(http.NewRequest("GET", server.URL+"/pipelines/asn/logs/stream", nil)).(*http.Request)
+	resp := w.ShouldHaveResult(http.DefaultClient.Do(request)).(*http.Response)
+	defer resp.Body.Close()
+	w.ShouldBeEqual(resp.StatusCode, http.StatusOK)
+	w.ShouldBeEqual(resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// readEvent returns the next event's ID and entry
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, scheduler.LogEntry) {
+		w.Helper()
+		var id string
+		var entry scheduler.LogEntry
+		for {
+			line := strings.TrimSuffix(w.ShouldHaveResult(reader.ReadString('\n')).(string), "\n")
+			switch {
+			case line == "":
+				return id, entry
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				w.ShouldSucceed(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &entry))
+			}
+		}
+	}
+
+	// the stream starts with the captured entries, then continues as more are
+	// logged
+	id, entry := readEvent()
+	w.ShouldBeEqual(id, "1")
+	w.ShouldBeEqual(entry.RunID, first.ID)
+
+	second := w.ShouldHaveResult(e.Run(true)).(*scheduler.Execution)
+	id, entry = readEvent()
+	w.ShouldBeEqual(id, "2")
+	w.ShouldBeEqual(entry.RunID, second.ID)
+
+	// reconnecting resumes after the last event received
+	request.Header.Set("Last-Event-ID", "1")
+	resumed := w.ShouldHaveResult(http.DefaultClient.Do(request)).(*http.Response)
+	defer resumed.Body.Close()
+	reader = bufio.NewReader(resumed.Body)
+	id, entry = readEvent()
+	w.ShouldBeEqual(id, "2")
+	w.ShouldBeEqual(entry.RunID, second.ID)
+
+	request.Header.Set("Last-Event-ID", "last")
+	invalid := w.ShouldHaveResult(http.DefaultClient.Do(request)).(*http.Response)
+	invalid.Body.Close()
+	w.ShouldBeEqual(invalid.StatusCode, http.StatusBadRequest)
+}
+
+func TestPipelines_StreamLogsEnd(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	registry.CaptureLogs(10)
+	addHookedPipeline(w, registry, "asn", nil)
+	server := httptest.NewServer(NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{}))
+	defer server.Close()
+
+	// waitForEnd reads the stream until the server ends it
+	waitForEnd := func(resp *http.Response) {
+		w.Helper()
+		ended := make(chan error, 1)
+		go func() {
+			_, err := ioutil.ReadAll(resp.Body)
+			ended <- err
+		}()
+		select {
+		case err := <-ended:
+			w.ShouldSucceed(err)
+		case <-time.After(5 * time.Second):
+			w.Error("timed out waiting for the stream to end")
+		}
+	}
+
+	// streams end before the server's write timeout would cut them off
+	defer func(d time.Duration) { streamDuration = d }(streamDuration)
+	streamDuration = 50 * time.Millisecond
+	resp := w.ShouldHaveResult(http.Get(server.URL + "/pipelines/asn/logs/stream")).(*http.Response)
+	defer resp.Body.Close()
+	waitForEnd(resp)
+
+	// and when the service starts shutting down
+	streamDuration = time.Hour
+	resp = w.ShouldHaveResult(http.Get(server.URL + "/pipelines/asn/logs/stream")).(*http.Response)
+	defer resp.Body.Close()
+	w.ShouldBeEmpty(registry.Drain(time.Second))
+	waitForEnd(resp)
+}
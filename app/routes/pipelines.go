@@ -0,0 +1,210 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
+)
+
+// Pipelines handles requests for information about the loaded pipelines.
+type Pipelines struct {
+	Registry *scheduler.Registry
+	// Reload reloads the configuration and pipelines.
+	Reload func() scheduler.Reload
+}
+
+// List responds with the status of every loaded pipeline.
+func (p Pipelines) List(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	entries := p.Registry.List()
+	statuses := make([]scheduler.Status, len(entries))
+	for i, e := range entries {
+		statuses[i] = e.Status()
+	}
+	web.Respond(ctx, writer, statuses, http.StatusOK)
+	return nil
+}
+
+// Get responds with the status of the pipeline named in the request path.
+func (p Pipelines) Get(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	e, err := p.entry(request)
+	if err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, e.Status(), http.StatusOK)
+	return nil
+}
+
+// Run executes the pipeline named in the request path immediately.
+//
+// By default, it responds as soon as the execution starts; if the "wait" query
+// parameter is true, it instead responds with the result once it completes.
+func (p Pipelines) Run(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	e, err := p.entry(request)
+	if err != nil {
+		return err
+	}
+
+	wait := false
+	if v := request.URL.Query().Get("wait"); v != "" {
+		wait, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Wrapf(web.ErrInvalidInput, "invalid value for wait: %q", v)
+		}
+	}
+
+	exec, err := e.Run(wait)
+	switch err {
+	case scheduler.ErrAlreadyRunning:
+		return errors.Wrapf(web.ErrConflict, "pipeline %q is already running", e.Name())
+	case scheduler.ErrDraining:
+		return errors.Wrap(web.ErrUnavailable, "the service is shutting down")
+	}
+	if err != nil {
+		return err
+	}
+
+	if wait {
+		web.Respond(ctx, writer, exec, http.StatusOK)
+	} else {
+		web.Respond(ctx, writer, exec, http.StatusAccepted)
+	}
+	return nil
+}
+
+// Pause disables the pipeline named in the request path.
+func (p Pipelines) Pause(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	e, err := p.entry(request)
+	if err != nil {
+		return err
+	}
+	if err := e.Pause(); err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, e.Status(), http.StatusOK)
+	return nil
+}
+
+// Resume re-enables the pipeline named in the request path.
+func (p Pipelines) Resume(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	e, err := p.entry(request)
+	if err != nil {
+		return err
+	}
+	if err := e.Resume(); err != nil {
+		return err
+	}
+	web.Respond(ctx, writer, e.Status(), http.StatusOK)
+	return nil
+}
+
+// Runs responds with the recorded executions of the pipeline named in the
+// request path, newest first, including those of a pipeline that's since been
+// removed. The "state" and "trigger" query parameters, if they're set, only
+// include executions with that State or Trigger, and "limit" caps the number
+// of executions in the response.
+func (p Pipelines) Runs(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	history, err := p.history()
+	if err != nil {
+		return err
+	}
+
+	name := mux.Vars(request)["name"]
+	runs := history.Runs(name)
+	if _, ok := p.Registry.Get(name); !ok && len(runs) == 0 {
+		return errors.Wrapf(web.ErrNotFound, "no pipeline named %q", name)
+	}
+
+	query := request.URL.Query()
+	limit := len(runs)
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return errors.Wrapf(web.ErrInvalidInput, "invalid value for limit: %q", v)
+		}
+	}
+	state, trigger := query.Get("state"), query.Get("trigger")
+
+	matched := make([]scheduler.Execution, 0, len(runs))
+	for _, exec := range runs {
+		if len(matched) == limit {
+			break
+		}
+		if (state == "" || exec.State.String() == state) &&
+			(trigger == "" || string(exec.Trigger) == trigger) {
+			matched = append(matched, exec)
+		}
+	}
+	web.Respond(ctx, writer, matched, http.StatusOK)
+	return nil
+}
+
+// GetRun responds with the recorded execution whose ID is in the request path.
+func (p Pipelines) GetRun(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	history, err := p.history()
+	if err != nil {
+		return err
+	}
+
+	id := mux.Vars(request)["id"]
+	exec, ok := history.Run(id)
+	if !ok {
+		return errors.Wrapf(web.ErrNotFound, "no recorded run with ID %q", id)
+	}
+	web.Respond(ctx, writer, exec, http.StatusOK)
+	return nil
+}
+
+// LastReload responds with the result of the latest attempt to load the
+// pipelines, which happens when the service starts and whenever their files
+// change.
+func (p Pipelines) LastReload(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	reload, ok := p.Registry.LastReload()
+	if !ok {
+		return errors.Wrap(web.ErrNotFound, "the pipelines haven't been loaded")
+	}
+	web.Respond(ctx, writer, reload, http.StatusOK)
+	return nil
+}
+
+// ReloadNow reloads the configuration and pipelines, and responds with the
+// pipelines that changed. If the reload fails, or any pipeline fails to load,
+// the response status is 422 Unprocessable Entity.
+func (p Pipelines) ReloadNow(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	reload := p.Reload()
+	if reload.Error != "" || len(reload.Failed) != 0 {
+		web.Respond(ctx, writer, reload, http.StatusUnprocessableEntity)
+		return nil
+	}
+	web.Respond(ctx, writer, reload, http.StatusOK)
+	return nil
+}
+
+// entry returns the registry entry for the pipeline named in the request path.
+func (p Pipelines) entry(request *http.Request) (*scheduler.Entry, error) {
+	name := mux.Vars(request)["name"]
+	e, ok := p.Registry.Get(name)
+	if !ok {
+		return nil, errors.Wrapf(web.ErrNotFound, "no pipeline named %q", name)
+	}
+	return e, nil
+}
+
+// history returns the registry's run history.
+func (p Pipelines) history() (*scheduler.History, error) {
+	history := p.Registry.History()
+	if history == nil {
+		return nil, errors.Wrap(web.ErrNotFound, "run history isn't enabled")
+	}
+	return history, nil
+}
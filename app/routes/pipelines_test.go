@@ -0,0 +1,131 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/prometheus"
+)
+
+func TestPipelines_Reload(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+
+	var loadErr error
+	reload := func() scheduler.Reload {
+		if loadErr != nil {
+			return registry.ReloadFailed(loadErr)
+		}
+		return registry.Update(nil, nil)
+	}
+	router := NewRouter(registry, store.NewMemoryStore(), reload, HealthChecks{})
+
+	resp := doRequest(w, router, "GET", "/reload", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+
+	resp = doRequest(w, router, "POST", "/reload", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	var result scheduler.Reload
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &result))
+	w.ShouldBeEmptyStr(result.Error)
+	w.ShouldBeEqual(result.Added, []string{})
+
+	loadErr = errors.New("invalid configuration")
+	resp = doRequest(w, router, "POST", "/reload", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusUnprocessableEntity)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &result))
+	w.ShouldBeEqual(result.Error, "invalid configuration")
+
+	resp = doRequest(w, router, "GET", "/reload", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &result))
+	w.ShouldBeEqual(result.Error, "invalid configuration")
+}
+
+func TestPipelines_Runs(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{})
+
+	// without a history, there are no runs
+	resp := doRequest(w, router, "GET", "/runs/123", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+
+	history := w.ShouldHaveResult(scheduler.NewHistory(store.NewMemoryStore(), 0, 0)).(*scheduler.History)
+	registry.UseHistory(history)
+	addHookedPipeline(w, registry, "asn", nil)
+	e, _ := registry.Get("asn")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		exec := w.ShouldHaveResult(e.Run(true)).(*scheduler.Execution)
+		ids = append(ids, exec.ID)
+	}
+
+	var runs []struct {
+		ID       string `json:"id"`
+		Pipeline string `json:"pipeline"`
+		Trigger  string `json:"trigger"`
+	}
+	resp = doRequest(w, router, "GET", "/pipelines/asn/runs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &runs))
+	w.ShouldBeEqual(len(runs), 3)
+	w.ShouldBeEqual(runs[0].ID, ids[2])
+	w.ShouldBeEqual(runs[0].Trigger, "api")
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/runs?limit=1&state=Success&trigger=api", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &runs))
+	w.ShouldBeEqual(len(runs), 1)
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/runs?state=Failed", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &runs))
+	w.ShouldBeEqual(len(runs), 0)
+
+	resp = doRequest(w, router, "GET", "/pipelines/asn/runs?limit=-1", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusBadRequest)
+	resp = doRequest(w, router, "GET", "/pipelines/missing/runs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusNotFound)
+
+	resp = doRequest(w, router, "GET", "/runs/"+ids[0], "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	var run struct {
+		ID       string `json:"id"`
+		Pipeline string `json:"pipeline"`
+	}
+	w.ShouldSucceed(json.Unmarshal(resp.Body.Bytes(), &run))
+	w.ShouldBeEqual(run.ID, ids[0])
+	w.ShouldBeEqual(run.Pipeline, "asn")
+
+	// runs of removed pipelines are kept
+	registry.Update(nil, nil)
+	resp = doRequest(w, router, "GET", "/pipelines/asn/runs", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+}
+
+func TestMetrics(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	registry := scheduler.NewRegistry()
+	addHookedPipeline(w, registry, "metricsRoute", nil)
+	e, _ := registry.Get("metricsRoute")
+	w.ShouldHaveResult(e.Run(true))
+	router := NewRouter(registry, store.NewMemoryStore(), nil, HealthChecks{})
+
+	resp := doRequest(w, router, "GET", "/metrics", "", nil)
+	w.ShouldBeEqual(resp.Code, http.StatusOK)
+	w.ShouldBeEqual(resp.Header().Get("Content-Type"), prometheus.ContentType)
+	w.ShouldContainStr(resp.Body.String(), `data_provider_pipeline_runs_total{pipeline="metricsRoute"} 1`)
+}
@@ -12,7 +12,10 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
 	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/middlewares"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/prometheus"
 	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/web"
 )
 
@@ -33,8 +36,22 @@ func Health(ctx context.Context, writer http.ResponseWriter, request *http.Reque
 	return nil
 }
 
-// NewRouter creates the routes for GET and POST
-func NewRouter() *mux.Router {
+// Metrics responds with the pipelines' metrics in Prometheus' text format.
+func Metrics(ctx context.Context, writer http.ResponseWriter, request *http.Request) error {
+	writer.Header().Set("Content-Type", prometheus.ContentType)
+	writer.WriteHeader(http.StatusOK)
+	return prometheus.DefaultRegistry.Write(writer)
+}
+
+// NewRouter creates the routes for GET and POST; the health checks use the
+// given registry.
+func NewRouter(registry *scheduler.Registry, kvStore store.Store, reload func() scheduler.Reload, health HealthChecks) *mux.Router {
+	health.Registry = registry
+	pipelines := Pipelines{Registry: registry, Reload: reload}
+	kv := KV{Store: kvStore}
+	hooks := Hooks{Registry: registry}
+	logLevels := LogLevels{Registry: registry}
+
 	var routes = []Route{
 		//swagger:operation GET / default Healthcheck
 		//
@@ -62,6 +79,703 @@ func NewRouter() *mux.Router {
 			"/",
 			Health,
 		},
+		//swagger:operation GET /health/live default Liveness
+		//
+		// Liveness
+		//
+		// Endpoint that is used to determine if the service is running and able to
+		// handle requests
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"Liveness",
+			"GET",
+			"/health/live",
+			health.Live,
+		},
+		//swagger:operation GET /health/ready default Readiness
+		//
+		// Readiness
+		//
+		// Endpoint that is used to determine if the service is ready: its pipelines
+		// loaded, those with schedules succeeded within the configured staleness
+		// window, and its dependencies are available. The body has a breakdown by
+		// pipeline and dependency.
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: the service is ready
+		//   '503':
+		//     description: the service isn't ready
+		//
+		{
+			"Readiness",
+			"GET",
+			"/health/ready",
+			health.Ready,
+		},
+		//swagger:operation GET /metrics default Metrics
+		//
+		// Metrics
+		//
+		// Returns the pipelines' execution counts, failure counts, durations, and
+		// last success times, along with their tasks' HTTP latency and bytes
+		// transferred, in Prometheus' text format
+		//
+		// ---
+		// produces:
+		// - text/plain
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"Metrics",
+			"GET",
+			"/metrics",
+			Metrics,
+		},
+		//swagger:operation GET /pipelines default ListPipelines
+		//
+		// List Pipelines
+		//
+		// Returns the definition and execution status of every loaded pipeline
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"ListPipelines",
+			"GET",
+			"/pipelines",
+			pipelines.List,
+		},
+		//swagger:operation GET /pipelines/{name} default GetPipeline
+		//
+		// Get Pipeline
+		//
+		// Returns the definition and execution status of a single pipeline
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//   '404':
+		//     description: no pipeline has that name
+		//
+		{
+			"GetPipeline",
+			"GET",
+			"/pipelines/{name}",
+			pipelines.Get,
+		},
+		//swagger:operation POST /pipelines/{name}/run default RunPipeline
+		//
+		// Run Pipeline
+		//
+		// Executes a pipeline immediately without changing its schedule
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		// - name: wait
+		//   in: query
+		//   description: if true, respond with the result once the execution completes
+		//   required: false
+		//   type: boolean
+		//
+		// responses:
+		//   '200':
+		//     description: the execution completed; the body has its per-task results
+		//   '202':
+		//     description: the execution started; the body has its ID
+		//   '404':
+		//     description: no pipeline has that name
+		//   '409':
+		//     description: the pipeline is already running
+		//   '503':
+		//     description: the service is shutting down
+		//
+		{
+			"RunPipeline",
+			"POST",
+			"/pipelines/{name}/run",
+			pipelines.Run,
+		},
+		//swagger:operation POST /pipelines/{name}/pause default PausePipeline
+		//
+		// Pause Pipeline
+		//
+		// Disables a pipeline, so nothing triggers it until it's resumed; the change persists across restarts when dataDir is configured
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK; the body has the pipeline's status
+		//   '404':
+		//     description: no pipeline has that name
+		//
+		{
+			"PausePipeline",
+			"POST",
+			"/pipelines/{name}/pause",
+			pipelines.Pause,
+		},
+		//swagger:operation POST /pipelines/{name}/resume default ResumePipeline
+		//
+		// Resume Pipeline
+		//
+		// Re-enables a paused pipeline
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK; the body has the pipeline's status
+		//   '404':
+		//     description: no pipeline has that name
+		//
+		{
+			"ResumePipeline",
+			"POST",
+			"/pipelines/{name}/resume",
+			pipelines.Resume,
+		},
+		//swagger:operation GET /pipelines/{name}/runs default ListPipelineRuns
+		//
+		// List Pipeline Runs
+		//
+		// Returns a pipeline's recorded executions, newest first, with their
+		// triggers and per-task results
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		// - name: state
+		//   in: query
+		//   description: only return executions with this state, such as Success
+		//   required: false
+		//   type: string
+		// - name: trigger
+		//   in: query
+		//   description: only return executions with this trigger, such as schedule
+		//   required: false
+		//   type: string
+		// - name: limit
+		//   in: query
+		//   description: the maximum number of executions to return
+		//   required: false
+		//   type: integer
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//   '400':
+		//     description: the limit is invalid
+		//   '404':
+		//     description: no pipeline has that name, and none with that name
+		//       has recorded executions
+		//
+		{
+			"ListPipelineRuns",
+			"GET",
+			"/pipelines/{name}/runs",
+			pipelines.Runs,
+		},
+		//swagger:operation GET /pipelines/{name}/logs default ListPipelineLogs
+		//
+		// List Pipeline Logs
+		//
+		// Returns the pipeline's captured log entries, oldest first, tagged with
+		// their execution's run ID and task name
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		// - name: runId
+		//   in: query
+		//   description: only return entries of this execution
+		//   required: false
+		//   type: string
+		// - name: task
+		//   in: query
+		//   description: only return entries of this task
+		//   required: false
+		//   type: string
+		// - name: limit
+		//   in: query
+		//   description: the maximum number of the newest entries to return
+		//   required: false
+		//   type: integer
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//   '400':
+		//     description: the limit is invalid
+		//   '404':
+		//     description: no pipeline has that name, or log capture isn't enabled
+		//
+		{
+			"ListPipelineLogs",
+			"GET",
+			"/pipelines/{name}/logs",
+			pipelines.Logs,
+		},
+		//swagger:operation GET /pipelines/{name}/logs/stream default StreamPipelineLogs
+		//
+		// Stream Pipeline Logs
+		//
+		// Streams the pipeline's log entries as Server-Sent Events, starting with
+		// those already captured; reconnecting with a Last-Event-ID header resumes
+		// after that entry
+		//
+		// ---
+		// produces:
+		// - text/event-stream
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: name
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		// - name: runId
+		//   in: query
+		//   description: only stream entries of this execution
+		//   required: false
+		//   type: string
+		// - name: task
+		//   in: query
+		//   description: only stream entries of this task
+		//   required: false
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: the stream of entries
+		//   '400':
+		//     description: the Last-Event-ID is invalid
+		//   '404':
+		//     description: no pipeline has that name, or log capture isn't enabled
+		//
+		{
+			"StreamPipelineLogs",
+			"GET",
+			"/pipelines/{name}/logs/stream",
+			pipelines.StreamLogs,
+		},
+		//swagger:operation GET /runs/{id} default GetRun
+		//
+		// Get Run
+		//
+		// Returns a recorded execution, with its trigger and per-task results
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: id
+		//   in: path
+		//   description: the execution's ID
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//   '404':
+		//     description: no recorded execution has that ID
+		//
+		{
+			"GetRun",
+			"GET",
+			"/runs/{id}",
+			pipelines.GetRun,
+		},
+		//swagger:operation POST /hooks/{pipeline} default PostWebhook
+		//
+		// Post Webhook
+		//
+		// Executes a pipeline with a webhook trigger, passing it the request's
+		// body and headers
+		//
+		// ---
+		// consumes:
+		// - application/json
+		// - application/octet-stream
+		//
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: pipeline
+		//   in: path
+		//   description: the pipeline's name
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '202':
+		//     description: the execution started; the body has its ID
+		//   '400':
+		//     description: the body is too large
+		//   '401':
+		//     description: the pipeline's webhook has a secret, and the request's
+		//       signature is missing or invalid
+		//   '404':
+		//     description: no pipeline with a webhook has that name
+		//   '409':
+		//     description: the pipeline is paused or already running
+		//   '503':
+		//     description: the pipeline's circuit breaker is open, or the service
+		//       is shutting down
+		//
+		{
+			"PostWebhook",
+			"POST",
+			"/hooks/{pipeline}",
+			hooks.Post,
+		},
+		//swagger:operation GET /reload default LastReload
+		//
+		// Last Reload
+		//
+		// Returns the result of the latest attempt to load the pipelines, including
+		// which were added, removed, or updated, and any errors
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"LastReload",
+			"GET",
+			"/reload",
+			pipelines.LastReload,
+		},
+		//swagger:operation POST /reload default Reload
+		//
+		// Reload
+		//
+		// Reloads the configuration and pipelines, then returns which pipelines
+		// were added, removed, or updated, and any errors
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: the pipelines were reloaded
+		//   '422':
+		//     description: the configuration or some pipelines failed to load; every
+		//       pipeline keeps its previous definition
+		//
+		{
+			"Reload",
+			"POST",
+			"/reload",
+			pipelines.ReloadNow,
+		},
+		//swagger:operation GET /loglevel default GetLogLevel
+		//
+		// Get Log Level
+		//
+		// Returns the global log level, and the levels of the pipelines whose
+		// levels have been set
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"GetLogLevel",
+			"GET",
+			"/loglevel",
+			logLevels.Get,
+		},
+		//swagger:operation PUT /loglevel default PutLogLevel
+		//
+		// Put Log Level
+		//
+		// Sets the global log level, such as {"level": "debug"}, or a pipeline's
+		// log level, such as {"pipeline": "SKU", "level": "debug"}; a pipeline
+		// without a level is reset to the global level
+		//
+		// ---
+		// consumes:
+		// - application/json
+		//
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// responses:
+		//   '200':
+		//     description: the level was changed; the body has the current levels
+		//   '400':
+		//     description: the body or level is invalid
+		//   '404':
+		//     description: no pipeline has that name
+		//
+		{
+			"PutLogLevel",
+			"PUT",
+			"/loglevel",
+			logLevels.Put,
+		},
+		//swagger:operation GET /kv default ListKV
+		//
+		// List Key/Value Entries
+		//
+		// Returns the entries in the pipelines' key/value store, sorted by key
+		//
+		// ---
+		// produces:
+		// - application/json
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: prefix
+		//   in: query
+		//   description: only return entries whose keys start with this prefix
+		//   required: false
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//
+		{
+			"ListKV",
+			"GET",
+			"/kv",
+			kv.List,
+		},
+		//swagger:operation GET /kv/{key} default GetKV
+		//
+		// Get Key/Value Entry
+		//
+		// Returns the value of a key as JSON, or as the raw value if the request
+		// doesn't accept JSON
+		//
+		// ---
+		// produces:
+		// - application/json
+		// - application/octet-stream
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: key
+		//   in: path
+		//   description: the key
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '200':
+		//     description: OK
+		//   '404':
+		//     description: the key has no value
+		//
+		{
+			"GetKV",
+			"GET",
+			"/kv/{key:.+}",
+			kv.Get,
+		},
+		//swagger:operation PUT /kv/{key} default PutKV
+		//
+		// Put Key/Value Entry
+		//
+		// Sets the value of a key; JSON bodies must be an object with a string
+		// "value", while other bodies are used as the raw value
+		//
+		// ---
+		// consumes:
+		// - application/json
+		// - application/octet-stream
+		//
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: key
+		//   in: path
+		//   description: the key
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '204':
+		//     description: the value was stored
+		//   '400':
+		//     description: the body is invalid
+		//
+		{
+			"PutKV",
+			"PUT",
+			"/kv/{key:.+}",
+			kv.Put,
+		},
+		//swagger:operation DELETE /kv/{key} default DeleteKV
+		//
+		// Delete Key/Value Entry
+		//
+		// Removes a key from the store
+		//
+		// ---
+		// schemes:
+		// - http
+		//
+		// parameters:
+		// - name: key
+		//   in: path
+		//   description: the key
+		//   required: true
+		//   type: string
+		//
+		// responses:
+		//   '204':
+		//     description: the key was removed
+		//
+		{
+			"DeleteKV",
+			"DELETE",
+			"/kv/{key:.+}",
+			kv.Delete,
+		},
 	}
 
 	router := mux.NewRouter().StrictSlash(true)
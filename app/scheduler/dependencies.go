@@ -0,0 +1,212 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Condition determines which executions of a prerequisite pipeline trigger
+// the pipelines that depend on it.
+type Condition string
+
+const (
+	// OnSuccess triggers dependents after each successful execution.
+	OnSuccess = Condition("onSuccess")
+	// OnChange triggers dependents after a successful execution whose output
+	// differs from that of the previous successful execution.
+	OnChange = Condition("onChange")
+	// Always triggers dependents after every execution, even failed ones.
+	Always = Condition("always")
+)
+
+// Dependency makes a pipeline execute after another one does.
+type Dependency struct {
+	// Pipeline is the name of the prerequisite pipeline.
+	Pipeline  string    `json:"pipeline"`
+	Condition Condition `json:"condition"`
+}
+
+// triggers returns true if an execution of the prerequisite pipeline should
+// trigger the dependent one.
+func (d Dependency) triggers(succeeded, changed bool) bool {
+	switch d.Condition {
+	case Always:
+		return true
+	case OnChange:
+		return succeeded && changed
+	default:
+		return succeeded
+	}
+}
+
+// ParseDependencies returns the Dependencies listed in the "after" of the given
+// pipeline configuration's trigger. A Dependency's condition defaults to
+// OnSuccess.
+func ParseDependencies(pipelineConf []byte) ([]Dependency, error) {
+	var tc triggerConfig
+	if err := json.Unmarshal(pipelineConf, &tc); err != nil {
+		return nil, errors.Wrap(err, "invalid trigger")
+	}
+
+	deps := tc.Trigger.After
+	seen := make(map[string]bool, len(deps))
+	for i, dep := range deps {
+		if dep.Pipeline == "" {
+			return nil, errors.New("each of the trigger's after entries must name a pipeline")
+		}
+		if seen[dep.Pipeline] {
+			return nil, errors.Errorf("the trigger lists pipeline %q more than once", dep.Pipeline)
+		}
+		seen[dep.Pipeline] = true
+
+		switch dep.Condition {
+		case "":
+			deps[i].Condition = OnSuccess
+		case OnSuccess, OnChange, Always:
+		default:
+			return nil, errors.Errorf("invalid condition %q for pipeline %q; "+
+				"it must be %q, %q, or %q", dep.Condition, dep.Pipeline, OnSuccess, OnChange, Always)
+		}
+	}
+	return deps, nil
+}
+
+// checkDependencies returns the reasons that the named candidates among the
+// definitions can't be loaded: either a pipeline they depend on isn't among
+// the definitions, or they're part of a dependency cycle.
+func checkDependencies(defs map[string]Definition, candidates map[string]bool) map[string]error {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := map[string]error{}
+	for _, name := range names {
+		if !candidates[name] {
+			continue
+		}
+		for _, dep := range defs[name].After {
+			if _, ok := defs[dep.Pipeline]; !ok {
+				problems[name] = errors.Errorf("pipeline %s runs after pipeline %q, "+
+					"which isn't loaded", name, dep.Pipeline)
+				break
+			}
+		}
+	}
+
+	// depth-first search, tracking the path to detect cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(defs))
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range defs[name].After {
+			switch state[dep.Pipeline] {
+			case unvisited:
+				if _, ok := defs[dep.Pipeline]; ok {
+					visit(dep.Pipeline)
+				}
+			case visiting:
+				start := len(path) - 1
+				for path[start] != dep.Pipeline {
+					start--
+				}
+				cycle := append(append([]string{}, path[start:]...), dep.Pipeline)
+				err := errors.Errorf("pipeline dependencies form a cycle: %s",
+					strings.Join(cycle, " -> "))
+				for _, member := range cycle {
+					if candidates[member] && problems[member] == nil {
+						problems[member] = err
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return problems
+}
+
+// removeInvalidDependencies removes the candidate definitions whose
+// dependencies are invalid, given the Registry's pipelines that are being kept
+// because their sources failed, and records the reasons in failures. The caller
+// must hold the Registry's write lock.
+//
+// A rejected candidate's existing pipeline is kept, too, so this repeats until
+// the remaining definitions are consistent.
+func (r *Registry) removeInvalidDependencies(candidates map[string]Definition, failures map[string]error) {
+	for {
+		graph := make(map[string]Definition, len(r.entries)+len(candidates))
+		for name, e := range r.entries {
+			def := e.Definition()
+			if _, failed := failures[def.Source]; failed {
+				graph[name] = def
+			}
+		}
+		names := make(map[string]bool, len(candidates))
+		for name, def := range candidates {
+			graph[name] = def
+			names[name] = true
+		}
+
+		problems := checkDependencies(graph, names)
+		if len(problems) == 0 {
+			return
+		}
+		for name, err := range problems {
+			failures[candidates[name].Source] = err
+			delete(candidates, name)
+		}
+	}
+}
+
+// runDependents triggers the pipelines that depend on the named pipeline,
+// according to the result of its execution.
+func (r *Registry) runDependents(name string, succeeded, changed bool) {
+	for _, e := range r.List() {
+		for _, dep := range e.Definition().After {
+			if dep.Pipeline == name && dep.triggers(succeeded, changed) {
+				e.fire(name)
+				break
+			}
+		}
+	}
+}
+
+// fire asks the pipeline's schedule to execute it because the named
+// prerequisite completed. If the schedule isn't running, nothing happens.
+func (e *Entry) fire(prerequisite string) {
+	e.mux.RLock()
+	running := e.stop != nil
+	e.mux.RUnlock()
+	if !running {
+		return
+	}
+
+	e.log().Debugf("Pipeline %s completed; triggering pipeline %s.", prerequisite, e.Name())
+	select {
+	case e.trigger <- struct{}{}:
+	default: // a trigger is already pending
+	}
+}
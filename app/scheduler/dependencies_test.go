@@ -0,0 +1,286 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+func TestParseDependencies(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	deps := w.ShouldHaveResult(ParseDependencies([]byte(`{}`))).([]Dependency)
+	w.ShouldHaveLength(deps, 0)
+
+	deps = w.ShouldHaveResult(ParseDependencies([]byte(`{"trigger": {"after": [
+	  {"pipeline": "SKU"},
+	  {"pipeline": "ASN", "condition": "onChange"},
+	  {"pipeline": "Cluster", "condition": "always"}
+	]}}`))).([]Dependency)
+	w.ShouldBeEqual(deps, []Dependency{
+		{Pipeline: "SKU", Condition: OnSuccess},
+		{Pipeline: "ASN", Condition: OnChange},
+		{Pipeline: "Cluster", Condition: Always},
+	})
+
+	// pipelines may only run after others, or on a schedule as well
+	sched, err := ParseTrigger([]byte(`{"trigger": {"after": [{"pipeline": "SKU"}]}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(sched)
+	w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {
+	  "interval": {"minutes": 2},
+	  "after": [{"pipeline": "SKU"}]
+	}}`)))
+
+	for _, invalid := range []string{
+		`{"trigger": {"after": [{}]}}`,
+		`{"trigger": {"after": [{"pipeline": "SKU", "condition": "sometimes"}]}}`,
+		`{"trigger": {"after": [{"pipeline": "SKU"}, {"pipeline": "SKU"}]}}`,
+		`{"trigger": {"after": "SKU"}}`,
+	} {
+		_, err := ParseDependencies([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+
+	_, err = ParseTrigger([]byte(`{"trigger": {
+	  "after": [{"pipeline": "SKU"}],
+	  "jitter": {"seconds": 1}
+	}}`))
+	w.ShouldFail(err)
+}
+
+func TestCheckDependencies(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	after := func(names ...string) Definition {
+		def := Definition{}
+		for _, name := range names {
+			def.After = append(def.After, Dependency{Pipeline: name, Condition: OnSuccess})
+		}
+		return def
+	}
+	all := map[string]bool{"a": true, "b": true, "c": true, "d": true, "e": true}
+
+	problems := checkDependencies(map[string]Definition{
+		"a": after(),
+		"b": after("a"),
+		"c": after("a", "b"),
+	}, all)
+	w.ShouldHaveLength(problems, 0)
+
+	problems = checkDependencies(map[string]Definition{
+		"a": after("c"),
+		"b": after("a"),
+		"c": after("b"),
+		"d": after("a"),
+		"e": after("e"),
+	}, all)
+	w.ShouldHaveLength(problems, 4)
+	w.ShouldContainStr(problems["a"].Error(), "a -> c -> b -> a")
+	w.ShouldBeEqual(problems["b"], problems["a"])
+	w.ShouldBeEqual(problems["c"], problems["a"])
+	w.ShouldContainStr(problems["e"].Error(), "e -> e")
+
+	// only candidates are reported
+	problems = checkDependencies(map[string]Definition{
+		"a": after("missing"),
+		"b": after("missing"),
+	}, map[string]bool{"b": true})
+	w.ShouldHaveLength(problems, 1)
+	w.ShouldContainStr(problems["b"].Error(), `"missing"`)
+}
+
+func TestRegistry_UpdateDependencies(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+	onSuccess := func(name string) Dependency {
+		return Dependency{Pipeline: name, Condition: OnSuccess}
+	}
+
+	_, err := reg.Add(newTestDefinition(w, plumber, "b.json", namedPipeline("b"), nil, withAfter(onSuccess("a"))))
+	w.ShouldFail(err)
+
+	reload := reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)),
+		newTestDefinition(w, plumber, "a.json", namedPipeline("a"), nil, withAfter(onSuccess("ok"))),
+		newTestDefinition(w, plumber, "b.json", namedPipeline("b"), nil, withAfter(onSuccess("c"))),
+		newTestDefinition(w, plumber, "c.json", namedPipeline("c"), nil, withAfter(onSuccess("b"))),
+		newTestDefinition(w, plumber, "d.json", namedPipeline("d"), nil, withAfter(onSuccess("missing"))),
+	}, nil)
+	w.ShouldBeEqual(reload.Added, []string{"a", "ok"})
+	w.ShouldHaveLength(reload.Failed, 3)
+	w.ShouldContainStr(reload.Failed["b.json"], "b -> c -> b")
+	w.ShouldContainStr(reload.Failed["c.json"], "b -> c -> b")
+	w.ShouldContainStr(reload.Failed["d.json"], `"missing"`)
+
+	a, ok := reg.Get("a")
+	w.ShouldBeTrue(ok)
+	s := a.Status()
+	w.ShouldBeEmptyStr(s.Schedule)
+	w.ShouldBeEqual(s.After, []Dependency{onSuccess("ok")})
+
+	// pipelines that would form a cycle keep their previous definitions
+	reload = reg.Update([]Definition{
+		newTestDefinition(w, plumber, "a.json", namedPipeline("a"), nil, withAfter(onSuccess("ok"))),
+		newTestDefinition(w, plumber, "ok.json", namedPipeline("ok"), nil, withAfter(onSuccess("a"))),
+	}, nil)
+	w.ShouldHaveLength(reload.Failed, 2)
+	w.ShouldContainStr(reload.Failed["ok.json"], "a -> ok -> a")
+	w.ShouldHaveLength(reg.List(), 2)
+	w.ShouldBeEqual(a.Status().After, []Dependency{onSuccess("ok")})
+	okEntry, _ := reg.Get("ok")
+	w.ShouldBeEqual(okEntry.Status().LoadError, reload.Failed["ok.json"])
+	w.ShouldHaveLength(okEntry.Status().After, 0)
+	w.ShouldNotBeEmptyStr(okEntry.Status().Schedule)
+}
+
+func TestRegistry_RunDependents(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	first := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", `{
+	  "name": "ok",
+	  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+	}`, Every(time.Hour)))).(*Entry)
+	var deps []*Entry
+	for _, cond := range []Condition{OnSuccess, OnChange, Always} {
+		name := string(cond)
+		deps = append(deps, w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, name+".json",
+			namedPipeline(name), nil, withAfter(Dependency{Pipeline: "ok", Condition: cond})))).(*Entry))
+	}
+	onSuccess, onChange, always := deps[0], deps[1], deps[2]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	// lastIDs returns the IDs of the dependents' last executions
+	lastIDs := func() (ids []string) {
+		for _, e := range deps {
+			if last := e.Status().LastExecution; last != nil {
+				ids = append(ids, last.ID)
+			} else {
+				ids = append(ids, "")
+			}
+		}
+		return ids
+	}
+	// waitForRuns waits until the given dependents have executed again.
+	waitForRuns := func(prev []string, which ...*Entry) []string {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			done := true
+			for i, e := range deps {
+				for _, target := range which {
+					if e == target && lastIDs()[i] == prev[i] {
+						done = false
+					}
+				}
+			}
+			if done {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		return lastIDs()
+	}
+
+	// the first execution's output is new
+	ids := waitForRuns([]string{"", "", ""}, onSuccess, onChange, always)
+	for _, id := range ids {
+		w.ShouldNotBeEmptyStr(id)
+	}
+	w.ShouldBeTrue(first.Status().LastExecution.OutputChanged)
+	w.ShouldBeNil(onChange.Status().NextExecution)
+
+	// the same output only triggers onSuccess and always
+	exec := w.ShouldHaveResult(first.Run(true)).(*Execution)
+	w.ShouldBeFalse(exec.OutputChanged)
+	next := waitForRuns(ids, onSuccess, always)
+	w.ShouldNotBeEqual(next[0], ids[0])
+	w.ShouldBeEqual(next[1], ids[1])
+	w.ShouldNotBeEqual(next[2], ids[2])
+	ids = next
+
+	// new output triggers all of them
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", `{
+		  "name": "ok",
+		  "tasks": { "only": { "type": "test", "raw": { "output": "bye" } } }
+		}`, Every(time.Hour)),
+		onSuccess.Definition(), onChange.Definition(), always.Definition(),
+	}, nil)
+	exec = w.ShouldHaveResult(first.Run(true)).(*Execution)
+	w.ShouldBeTrue(exec.OutputChanged)
+	next = waitForRuns(ids, onSuccess, onChange, always)
+	for i := range ids {
+		w.ShouldNotBeEqual(next[i], ids[i])
+	}
+	ids = next
+
+	// failures only trigger always
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", `{
+		  "name": "ok",
+		  "tasks": { "only": { "type": "test", "raw": { "fail": "no good" } } }
+		}`, Every(time.Hour)),
+		onSuccess.Definition(), onChange.Definition(), always.Definition(),
+	}, nil)
+	w.ShouldHaveResult(first.Run(true))
+	next = waitForRuns(ids, always)
+	w.ShouldBeEqual(next[0], ids[0])
+	w.ShouldBeEqual(next[1], ids[1])
+	w.ShouldNotBeEqual(next[2], ids[2])
+	ids = next
+
+	// and paused dependents aren't triggered
+	w.ShouldSucceed(always.Pause())
+	w.ShouldHaveResult(first.Run(true))
+	w.ShouldBeEqual(waitForRuns(ids), ids)
+}
+
+func TestRegistry_TriggersKeepSchedule(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	reg := NewRegistry()
+	history := w.ShouldHaveResult(NewHistory(store.NewMemoryStore(), 0, 0)).(*History)
+	reg.UseHistory(history)
+	plumber := getTestPlumber()
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", namedPipeline("ok"), nil)))
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "both.json", namedPipeline("both"),
+		Every(200*time.Millisecond), withAfter(Dependency{Pipeline: "ok"})))).(*Entry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	// triggers that arrive more often than the interval don't delay the schedule
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		e.fire("ok")
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var scheduled, triggered int
+	for _, run := range history.Runs("both") {
+		switch run.Trigger {
+		case TriggerSchedule:
+			scheduled++
+		case TriggerDependency:
+			triggered++
+		}
+	}
+	w.ShouldBeTrue(scheduled >= 3)
+	w.ShouldBeTrue(triggered > scheduled)
+}
@@ -0,0 +1,381 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMinAge is how long a file must go unmodified before a drop
+	// folder's pipeline processes it, unless the DropFolder sets its own.
+	DefaultMinAge = 5 * time.Second
+	// DefaultPollInterval is how often a drop folder is checked for new
+	// files, unless the DropFolder sets its own.
+	DefaultPollInterval = 10 * time.Second
+
+	// DoneDir and FailedDir are the subdirectories of a drop folder to which
+	// files are moved after the pipeline processes them.
+	DoneDir   = "done"
+	FailedDir = "failed"
+)
+
+// DropFolder makes a pipeline execute for each file delivered to a directory.
+type DropFolder struct {
+	Path string `json:"path"`
+	// Pattern is a glob, such as "*.json", that files' names must match.
+	Pattern string `json:"pattern"`
+	// MinAge is how long a file must go unmodified before it's processed, so
+	// that files still being written are left alone.
+	MinAge time.Duration `json:"-"`
+	// PollInterval is how often the directory is checked for new files.
+	PollInterval time.Duration `json:"-"`
+}
+
+type dropFolderConfig struct {
+	Path         string              `json:"path"`
+	Pattern      string              `json:"pattern"`
+	MinAge       *goplumber.Interval `json:"minAge"`
+	PollInterval *goplumber.Interval `json:"pollInterval"`
+}
+
+// DroppedFile is a file delivered to a pipeline's DropFolder.
+type DroppedFile struct {
+	Name     string
+	Contents []byte
+	modTime  time.Time
+}
+
+// unmovedFile is a file a pipeline processed, but couldn't move afterwards.
+// It's skipped, so its contents aren't processed again, until it's moved to
+// subdir or replaced by a file with a different modification time.
+type unmovedFile struct {
+	modTime time.Time
+	subdir  string
+}
+
+// ParseDropFolder returns the DropFolder described by the "dropFolder" of the
+// given pipeline configuration's trigger, or nil if it doesn't have one. It
+// must have a "path"; its "pattern" defaults to "*", and its "minAge" and
+// "pollInterval" default to DefaultMinAge and DefaultPollInterval.
+func ParseDropFolder(pipelineConf []byte) (*DropFolder, error) {
+	var tc triggerConfig
+	if err := json.Unmarshal(pipelineConf, &tc); err != nil {
+		return nil, errors.Wrap(err, "invalid trigger")
+	}
+
+	conf := tc.Trigger.DropFolder
+	if conf == nil {
+		return nil, nil
+	}
+	folder := &DropFolder{
+		Path:         filepath.Clean(conf.Path),
+		Pattern:      conf.Pattern,
+		MinAge:       DefaultMinAge,
+		PollInterval: DefaultPollInterval,
+	}
+	if folder.Pattern == "" {
+		folder.Pattern = "*"
+	}
+	if conf.MinAge != nil {
+		folder.MinAge = conf.MinAge.Duration()
+	}
+	if conf.PollInterval != nil {
+		folder.PollInterval = conf.PollInterval.Duration()
+	}
+
+	switch {
+	case conf.Path == "":
+		return nil, errors.New("the trigger's dropFolder must have a path")
+	case strings.Contains(folder.Pattern, "/"):
+		return nil, errors.Errorf("the trigger's dropFolder pattern %q "+
+			"can't match files in subdirectories", folder.Pattern)
+	case folder.MinAge < 0:
+		return nil, errors.Errorf("the trigger's dropFolder minAge "+
+			"can't be negative, not %s", folder.MinAge)
+	case folder.PollInterval <= 0:
+		return nil, errors.Errorf("the trigger's dropFolder pollInterval "+
+			"must be positive, not %s", folder.PollInterval)
+	}
+	if _, err := filepath.Match(folder.Pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid dropFolder pattern %q", folder.Pattern)
+	}
+	return folder, nil
+}
+
+// ready returns the files in the folder that are ready to be processed, oldest
+// first, as of now. Hidden files, which are often used for partial uploads,
+// and directories are ignored.
+func (folder *DropFolder) ready(now time.Time) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(folder.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []os.FileInfo
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") ||
+			now.Sub(info.ModTime()) < folder.MinAge {
+			continue
+		}
+		if matched, _ := filepath.Match(folder.Pattern, name); matched {
+			ready = append(ready, info)
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return ready[i].ModTime().Before(ready[j].ModTime())
+	})
+	return ready, nil
+}
+
+// move moves the named file into the folder's subdirectory, creating it if
+// necessary. The file's new name is prefixed with the current time, so files
+// that are delivered repeatedly with the same name don't replace each other.
+func (folder *DropFolder) move(name, subdir string) (string, error) {
+	dir := filepath.Join(folder.Path, subdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "unable to create %q", dir)
+	}
+	dest := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000Z")+"-"+name)
+	if err := os.Rename(filepath.Join(folder.Path, name), dest); err != nil {
+		return "", errors.Wrapf(err, "unable to move %q to %q", name, dir)
+	}
+	return dest, nil
+}
+
+// watchFolder checks the pipeline's DropFolder for new files, if it has one,
+// and tells its schedule when they're ready, replacing any previous watcher.
+func (e *Entry) watchFolder() {
+	e.unwatchFolder()
+
+	folder := e.Definition().DropFolder
+	if folder == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.mux.Lock()
+	e.cancelWatch = cancel
+	e.mux.Unlock()
+
+	e.log().Debugf("Pipeline %s is watching %q for files matching %q.",
+		e.Name(), folder.Path, folder.Pattern)
+	go func() {
+		ticker := time.NewTicker(folder.PollInterval)
+		defer ticker.Stop()
+		for {
+			files, err := folder.ready(time.Now())
+			if err != nil {
+				e.log().WithError(err).Warnf("Unable to check drop folder %q for pipeline %s.",
+					folder.Path, e.Name())
+			} else if len(files) != 0 {
+				e.notifyDropped()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// unwatchFolder stops checking the pipeline's DropFolder, if it has one.
+func (e *Entry) unwatchFolder() {
+	e.mux.Lock()
+	cancel := e.cancelWatch
+	e.cancelWatch = nil
+	e.mux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// notifyDropped tells the pipeline's schedule files may be ready.
+func (e *Entry) notifyDropped() {
+	select {
+	case e.dropped <- struct{}{}:
+	default: // a notification is already pending
+	}
+}
+
+// nextDroppedFile returns the oldest file in the pipeline's DropFolder that's
+// ready to be processed, or nil if there aren't any. If a file can't be read,
+// it's moved to the FailedDir. Files that were processed, but couldn't be
+// moved, are skipped, and moving them is tried again. It's only called once
+// the pipeline can execute, so files are left alone while it's paused or its
+// circuit breaker is open.
+func (e *Entry) nextDroppedFile() *DroppedFile {
+	folder := e.Definition().DropFolder
+	if folder == nil {
+		return nil
+	}
+	files, err := folder.ready(time.Now())
+	if err != nil {
+		return nil
+	}
+	e.forgetUnmoved(files)
+
+	for _, info := range files {
+		name := info.Name()
+		if e.skipUnmoved(folder, info) {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(folder.Path, name))
+		if err != nil {
+			e.log().WithError(err).Errorf("Unable to read %q from drop folder %q for pipeline %s.",
+				name, folder.Path, e.Name())
+			if _, err := folder.move(name, FailedDir); err != nil {
+				e.log().WithError(err).Error("Unable to move unreadable file.")
+			}
+			return nil
+		}
+		return &DroppedFile{Name: name, Contents: contents, modTime: info.ModTime()}
+	}
+	return nil
+}
+
+// skipUnmoved returns true if the file was processed, but couldn't be moved
+// afterwards, in which case it tries to move the file again. If the file was
+// replaced since, it returns false, so that the new file is processed.
+func (e *Entry) skipUnmoved(folder *DropFolder, info os.FileInfo) bool {
+	name := info.Name()
+	e.mux.Lock()
+	unmoved, ok := e.unmoved[name]
+	if ok && !unmoved.modTime.Equal(info.ModTime()) {
+		delete(e.unmoved, name)
+		ok = false
+	}
+	e.mux.Unlock()
+	if !ok {
+		return false
+	}
+
+	dest, err := folder.move(name, unmoved.subdir)
+	if err != nil {
+		return true
+	}
+	e.mux.Lock()
+	delete(e.unmoved, name)
+	e.mux.Unlock()
+	e.log().Infof("Moved %q to %q after pipeline %s processed it.", name, dest, e.Name())
+	return true
+}
+
+// forgetUnmoved forgets the files that couldn't be moved that are no longer
+// among those ready to be processed, such as because they were removed.
+func (e *Entry) forgetUnmoved(ready []os.FileInfo) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if len(e.unmoved) == 0 {
+		return
+	}
+	present := make(map[string]bool, len(ready))
+	for _, info := range ready {
+		present[info.Name()] = true
+	}
+	for name := range e.unmoved {
+		if !present[name] {
+			delete(e.unmoved, name)
+		}
+	}
+}
+
+// finishDroppedFile moves a file the pipeline processed to the DoneDir or,
+// if its execution failed, to the FailedDir, and returns true if it was moved.
+// If the execution was canceled, such as when the service shuts down, the file
+// is left alone, so that it's processed again later, unless the execution had
+// already succeeded. If the file can't be moved, it's skipped until it can be,
+// so that it isn't processed again.
+func (e *Entry) finishDroppedFile(ctx context.Context, file *DroppedFile, exec *Execution) bool {
+	folder := e.Definition().DropFolder
+	if folder == nil || (ctx.Err() != nil && exec.State != goplumber.Success) {
+		return false
+	}
+
+	subdir := DoneDir
+	if exec.State == goplumber.Failed {
+		subdir = FailedDir
+	}
+	dest, err := folder.move(file.Name, subdir)
+	if err != nil {
+		e.log().WithError(err).Errorf("Unable to move %q after pipeline %s processed it; "+
+			"it won't be processed again until it's moved or replaced.", file.Name, e.Name())
+		e.mux.Lock()
+		if e.unmoved == nil {
+			e.unmoved = map[string]unmovedFile{}
+		}
+		e.unmoved[file.Name] = unmovedFile{modTime: file.modTime, subdir: subdir}
+		e.mux.Unlock()
+		return false
+	}
+	e.log().Debugf("Moved %q to %q after pipeline %s processed it.", file.Name, dest, e.Name())
+	return true
+}
+
+type fileCtxKey int
+
+const fileKey fileCtxKey = 0
+
+// withDroppedFile returns a context for an execution triggered by file.
+func withDroppedFile(ctx context.Context, file *DroppedFile) context.Context {
+	return context.WithValue(ctx, fileKey, file)
+}
+
+// FileClient returns a Client for "file" tasks, which output the contents or
+// name of the file in the pipeline's drop folder that triggered its execution:
+//
+//	{"type": "file", "raw": {"field": "name"}}
+//
+// The "field" defaults to "contents". When the execution wasn't triggered by a
+// file, the task outputs its "default", if it has one, or nothing.
+func FileClient() goplumber.Client {
+	return goplumber.PipeFunc(func(task *goplumber.Task) (goplumber.Pipe, error) {
+		ft := &fileTask{Field: "contents"}
+		if len(task.Raw) != 0 {
+			if err := json.Unmarshal(task.Raw, ft); err != nil {
+				return nil, errors.Wrap(err, "failed to create file task")
+			}
+		}
+		if ft.Field != "contents" && ft.Field != "name" {
+			return nil, errors.Errorf("invalid file field %q; "+
+				"it must be \"contents\" or \"name\"", ft.Field)
+		}
+		return ft, nil
+	})
+}
+
+type fileTask struct {
+	Field   string          `json:"field"`
+	Default json.RawMessage `json:"default"`
+}
+
+func (ft *fileTask) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	file, ok := ctx.Value(fileKey).(*DroppedFile)
+	if !ok {
+		_, err := w.Write(ft.Default)
+		return err
+	}
+	if ft.Field == "name" {
+		_, err := io.WriteString(w, file.Name)
+		return err
+	}
+	_, err := w.Write(file.Contents)
+	return err
+}
@@ -0,0 +1,278 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+)
+
+// rejectPipe fails if the data linked to it is "bad".
+type rejectPipe struct{}
+
+func (rejectPipe) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	if string(links["contents"]) == "bad" {
+		return errors.New("bad contents")
+	}
+	return nil
+}
+
+// filesPipeline processes the file that triggered it, and fails if its
+// contents are "bad".
+const filesPipeline = `{
+  "name": "files",
+  "tasks": {
+    "contents": { "type": "file" },
+    "name": { "type": "file", "raw": { "field": "name" } },
+    "check": { "type": "reject", "links": { "contents": { "from": "contents" } } }
+  }
+}`
+
+// dirContents returns the names of the files in dir.
+func dirContents(w *expect.TWrapper, dir string) []string {
+	w.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	w.ShouldSucceed(err)
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names
+}
+
+// waitForFiles waits until the number of files in dir is n, since files are
+// moved after their executions are recorded, then returns their names.
+func waitForFiles(w *expect.TWrapper, dir string, n int) []string {
+	w.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if names := dirContents(w, dir); len(names) == n {
+			return names
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Errorf("timed out waiting for %d files in %q", n, dir)
+	w.FailNow()
+	return nil
+}
+
+func TestParseDropFolder(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	folder, err := ParseDropFolder([]byte(`{"trigger": {"interval": {"minutes": 1}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(folder)
+
+	folder = w.ShouldHaveResult(ParseDropFolder([]byte(`{"trigger": {"dropFolder": {
+	  "path": "/data/drop/asn/"
+	}}}`))).(*DropFolder)
+	w.ShouldBeEqual(*folder, DropFolder{Path: "/data/drop/asn", Pattern: "*",
+		MinAge: DefaultMinAge, PollInterval: DefaultPollInterval})
+
+	folder = w.ShouldHaveResult(ParseDropFolder([]byte(`{"trigger": {"dropFolder": {
+	  "path": "/data/drop/asn", "pattern": "asn-*.json",
+	  "minAge": {"seconds": 30}, "pollInterval": {"minutes": 1}
+	}}}`))).(*DropFolder)
+	w.ShouldBeEqual(*folder, DropFolder{Path: "/data/drop/asn", Pattern: "asn-*.json",
+		MinAge: 30 * time.Second, PollInterval: time.Minute})
+
+	// pipelines may only run when files are delivered
+	sched, err := ParseTrigger([]byte(`{"trigger": {"dropFolder": {"path": "/data/drop/asn"}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(sched)
+
+	for _, invalid := range []string{
+		`{"trigger": {"dropFolder": {"pattern": "*.json"}}}`,
+		`{"trigger": {"dropFolder": {"path": "/data", "pattern": "asn/*.json"}}}`,
+		`{"trigger": {"dropFolder": {"path": "/data", "pattern": "[asn"}}}`,
+		`{"trigger": {"dropFolder": {"path": "/data", "minAge": {"seconds": -1}}}}`,
+		`{"trigger": {"dropFolder": {"path": "/data", "pollInterval": {"seconds": 0}}}}`,
+		`{"trigger": {"dropFolder": "/data"}}`,
+	} {
+		_, err := ParseDropFolder([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestDropFolder_ready(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "dropfolder")).(string)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"b.json", "a.json", "c.txt", ".d.json"} {
+		path := filepath.Join(dir, name)
+		w.ShouldSucceed(ioutil.WriteFile(path, []byte("{}"), 0644))
+		modTime := now.Add(time.Duration(i-10) * time.Minute)
+		w.ShouldSucceed(os.Chtimes(path, modTime, modTime))
+	}
+	w.ShouldSucceed(os.Mkdir(filepath.Join(dir, "e.json"), 0755))
+
+	folder := &DropFolder{Path: dir, Pattern: "*.json", MinAge: time.Minute}
+	// names returns the names of the files that are ready at the given time
+	names := func(at time.Time) []string {
+		w.Helper()
+		var names []string
+		for _, info := range w.ShouldHaveResult(folder.ready(at)).([]os.FileInfo) {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+	w.ShouldBeEqual(names(now), []string{"b.json", "a.json"})
+
+	// files modified too recently are left alone
+	w.ShouldBeEqual(names(now.Add(-8*time.Minute-30*time.Second)), []string{"b.json"})
+
+	folder.Path = filepath.Join(dir, "missing")
+	w.ShouldHaveError(folder.ready(now))
+}
+
+func TestRegistry_DropFolder(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "dropfolder")).(string)
+	defer os.RemoveAll(dir)
+
+	folder := &DropFolder{Path: dir, Pattern: "*.json", PollInterval: 20 * time.Millisecond}
+	plumber := getTestPlumber(testClient{"file", FileClient()}, pipeClient("reject", rejectPipe{}))
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "files.json", filesPipeline, nil,
+		withDropFolder(folder)))).(*Entry)
+	w.ShouldBeEqual(e.Status().DropFolder.Path, dir)
+
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "asn.json"), []byte(`{"asn": 1}`), 0644))
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`ignored`), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	// processed files are moved to the done folder
+	exec := waitForExecution(w, e, nil)
+	w.ShouldBeEqual(exec.File, "asn.json")
+	w.ShouldBeEqual(exec.State.String(), "Success")
+	done := waitForFiles(w, filepath.Join(dir, DoneDir), 1)
+	w.ShouldBeEqual(dirContents(w, dir), []string{"notes.txt"})
+	w.ShouldContainStr(done[0], "-asn.json")
+
+	// and those that fail are moved to the failed folder
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`bad`), 0644))
+	exec = waitForExecution(w, e, exec)
+	w.ShouldBeEqual(exec.File, "bad.json")
+	w.ShouldBeEqual(exec.State.String(), "Failed")
+	waitForFiles(w, filepath.Join(dir, FailedDir), 1)
+
+	// on-demand executions have no file
+	run := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldBeEmptyStr(run.File)
+
+	// files are left alone while the pipeline is paused
+	w.ShouldSucceed(e.Pause())
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "asn2.json"), []byte(`{"asn": 2}`), 0644))
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeEqual(e.Status().LastExecution.ID, run.ID)
+	w.ShouldBeEqual(dirContents(w, dir), []string{"asn2.json", "notes.txt"})
+
+	w.ShouldSucceed(e.Resume())
+	exec = waitForExecution(w, e, run)
+	w.ShouldBeEqual(exec.File, "asn2.json")
+	waitForFiles(w, filepath.Join(dir, DoneDir), 2)
+
+	// removing the pipeline stops watching the folder
+	reg.Update(nil, nil)
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "asn3.json"), []byte(`{"asn": 3}`), 0644))
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeEqual(dirContents(w, dir), []string{"asn3.json", "notes.txt"})
+}
+
+func TestRegistry_DropFolderWhileRunning(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "dropfolder")).(string)
+	defer os.RemoveAll(dir)
+
+	block := make(blockingPipe)
+	plumber := getTestPlumber(testClient{"file", FileClient()}, pipeClient("block", block))
+	folder := &DropFolder{Path: dir, Pattern: "*.json", PollInterval: 20 * time.Millisecond}
+
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "slow.json", `{
+	  "name": "slow",
+	  "tasks": {
+	    "name": { "type": "file", "raw": { "field": "name" } },
+	    "wait": { "type": "block" }
+	  }
+	}`, nil, withDropFolder(folder)))).(*Entry)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	// a file delivered while the pipeline is run on demand waits for it
+	onDemand := w.ShouldHaveResult(e.Run(false)).(*Execution)
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "asn.json"), []byte(`{"asn": 1}`), 0644))
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeNil(e.Status().LastExecution)
+	w.ShouldBeEqual(dirContents(w, dir), []string{"asn.json"})
+
+	// then it's processed and moved
+	close(block)
+	exec := waitForExecution(w, e, onDemand)
+	w.ShouldBeEqual(exec.Trigger, TriggerDropFolder)
+	w.ShouldBeEqual(exec.File, "asn.json")
+	waitForFiles(w, filepath.Join(dir, DoneDir), 1)
+}
+
+func TestRegistry_DropFolderUnmoved(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "dropfolder")).(string)
+	defer os.RemoveAll(dir)
+
+	// a file in the done folder's place keeps processed files from being moved
+	blocker := filepath.Join(dir, DoneDir)
+	w.ShouldSucceed(ioutil.WriteFile(blocker, nil, 0644))
+
+	folder := &DropFolder{Path: dir, Pattern: "*.json", PollInterval: 20 * time.Millisecond}
+	plumber := getTestPlumber(testClient{"file", FileClient()}, pipeClient("reject", rejectPipe{}))
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "files.json", filesPipeline, nil,
+		withDropFolder(folder)))).(*Entry)
+	path := filepath.Join(dir, "asn.json")
+	w.ShouldSucceed(ioutil.WriteFile(path, []byte(`{"asn": 1}`), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	// the file isn't processed again while it can't be moved
+	exec := waitForExecution(w, e, nil)
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeEqual(e.Status().LastExecution.ID, exec.ID)
+	w.ShouldBeEqual(dirContents(w, dir), []string{"asn.json", DoneDir})
+
+	// unless it's replaced
+	modTime := time.Now().Add(-time.Minute)
+	w.ShouldSucceed(os.Chtimes(path, modTime, modTime))
+	exec = waitForExecution(w, e, exec)
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeEqual(e.Status().LastExecution.ID, exec.ID)
+
+	// and it's moved once it can be
+	w.ShouldSucceed(os.Remove(blocker))
+	waitForFiles(w, filepath.Join(dir, DoneDir), 1)
+	w.ShouldBeEqual(e.Status().LastExecution.ID, exec.ID)
+}
@@ -0,0 +1,104 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Readiness describes whether the Registry's pipelines are ready: whether
+// they all loaded, and whether those with schedules have succeeded recently.
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Loaded is true if the pipelines have loaded, and none failed to load.
+	Loaded bool `json:"loaded"`
+	// Draining is true if the service is shutting down.
+	Draining bool `json:"draining,omitempty"`
+	// Failed maps the files of pipelines that failed to load to the reason.
+	Failed    map[string]string   `json:"failed,omitempty"`
+	Pipelines []PipelineReadiness `json:"pipelines"`
+}
+
+// PipelineReadiness describes whether a single pipeline is ready.
+type PipelineReadiness struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	// Reason explains why the pipeline isn't ready, or why its freshness
+	// isn't checked.
+	Reason      string     `json:"reason,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// Readiness returns whether the pipelines are ready at the given time.
+//
+// The pipelines are ready once they've loaded, until the Registry starts
+// draining, as long as none failed to load and each pipeline that runs on a
+// schedule has succeeded within staleAfter. A pipeline that hasn't succeeded
+// since it was added has until staleAfter after then. Paused pipelines and
+// those without schedules are always ready, as is every pipeline if
+// staleAfter is zero.
+func (r *Registry) Readiness(staleAfter time.Duration, now time.Time) Readiness {
+	r.mux.RLock()
+	rd := Readiness{
+		Loaded:    r.lastReload != nil,
+		Draining:  r.draining,
+		Pipelines: make([]PipelineReadiness, 0, len(r.entries)),
+	}
+	if r.lastReload != nil && len(r.lastReload.Failed) != 0 {
+		rd.Loaded = false
+		rd.Failed = r.lastReload.Failed
+	}
+	for _, e := range r.entries {
+		rd.Pipelines = append(rd.Pipelines, e.readiness(staleAfter, now))
+	}
+	r.mux.RUnlock()
+
+	sort.Slice(rd.Pipelines, func(i, j int) bool {
+		return rd.Pipelines[i].Name < rd.Pipelines[j].Name
+	})
+	rd.Ready = rd.Loaded && !rd.Draining
+	for _, pr := range rd.Pipelines {
+		rd.Ready = rd.Ready && pr.Ready
+	}
+	return rd
+}
+
+// readiness returns whether the pipeline is ready at the given time.
+func (e *Entry) readiness(staleAfter time.Duration, now time.Time) PipelineReadiness {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	pr := PipelineReadiness{Name: e.name, Ready: true}
+	if !e.lastOK.IsZero() {
+		lastOK := e.lastOK
+		pr.LastSuccess = &lastOK
+	}
+
+	switch {
+	case e.loadError != "":
+		pr.Ready = false
+		pr.Reason = "its latest definition failed to load: " + e.loadError
+	case e.paused:
+		pr.Reason = "paused"
+	case e.def.Schedule == nil:
+		pr.Reason = "not scheduled"
+	case staleAfter == 0:
+	case e.lastOK.IsZero():
+		if now.Sub(e.added) > staleAfter {
+			pr.Ready = false
+			pr.Reason = fmt.Sprintf("it hasn't succeeded since it was loaded %s ago",
+				now.Sub(e.added).Round(time.Second))
+		}
+	case now.Sub(e.lastOK) > staleAfter:
+		pr.Ready = false
+		pr.Reason = fmt.Sprintf("it last succeeded %s ago",
+			now.Sub(e.lastOK).Round(time.Second))
+	}
+	return pr
+}
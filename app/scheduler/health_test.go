@@ -0,0 +1,91 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+)
+
+func TestRegistry_Readiness(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	// the pipelines aren't ready until they've loaded
+	rd := reg.Readiness(time.Minute, time.Now())
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeFalse(rd.Loaded)
+
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)),
+		newTestDefinition(w, plumber, "fail.json", failPipeline, nil),
+	}, nil)
+	start := time.Now()
+	rd = reg.Readiness(time.Minute, start)
+	w.ShouldBeTrue(rd.Ready)
+	w.ShouldBeTrue(rd.Loaded)
+	w.ShouldBeEqual(rd.Pipelines, []PipelineReadiness{
+		{Name: "fail", Ready: true, Reason: "not scheduled"},
+		{Name: "ok", Ready: true},
+	})
+
+	// a scheduled pipeline that hasn't succeeded since it was added is stale
+	// once the time allowed has passed
+	later := start.Add(2 * time.Minute)
+	rd = reg.Readiness(time.Minute, later)
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeFalse(rd.Pipelines[1].Ready)
+	w.ShouldContainStr(rd.Pipelines[1].Reason, "hasn't succeeded")
+	w.ShouldBeTrue(reg.Readiness(0, later).Ready)
+
+	ok, _ := reg.Get("ok")
+	exec := w.ShouldHaveResult(ok.Run(true)).(*Execution)
+	fail, _ := reg.Get("fail")
+	w.ShouldHaveResult(fail.Run(true))
+	rd = reg.Readiness(time.Minute, exec.CompletedAt.Add(time.Second))
+	w.ShouldBeTrue(rd.Ready)
+	w.ShouldBeEqual(*rd.Pipelines[1].LastSuccess, exec.CompletedAt)
+	w.ShouldBeNil(rd.Pipelines[0].LastSuccess)
+	w.ShouldBeEqual(*ok.Status().LastSuccess, exec.CompletedAt)
+
+	rd = reg.Readiness(time.Minute, exec.CompletedAt.Add(2*time.Minute))
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeEqual(rd.Pipelines[1].Reason, "it last succeeded 2m0s ago")
+
+	// paused pipelines are always ready
+	w.ShouldSucceed(ok.Pause())
+	rd = reg.Readiness(time.Minute, exec.CompletedAt.Add(2*time.Minute))
+	w.ShouldBeTrue(rd.Ready)
+	w.ShouldBeEqual(rd.Pipelines[1].Reason, "paused")
+	w.ShouldSucceed(ok.Resume())
+
+	// pipelines that fail to reload aren't
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)),
+	}, map[string]error{"fail.json": errors.New("bad definition")})
+	rd = reg.Readiness(0, time.Now())
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeFalse(rd.Loaded)
+	w.ShouldBeEqual(rd.Failed, map[string]string{"fail.json": "bad definition"})
+	w.ShouldBeFalse(rd.Pipelines[0].Ready)
+	w.ShouldContainStr(rd.Pipelines[0].Reason, "bad definition")
+
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)),
+	}, nil)
+	w.ShouldBeTrue(reg.Readiness(0, time.Now()).Ready)
+
+	// nor is a Registry that's draining
+	w.ShouldBeNil(reg.Drain(time.Second))
+	rd = reg.Readiness(0, time.Now())
+	w.ShouldBeFalse(rd.Ready)
+	w.ShouldBeTrue(rd.Draining)
+}
@@ -0,0 +1,180 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+// History keeps the completed executions of every pipeline, including their
+// per-task results, in a Store, so they can be queried after the fact.
+//
+// It's bounded: each pipeline keeps at most MaxRuns executions, and those that
+// completed more than MaxAge ago are discarded. Executions of pipelines that
+// are removed are kept until they age out.
+type History struct {
+	store   store.Store
+	maxRuns int           // per pipeline; if it's not positive, there's no limit
+	maxAge  time.Duration // if it's zero, there's no limit
+
+	mux  sync.RWMutex
+	runs map[string][]*Execution // by pipeline, oldest first
+	byID map[string]*Execution
+}
+
+// NewHistory returns a History that keeps up to maxRuns executions of each
+// pipeline for up to maxAge, loading any already in the Store. If maxRuns
+// isn't positive or maxAge is zero, that limit doesn't apply.
+//
+// Executions are stored with their IDs as keys, so the Store shouldn't be used
+// for anything else.
+func NewHistory(s store.Store, maxRuns int, maxAge time.Duration) (*History, error) {
+	h := &History{
+		store:   s,
+		maxRuns: maxRuns,
+		maxAge:  maxAge,
+		runs:    map[string][]*Execution{},
+		byID:    map[string]*Execution{},
+	}
+
+	stored, err := s.List(context.Background(), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load run history")
+	}
+	for id, data := range stored {
+		exec := &Execution{}
+		if err := json.Unmarshal(data, exec); err != nil {
+			log.WithError(err).Warnf("Discarding unreadable run %s from the history.", id)
+			h.delete(id)
+			continue
+		}
+		h.runs[exec.Pipeline] = append(h.runs[exec.Pipeline], exec)
+		h.byID[exec.ID] = exec
+	}
+	for name, runs := range h.runs {
+		sort.Slice(runs, func(i, j int) bool {
+			return runs[i].CompletedAt.Before(runs[j].CompletedAt)
+		})
+		h.prune(name, time.Now())
+	}
+	return h, nil
+}
+
+// UseHistory makes the Registry add every completed execution to h.
+//
+// It should be called before the Registry is started.
+func (r *Registry) UseHistory(h *History) {
+	r.mux.Lock()
+	r.history = h
+	r.mux.Unlock()
+}
+
+// History returns the Registry's History, or nil if it doesn't have one.
+func (r *Registry) History() *History {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.history
+}
+
+// Runs returns the recorded executions of the named pipeline, newest first.
+func (h *History) Runs(pipeline string) []Execution {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	runs := h.runs[pipeline]
+	result := make([]Execution, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		if h.expired(runs[i], time.Now()) {
+			break
+		}
+		result = append(result, *runs[i])
+	}
+	return result
+}
+
+// Run returns the recorded execution with the given ID.
+func (h *History) Run(id string) (Execution, bool) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	exec, ok := h.byID[id]
+	if !ok || h.expired(exec, time.Now()) {
+		return Execution{}, false
+	}
+	return *exec, true
+}
+
+// add records a completed execution and discards those that exceed the
+// limits. Failing to store it is logged, but otherwise ignored, so that it
+// doesn't affect the pipeline.
+func (h *History) add(exec Execution) {
+	data, err := json.Marshal(exec)
+	if err != nil {
+		log.WithError(err).Errorf("Unable to encode run %s of pipeline %s for the history.",
+			exec.ID, exec.Pipeline)
+		return
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if err := h.store.Put(context.Background(), exec.ID, data); err != nil {
+		log.WithError(err).Errorf("Unable to store run %s of pipeline %s in the history.",
+			exec.ID, exec.Pipeline)
+		return
+	}
+	h.runs[exec.Pipeline] = append(h.runs[exec.Pipeline], &exec)
+	h.byID[exec.ID] = &exec
+	h.prune(exec.Pipeline, time.Now())
+}
+
+// prune discards the named pipeline's oldest executions that exceed the
+// limits as of now. The caller must hold the write lock.
+func (h *History) prune(pipeline string, now time.Time) {
+	runs := h.runs[pipeline]
+	drop := 0
+	if h.maxRuns > 0 && len(runs) > h.maxRuns {
+		drop = len(runs) - h.maxRuns
+	}
+	for drop < len(runs) && h.expired(runs[drop], now) {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+
+	for _, exec := range runs[:drop] {
+		delete(h.byID, exec.ID)
+		h.delete(exec.ID)
+	}
+	if drop == len(runs) {
+		delete(h.runs, pipeline)
+	} else {
+		h.runs[pipeline] = append([]*Execution(nil), runs[drop:]...)
+	}
+}
+
+// delete removes the execution with the given ID from the Store.
+func (h *History) delete(id string) {
+	if err := h.store.Delete(context.Background(), id); err != nil {
+		log.WithError(err).Warnf("Unable to remove run %s from the history.", id)
+	}
+}
+
+// expired returns true if the execution completed longer than maxAge before
+// now.
+func (h *History) expired(exec *Execution, now time.Time) bool {
+	return h.maxAge > 0 && now.Sub(exec.CompletedAt) > h.maxAge
+}
@@ -0,0 +1,132 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+)
+
+// completedRun returns a successful Execution of the named pipeline that
+// completed at the given time.
+func completedRun(id, pipeline string, completed time.Time) Execution {
+	return Execution{
+		ID:          id,
+		Pipeline:    pipeline,
+		Trigger:     TriggerSchedule,
+		State:       goplumber.Success,
+		StartedAt:   completed.Add(-time.Second),
+		CompletedAt: completed,
+		Tasks: []TaskResult{
+			{Name: "send", Type: "http", State: goplumber.Success, OutputSize: 42},
+		},
+	}
+}
+
+// runIDs returns the IDs of the executions.
+func runIDs(runs []Execution) []string {
+	ids := make([]string, len(runs))
+	for i, exec := range runs {
+		ids[i] = exec.ID
+	}
+	return ids
+}
+
+func TestHistory(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "history")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "runs.log")
+
+	fs := w.ShouldHaveResult(store.OpenFileStore(path)).(*store.FileStore)
+	h := w.ShouldHaveResult(NewHistory(fs, 3, time.Hour)).(*History)
+	w.ShouldBeEqual(h.Runs("SKU"), []Execution{})
+
+	now := time.Now().UTC()
+	h.add(completedRun("expired", "SKU", now.Add(-2*time.Hour)))
+	for i, id := range []string{"sku1", "sku2", "sku3", "sku4"} {
+		h.add(completedRun(id, "SKU", now.Add(time.Duration(i-10)*time.Minute)))
+	}
+	h.add(completedRun("asn1", "ASN", now))
+
+	// each pipeline keeps its newest runs, up to the limit
+	w.ShouldBeEqual(runIDs(h.Runs("SKU")), []string{"sku4", "sku3", "sku2"})
+	w.ShouldBeEqual(runIDs(h.Runs("ASN")), []string{"asn1"})
+	_, ok := h.Run("sku1")
+	w.ShouldBeFalse(ok)
+	_, ok = h.Run("expired")
+	w.ShouldBeFalse(ok)
+
+	exec, ok := h.Run("sku3")
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(exec.Pipeline, "SKU")
+	w.ShouldBeEqual(exec.Tasks[0].OutputSize, int64(42))
+
+	// the history survives restarts
+	w.ShouldSucceed(fs.Close())
+	fs = w.ShouldHaveResult(store.OpenFileStore(path)).(*store.FileStore)
+	defer fs.Close()
+	h = w.ShouldHaveResult(NewHistory(fs, 2, time.Hour)).(*History)
+	w.ShouldBeEqual(runIDs(h.Runs("SKU")), []string{"sku4", "sku3"})
+	exec, ok = h.Run("asn1")
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(exec.State, goplumber.Success)
+	w.ShouldBeEqual(exec.Trigger, TriggerSchedule)
+	w.ShouldBeEqual(exec.CompletedAt.Equal(now), true)
+	w.ShouldBeEqual(exec.Tasks[0].State, goplumber.Success)
+
+	// and what's discarded is removed from the store
+	stored := w.ShouldHaveResult(fs.List(context.Background(), "")).(map[string][]byte)
+	w.ShouldBeEqual(len(stored), 3)
+}
+
+func TestRegistry_History(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	h := w.ShouldHaveResult(NewHistory(store.NewMemoryStore(), 0, 0)).(*History)
+	reg := NewRegistry()
+	reg.UseHistory(h)
+	w.ShouldBeEqual(reg.History(), h)
+
+	plumber := getTestPlumber()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", okPipeline, nil))).(*Entry)
+
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldBeEqual(exec.Pipeline, "ok")
+	w.ShouldBeEqual(exec.Trigger, TriggerAPI)
+
+	runs := h.Runs("ok")
+	w.ShouldBeEqual(len(runs), 1)
+	w.ShouldBeEqual(runs[0].ID, exec.ID)
+	w.ShouldBeEqual(runs[0].Trigger, TriggerAPI)
+	sizes := map[string]int64{}
+	for _, task := range runs[0].Tasks {
+		sizes[task.Name] = task.OutputSize
+	}
+	w.ShouldBeEqual(sizes, map[string]int64{"first": 5, "second": 5})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.ShouldBeEmptyStr(reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(20*time.Millisecond)),
+	}, nil).Error)
+	reg.Start(ctx)
+
+	scheduled := waitForExecution(w, e, exec)
+	w.ShouldBeEqual(scheduled.Trigger, TriggerSchedule)
+	recorded, ok := h.Run(scheduled.ID)
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(recorded.Trigger, TriggerSchedule)
+}
@@ -0,0 +1,192 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+)
+
+// subscriberBuffer is how many entries a log subscriber may fall behind
+// before it misses them.
+const subscriberBuffer = 64
+
+// LogEntry is a message logged about a pipeline.
+type LogEntry struct {
+	// ID increases with each entry logged about the pipeline.
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Level    string    `json:"level"`
+	Message  string    `json:"message"`
+	Pipeline string    `json:"pipeline"`
+	RunID    string    `json:"runId,omitempty"`
+	Task     string    `json:"task,omitempty"`
+	// Fields has the entry's other fields, such as its error.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LogBuffer keeps a pipeline's most recent log entries, and sends new entries
+// to its subscribers.
+type LogBuffer struct {
+	mux         sync.Mutex
+	entries     []LogEntry // a ring; once full, the oldest is at entries[(next-1)%size]
+	size        int
+	next        uint64 // the ID of the next entry
+	subscribers map[chan LogEntry]struct{}
+}
+
+func newLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{
+		entries:     make([]LogEntry, 0, size),
+		size:        size,
+		next:        1,
+		subscribers: map[chan LogEntry]struct{}{},
+	}
+}
+
+// add assigns the entry its ID, keeps it, replacing the oldest entry if the
+// buffer is full, and sends it to the subscribers. Subscribers that aren't
+// keeping up miss it, rather than delaying the pipeline.
+func (lb *LogBuffer) add(entry LogEntry) {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	entry.ID = lb.next
+	lb.next++
+	if len(lb.entries) < lb.size {
+		lb.entries = append(lb.entries, entry)
+	} else {
+		lb.entries[(entry.ID-1)%uint64(lb.size)] = entry
+	}
+
+	for ch := range lb.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// Entries returns the kept entries whose IDs are greater than after, oldest
+// first.
+func (lb *LogBuffer) Entries(after uint64) []LogEntry {
+	lb.mux.Lock()
+	defer lb.mux.Unlock()
+	entries := make([]LogEntry, 0, len(lb.entries))
+	for i := range lb.entries {
+		entry := lb.entries[i]
+		if len(lb.entries) == lb.size {
+			entry = lb.entries[(lb.next-1+uint64(i))%uint64(lb.size)]
+		}
+		if entry.ID > after {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Subscribe returns a channel that receives entries as they're logged, and a
+// function that unsubscribes it.
+func (lb *LogBuffer) Subscribe() (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, subscriberBuffer)
+	lb.mux.Lock()
+	lb.subscribers[ch] = struct{}{}
+	lb.mux.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			lb.mux.Lock()
+			delete(lb.subscribers, ch)
+			lb.mux.Unlock()
+		})
+	}
+}
+
+// CaptureLogs keeps the most recent entries logged about each pipeline,
+// including those of their executions and tasks, in a LogBuffer of the given
+// size. It only affects pipelines added afterwards, so it should be called
+// before the pipelines are loaded. Entries are only captured if they're
+// enabled by their pipeline's log level.
+func (r *Registry) CaptureLogs(size int) {
+	captureOnce.Do(func() {
+		logger.AddHook(captureHook{})
+	})
+	r.mux.Lock()
+	r.logSize = size
+	r.mux.Unlock()
+}
+
+// Logs returns the pipeline's LogBuffer, or nil if its logs aren't captured.
+func (e *Entry) Logs() *LogBuffer {
+	return e.logs
+}
+
+// captureOnce adds the captureHook to the loggers the first time logs are
+// captured.
+var captureOnce sync.Once
+
+type logsCtxKey int
+
+const logsKey logsCtxKey = 0
+
+// captureHook adds entries to the LogBuffer in their context, if there is one.
+type captureHook struct{}
+
+func (captureHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (captureHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	lb, ok := entry.Context.Value(logsKey).(*LogBuffer)
+	if !ok {
+		return nil
+	}
+
+	captured := LogEntry{
+		Time:    entry.Time.UTC(),
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	for key, value := range entry.Data {
+		switch key {
+		case "pipeline":
+			captured.Pipeline, _ = value.(string)
+		case "runID":
+			captured.RunID, _ = value.(string)
+		case "task":
+			captured.Task, _ = value.(string)
+		case "TAG":
+		default:
+			if value == nil {
+				continue
+			}
+			if captured.Fields == nil {
+				captured.Fields = map[string]interface{}{}
+			}
+			// errors don't marshal to JSON usefully
+			if err, isErr := value.(error); isErr {
+				value = err.Error()
+			}
+			captured.Fields[key] = value
+		}
+	}
+	lb.add(captured)
+	return nil
+}
+
+// withLogs returns a context for log entries that captures them in the LogBuffer.
+func withLogs(lb *LogBuffer) context.Context {
+	return context.WithValue(context.Background(), logsKey, lb)
+}
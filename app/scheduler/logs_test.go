@@ -0,0 +1,111 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+)
+
+// logIDs returns the IDs of the entries.
+func logIDs(entries []LogEntry) []uint64 {
+	ids := make([]uint64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+func TestLogBuffer(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	lb := newLogBuffer(3)
+	w.ShouldBeEqual(len(lb.Entries(0)), 0)
+
+	entries, cancel := lb.Subscribe()
+	for i := 0; i < 2; i++ {
+		lb.add(LogEntry{Message: "entry"})
+	}
+	w.ShouldBeEqual(logIDs(lb.Entries(0)), []uint64{1, 2})
+
+	// once it's full, the oldest entries are replaced
+	for i := 0; i < 3; i++ {
+		lb.add(LogEntry{Message: "entry"})
+	}
+	w.ShouldBeEqual(logIDs(lb.Entries(0)), []uint64{3, 4, 5})
+	w.ShouldBeEqual(logIDs(lb.Entries(3)), []uint64{4, 5})
+	w.ShouldBeEqual(len(lb.Entries(5)), 0)
+
+	// subscribers receive every entry, unless they fall behind
+	for i := uint64(1); i <= 5; i++ {
+		w.ShouldBeEqual((<-entries).ID, i)
+	}
+	for i := 0; i < subscriberBuffer+1; i++ {
+		lb.add(LogEntry{Message: "entry"})
+	}
+	w.ShouldBeEqual(len(entries), subscriberBuffer)
+
+	cancel()
+	cancel()
+	w.ShouldBeEqual(len(lb.subscribers), 0)
+}
+
+func TestRegistry_CaptureLogs(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	// without capture, there are no logs
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "uncaptured.json", `{
+	  "name": "uncaptured",
+	  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+	}`, nil))).(*Entry)
+	w.ShouldBeNil(e.Logs())
+
+	reg.CaptureLogs(100)
+	logger.SetTagLevel("captured", logger.DebugLevel)
+	defer logger.ResetTagLevel("captured")
+	e = w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "captured.json", `{
+	  "name": "captured",
+	  "tasks": {
+	    "first": { "type": "test", "raw": { "output": "hello" } },
+	    "broken": { "type": "test", "raw": { "fail": "no good" }, "ifSuccessful": [ "first" ] }
+	  }
+	}`, Every(time.Hour)))).(*Entry)
+
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	entries := e.Logs().Entries(0)
+	byMessage := map[string]LogEntry{}
+	for _, entry := range entries {
+		w.ShouldBeEqual(entry.Pipeline, "captured")
+		w.ShouldBeEqual(entry.RunID, exec.ID)
+		byMessage[entry.Message] = entry
+	}
+
+	w.ShouldContain(byMessage, []string{"Starting pipeline captured.", "Pipeline failed."})
+	w.ShouldBeEqual(byMessage["Pipeline failed."].Level, "error")
+
+	completed := byMessage["Task completed."]
+	w.ShouldBeEqual(completed.Task, "first")
+	w.ShouldBeEqual(completed.Level, "debug")
+	w.ShouldBeEqual(completed.Fields["type"], "test")
+
+	failed := byMessage["Task failed."]
+	w.ShouldBeEqual(failed.Task, "broken")
+	w.ShouldBeEqual(failed.Level, "warning")
+	w.ShouldContainStr(failed.Fields["error"].(string), "no good")
+
+	// entries not about an execution have no run ID
+	w.ShouldSucceed(e.Pause())
+	last := e.Logs().Entries(entries[len(entries)-1].ID)
+	w.ShouldHaveLength(last, 1)
+	w.ShouldBeEqual(last[0].Message, "Pipeline captured paused.")
+	w.ShouldBeEmptyStr(last[0].RunID)
+}
@@ -0,0 +1,134 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/prometheus"
+)
+
+// These are exposed in the Prometheus format by the /metrics route; they're
+// separate from the go-metrics registry, which is reported to InfluxDB.
+var (
+	pipelineRuns = prometheus.NewCounterVec("data_provider_pipeline_runs_total",
+		"Completed pipeline executions.", "pipeline")
+	pipelineFailures = prometheus.NewCounterVec("data_provider_pipeline_failures_total",
+		"Pipeline executions that failed.", "pipeline")
+	pipelineDuration = prometheus.NewHistogramVec("data_provider_pipeline_duration_seconds",
+		"How long pipeline executions take.", nil, "pipeline")
+	pipelineLastSuccess = prometheus.NewGaugeVec("data_provider_pipeline_last_success_timestamp_seconds",
+		"When the pipeline last completed successfully, as a Unix time.", "pipeline")
+	pipelineConsecutiveFailures = prometheus.NewGaugeVec("data_provider_pipeline_consecutive_failures",
+		"How many of the pipeline's latest executions failed in a row.", "pipeline")
+	pipelineBreakerOpen = prometheus.NewGaugeVec("data_provider_pipeline_circuit_breaker_open",
+		"1 if the pipeline's circuit breaker is open or half-open, or else 0.", "pipeline")
+
+	httpDuration = prometheus.NewHistogramVec("data_provider_task_http_request_duration_seconds",
+		"How long the HTTP requests made by a pipeline's task take.", nil, "pipeline", "task")
+	httpRequestBytes = prometheus.NewCounterVec("data_provider_task_http_request_bytes_total",
+		"Bytes sent in the bodies of HTTP requests made by a pipeline's task.", "pipeline", "task")
+	httpResponseBytes = prometheus.NewCounterVec("data_provider_task_http_response_bytes_total",
+		"Bytes received in the bodies of HTTP responses to a pipeline's task.", "pipeline", "task")
+)
+
+func init() {
+	prometheus.MustRegister(
+		pipelineRuns, pipelineFailures, pipelineDuration, pipelineLastSuccess,
+		pipelineConsecutiveFailures, pipelineBreakerOpen,
+		httpDuration, httpRequestBytes, httpResponseBytes,
+	)
+}
+
+// observeExecution updates the pipeline's metrics with a completed execution,
+// both those exposed to Prometheus and those in the go-metrics registry.
+func observeExecution(exec *Execution) {
+	duration := exec.CompletedAt.Sub(exec.StartedAt)
+	pipelineRuns.Inc(exec.Pipeline)
+	pipelineDuration.Observe(duration.Seconds(), exec.Pipeline)
+	switch exec.State {
+	case goplumber.Success:
+		pipelineLastSuccess.Set(float64(exec.CompletedAt.UnixNano())/1e9, exec.Pipeline)
+	case goplumber.Failed:
+		pipelineFailures.Inc(exec.Pipeline)
+	}
+
+	var bytes int64
+	for _, task := range exec.Tasks {
+		if task.State == goplumber.Waiting {
+			continue // it didn't run
+		}
+		bytes += task.OutputSize
+		reportResult("DataProvider.Task."+exec.Pipeline+"."+task.Name, task.State,
+			task.CompletedAt.Sub(task.StartedAt), task.OutputSize)
+	}
+	reportResult("DataProvider.Pipeline."+exec.Pipeline, exec.State, duration, bytes)
+}
+
+// reportResult updates the go-metrics named with the prefix, which are
+// reported to InfluxDB, with the result of a pipeline or task: its Success or
+// Failure meter, its Duration timer, and its Bytes meter, which counts the
+// bytes it output.
+func reportResult(prefix string, state goplumber.State, duration time.Duration, bytes int64) {
+	switch state {
+	case goplumber.Success:
+		metrics.GetOrRegisterMeter(prefix+".Success", nil).Mark(1)
+	case goplumber.Failed:
+		metrics.GetOrRegisterMeter(prefix+".Failure", nil).Mark(1)
+	}
+	metrics.GetOrRegisterTimer(prefix+".Duration", nil).Update(duration)
+	metrics.GetOrRegisterMeter(prefix+".Bytes", nil).Mark(bytes)
+}
+
+type taskCtxKey int
+
+const outerTaskKey taskCtxKey = 0
+
+// withOuterTask returns a context for executing task, along with the task of
+// the pipeline's own definition that's executing it. That's task itself unless
+// it belongs to a pipeline used as a custom task type.
+func withOuterTask(ctx context.Context, task *goplumber.Task) (context.Context, *goplumber.Task) {
+	if outer, ok := ctx.Value(outerTaskKey).(*goplumber.Task); ok {
+		return ctx, outer
+	}
+	return context.WithValue(ctx, outerTaskKey, task), task
+}
+
+// httpRequest is the request in an "http" task's raw configuration, parts of
+// which its links may replace.
+type httpRequest struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+	Body    json.RawMessage     `json:"body"`
+}
+
+// parseHTTPRequest returns the request in an "http" task's raw configuration.
+func parseHTTPRequest(task *goplumber.Task) *httpRequest {
+	req := &httpRequest{}
+	if len(task.Raw) != 0 && json.Unmarshal(task.Raw, req) != nil {
+		return &httpRequest{}
+	}
+	return req
+}
+
+// observeHTTP updates the HTTP metrics of the pipeline's outer task with a
+// request made by one of its "http" tasks.
+func (rec *taskRecorder) observeHTTP(outer *goplumber.Task, elapsed time.Duration, sent int, received int64) {
+	task, ok := rec.names[outer]
+	if !ok {
+		task = outer.TaskType
+	}
+	httpDuration.Observe(elapsed.Seconds(), rec.pipeline, task)
+	httpRequestBytes.Add(float64(sent), rec.pipeline, task)
+	httpResponseBytes.Add(float64(received), rec.pipeline, task)
+}
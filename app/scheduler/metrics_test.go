@@ -0,0 +1,84 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		_, _ = rw.Write([]byte(`{"sku": "123"}`))
+	}))
+	defer server.Close()
+
+	// http tasks in custom task types are attributed to the task that uses them
+	plumber := getTestPlumber()
+	fetchConf := &goplumber.PipelineConfig{}
+	w.ShouldSucceed(json.Unmarshal([]byte(`{
+	  "name": "fetch",
+	  "defaultOutput": "get",
+	  "tasks": {
+	    "get": { "type": "http", "raw": { "method": "GET", "url": "`+server.URL+`" } }
+	  }
+	}`), fetchConf))
+	fetch := w.ShouldHaveResult(plumber.NewPipeline(fetchConf)).(*goplumber.Pipeline)
+	plumber.SetClient("fetch", w.ShouldHaveResult(goplumber.NewTaskType(fetch)).(goplumber.Client))
+	Instrument(plumber)
+
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "metered.json", `{
+	  "name": "metered",
+	  "tasks": {
+	    "download": { "type": "fetch" },
+	    "upload": {
+	      "type": "http",
+	      "raw": { "method": "POST", "url": "`+server.URL+`", "body": {"a": 1} }
+	    }
+	  }
+	}`, nil))).(*Entry)
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldBeEqual(exec.State, goplumber.Success)
+
+	var buf bytes.Buffer
+	w.ShouldSucceed(prometheus.DefaultRegistry.Write(&buf))
+	exposed := buf.String()
+	w.ShouldContainStr(exposed, `data_provider_pipeline_runs_total{pipeline="metered"} 1`)
+	w.ShouldContainStr(exposed, `data_provider_pipeline_duration_seconds_count{pipeline="metered"} 1`)
+	w.ShouldContainStr(exposed, `data_provider_pipeline_last_success_timestamp_seconds{pipeline="metered"}`)
+	w.ShouldContainStr(exposed, `data_provider_task_http_request_duration_seconds_count{pipeline="metered",task="download"} 1`)
+	w.ShouldContainStr(exposed, `data_provider_task_http_response_bytes_total{pipeline="metered",task="download"} 14`)
+	w.ShouldContainStr(exposed, `data_provider_task_http_request_bytes_total{pipeline="metered",task="upload"} 8`)
+
+	// the go-metrics registry, which is reported to InfluxDB, has them too
+	meter := func(name string) int64 {
+		m, ok := metrics.DefaultRegistry.Get(name).(metrics.Meter)
+		w.As(name).ShouldBeTrue(ok)
+		return m.Count()
+	}
+	w.ShouldBeEqual(meter("DataProvider.Pipeline.metered.Success"), int64(1))
+	w.ShouldBeEqual(meter("DataProvider.Pipeline.metered.Bytes"), int64(28))
+	w.ShouldBeEqual(meter("DataProvider.Task.metered.download.Success"), int64(1))
+	w.ShouldBeEqual(meter("DataProvider.Task.metered.download.Bytes"), int64(14))
+	timer, ok := metrics.DefaultRegistry.Get("DataProvider.Task.metered.upload.Duration").(metrics.Timer)
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(timer.Count(), int64(1))
+	w.ShouldBeNil(metrics.DefaultRegistry.Get("DataProvider.Pipeline.metered.Failure"))
+}
@@ -0,0 +1,179 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/pkg/errors"
+)
+
+// FailurePolicy determines how a pipeline's schedule changes while its
+// executions are failing. The zero value leaves the schedule alone.
+type FailurePolicy struct {
+	// BackoffMultiplier, if greater than 1, multiplies the delay before the
+	// next scheduled execution by itself for each consecutive failure.
+	BackoffMultiplier float64
+	// MaxDelay, if positive, limits the delay while backing off, though the
+	// delay is never shorter than the pipeline's schedule allows.
+	MaxDelay time.Duration
+	// Jitter randomly lengthens or shortens the delay while backing off by up
+	// to this fraction of it, so pipelines that fail together don't retry in
+	// lockstep.
+	Jitter float64
+	// BreakerThreshold, if positive, is the number of consecutive failures
+	// that open the pipeline's circuit breaker, which stops its scheduled
+	// executions until BreakerCooldown elapses. After that, the breaker is
+	// half-open: the next scheduled execution closes it if it succeeds, or
+	// reopens it if it fails.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// BreakerState describes a pipeline's circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed means the pipeline runs on its regular schedule.
+	BreakerClosed = BreakerState("closed")
+	// BreakerOpen means the pipeline's scheduled executions are stopped.
+	BreakerOpen = BreakerState("open")
+	// BreakerHalfOpen means the pipeline's next scheduled execution decides
+	// whether the breaker closes or reopens.
+	BreakerHalfOpen = BreakerState("half-open")
+)
+
+// failurePolicyConfig is the part of a pipeline's configuration that holds its
+// FailurePolicy.
+type failurePolicyConfig struct {
+	FailurePolicy struct {
+		BackoffMultiplier float64             `json:"backoffMultiplier"`
+		MaxDelay          *goplumber.Interval `json:"maxDelay"`
+		Jitter            float64             `json:"jitter"`
+		BreakerThreshold  int                 `json:"breakerThreshold"`
+		BreakerCooldown   *goplumber.Interval `json:"breakerCooldown"`
+	} `json:"failurePolicy"`
+}
+
+// ParseFailurePolicy returns the FailurePolicy described by the
+// "failurePolicy" of the given pipeline configuration. If the pipeline doesn't
+// have one, it returns the zero FailurePolicy.
+func ParseFailurePolicy(pipelineConf []byte) (FailurePolicy, error) {
+	var fc failurePolicyConfig
+	if err := json.Unmarshal(pipelineConf, &fc); err != nil {
+		return FailurePolicy{}, errors.Wrap(err, "invalid failure policy")
+	}
+	conf := fc.FailurePolicy
+
+	policy := FailurePolicy{
+		BackoffMultiplier: conf.BackoffMultiplier,
+		Jitter:            conf.Jitter,
+		BreakerThreshold:  conf.BreakerThreshold,
+	}
+	if conf.MaxDelay != nil {
+		policy.MaxDelay = conf.MaxDelay.Duration()
+	}
+	if conf.BreakerCooldown != nil {
+		policy.BreakerCooldown = conf.BreakerCooldown.Duration()
+	}
+
+	switch {
+	case policy.BackoffMultiplier != 0 && policy.BackoffMultiplier < 1:
+		return FailurePolicy{}, errors.Errorf("the failure policy's backoffMultiplier "+
+			"must be at least 1, not %v", policy.BackoffMultiplier)
+	case policy.MaxDelay < 0:
+		return FailurePolicy{}, errors.Errorf("the failure policy's maxDelay "+
+			"can't be negative, not %s", policy.MaxDelay)
+	case policy.Jitter < 0 || policy.Jitter > 1:
+		return FailurePolicy{}, errors.Errorf("the failure policy's jitter "+
+			"must be between 0 and 1, not %v", policy.Jitter)
+	case (conf.MaxDelay != nil || policy.Jitter != 0) && policy.BackoffMultiplier == 0:
+		return FailurePolicy{}, errors.New("the failure policy's maxDelay and " +
+			"jitter require a backoffMultiplier")
+	case policy.BreakerThreshold < 0:
+		return FailurePolicy{}, errors.Errorf("the failure policy's breakerThreshold "+
+			"can't be negative, not %d", policy.BreakerThreshold)
+	case policy.BreakerThreshold > 0 && policy.BreakerCooldown <= 0:
+		return FailurePolicy{}, errors.New("the failure policy's breakerThreshold " +
+			"requires a positive breakerCooldown")
+	case policy.BreakerThreshold == 0 && conf.BreakerCooldown != nil:
+		return FailurePolicy{}, errors.New("the failure policy's breakerCooldown " +
+			"requires a breakerThreshold")
+	}
+	return policy, nil
+}
+
+// maxBackoff limits the delay while backing off without a MaxDelay, so that it
+// can't overflow a Duration, which float64(math.MaxInt64) would, since it
+// rounds up to 2^63.
+const maxBackoff = time.Duration(1 << 62)
+
+// backoff returns when a pipeline should next execute after failures
+// consecutive failures, the last of which completed at lastRun, given that its
+// schedule would otherwise execute it at next.
+func (p FailurePolicy) backoff(lastRun, next time.Time, failures int) time.Time {
+	if p.BackoffMultiplier <= 1 || failures == 0 {
+		return next
+	}
+
+	regular := next.Sub(lastRun)
+	limit := maxBackoff
+	if p.MaxDelay > 0 && p.MaxDelay < limit {
+		limit = p.MaxDelay
+	}
+	delay := math.Min(float64(regular)*math.Pow(p.BackoffMultiplier, float64(failures)), float64(limit))
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	delay = math.Min(math.Max(delay, float64(regular)), float64(maxBackoff))
+	return lastRun.Add(time.Duration(delay))
+}
+
+// breakerState returns the state of the pipeline's circuit breaker at the
+// given time. The caller must hold the entry's lock.
+func (e *Entry) breakerState(now time.Time) BreakerState {
+	if e.openedAt.IsZero() || e.def.FailurePolicy.BreakerThreshold == 0 {
+		return BreakerClosed
+	}
+	if now.Before(e.openedAt.Add(e.def.FailurePolicy.BreakerCooldown)) {
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// recordFailure updates the pipeline's consecutive failures and circuit
+// breaker after an execution completes. It returns the breaker's previous and
+// new states. The caller must hold the entry's lock.
+func (e *Entry) recordFailure(failed bool, now time.Time) (from, to BreakerState) {
+	from = e.breakerState(now)
+	if !failed {
+		e.failures = 0
+		e.openedAt = time.Time{}
+	} else {
+		e.failures++
+		threshold := e.def.FailurePolicy.BreakerThreshold
+		if threshold > 0 && e.failures >= threshold {
+			e.openedAt = now
+		}
+	}
+
+	to = e.breakerState(now)
+	breakerOpen := int64(0)
+	if to != BreakerClosed {
+		breakerOpen = 1
+	}
+	prefix := "DataProvider.Pipeline." + e.name
+	metrics.GetOrRegisterGauge(prefix+".ConsecutiveFailures", nil).Update(int64(e.failures))
+	metrics.GetOrRegisterGauge(prefix+".CircuitBreakerOpen", nil).Update(breakerOpen)
+	pipelineConsecutiveFailures.Set(float64(e.failures), e.name)
+	pipelineBreakerOpen.Set(float64(breakerOpen), e.name)
+
+	return from, to
+}
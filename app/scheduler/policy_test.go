@@ -0,0 +1,144 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	policy := w.ShouldHaveResult(ParseFailurePolicy([]byte(`{}`))).(FailurePolicy)
+	w.ShouldBeEqual(policy, FailurePolicy{})
+
+	policy = w.ShouldHaveResult(ParseFailurePolicy([]byte(`{"failurePolicy": {
+	  "backoffMultiplier": 2,
+	  "maxDelay": {"minutes": 30},
+	  "jitter": 0.1,
+	  "breakerThreshold": 5,
+	  "breakerCooldown": {"minutes": 10}
+	}}`))).(FailurePolicy)
+	w.ShouldBeEqual(policy, FailurePolicy{
+		BackoffMultiplier: 2,
+		MaxDelay:          30 * time.Minute,
+		Jitter:            0.1,
+		BreakerThreshold:  5,
+		BreakerCooldown:   10 * time.Minute,
+	})
+
+	for _, invalid := range []string{
+		`{"failurePolicy": {"backoffMultiplier": 0.5}}`,
+		`{"failurePolicy": {"backoffMultiplier": 2, "maxDelay": {"minutes": -1}}}`,
+		`{"failurePolicy": {"backoffMultiplier": 2, "jitter": 1.5}}`,
+		`{"failurePolicy": {"jitter": 0.1}}`,
+		`{"failurePolicy": {"maxDelay": {"minutes": 1}}}`,
+		`{"failurePolicy": {"breakerThreshold": -1}}`,
+		`{"failurePolicy": {"breakerThreshold": 3}}`,
+		`{"failurePolicy": {"breakerCooldown": {"minutes": 1}}}`,
+		`{"failurePolicy": "retry"}`,
+	} {
+		_, err := ParseFailurePolicy([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestFailurePolicy_backoff(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	lastRun := time.Date(2019, 3, 10, 5, 30, 0, 0, time.UTC)
+	next := lastRun.Add(time.Minute)
+
+	w.ShouldBeEqual(FailurePolicy{}.backoff(lastRun, next, 3), next)
+
+	policy := FailurePolicy{BackoffMultiplier: 2, MaxDelay: 10 * time.Minute}
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 0), next)
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 1), lastRun.Add(2*time.Minute))
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 3), lastRun.Add(8*time.Minute))
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 4), lastRun.Add(10*time.Minute))
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 1000), lastRun.Add(10*time.Minute))
+
+	// the delay is never shorter than the schedule's
+	policy.MaxDelay = time.Second
+	w.ShouldBeEqual(policy.backoff(lastRun, next, 3), next)
+
+	// without a MaxDelay, long runs of failures don't overflow the delay
+	policy = FailurePolicy{BackoffMultiplier: 2}
+	for _, failures := range []int{27, 62, 100, 10000} {
+		w.As(failures).ShouldBeEqual(policy.backoff(lastRun, lastRun.Add(2*time.Minute), failures),
+			lastRun.Add(maxBackoff))
+	}
+	policy.Jitter = 1
+	for i := 0; i < 100; i++ {
+		at := policy.backoff(lastRun, next, 1000)
+		w.ShouldBeFalse(at.Before(next))
+		w.ShouldBeFalse(at.After(lastRun.Add(maxBackoff)))
+	}
+
+	policy = FailurePolicy{BackoffMultiplier: 2, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		at := policy.backoff(lastRun, next, 2)
+		w.ShouldBeFalse(at.Before(lastRun.Add(2 * time.Minute)))
+		w.ShouldBeFalse(at.After(lastRun.Add(6 * time.Minute)))
+	}
+}
+
+func TestEntry_CircuitBreaker(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+	policy := FailurePolicy{BreakerThreshold: 2, BreakerCooldown: 100 * time.Millisecond}
+
+	def := newTestDefinition(w, plumber, "fail.json", failPipeline, Every(10*time.Millisecond))
+	def.FailurePolicy = policy
+	e := w.ShouldHaveResult(reg.Add(def)).(*Entry)
+	w.ShouldBeEqual(e.Status().CircuitBreaker, BreakerClosed)
+
+	w.ShouldHaveResult(e.Run(true))
+	s := e.Status()
+	w.ShouldBeEqual(s.Failures, 1)
+	w.ShouldBeEqual(s.CircuitBreaker, BreakerClosed)
+
+	w.ShouldHaveResult(e.Run(true))
+	s = e.Status()
+	w.ShouldBeEqual(s.Failures, 2)
+	w.ShouldBeEqual(s.CircuitBreaker, BreakerOpen)
+
+	// while the breaker is open, the next execution waits for the cooldown
+	wait, _ := e.schedule(time.Now(), time.Now())
+	w.ShouldBeTrue(wait > 50*time.Millisecond)
+
+	// after the cooldown, a failure reopens the breaker
+	time.Sleep(policy.BreakerCooldown)
+	w.ShouldBeEqual(e.Status().CircuitBreaker, BreakerHalfOpen)
+	wait, _ = e.schedule(time.Now(), time.Now())
+	w.ShouldBeTrue(wait <= 10*time.Millisecond)
+	w.ShouldHaveResult(e.Run(true))
+	s = e.Status()
+	w.ShouldBeEqual(s.Failures, 3)
+	w.ShouldBeEqual(s.CircuitBreaker, BreakerOpen)
+
+	// and a success closes it
+	time.Sleep(policy.BreakerCooldown)
+	def = newTestDefinition(w, plumber, "fail.json", `{
+	  "name": "fail",
+	  "tasks": { "fixed": { "type": "test", "raw": { "output": "hi" } } }
+	}`, Every(10*time.Millisecond))
+	def.FailurePolicy = policy
+	reg.Update([]Definition{def}, nil)
+	w.ShouldHaveResult(e.Run(true))
+	s = e.Status()
+	w.ShouldBeEqual(s.Failures, 0)
+	w.ShouldBeEqual(s.CircuitBreaker, BreakerClosed)
+
+	// pipelines without a breaker don't report its state
+	def.FailurePolicy = FailurePolicy{}
+	reg.Update([]Definition{def}, nil)
+	w.ShouldBeEmptyStr(string(e.Status().CircuitBreaker))
+}
@@ -0,0 +1,937 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package scheduler keeps track of the pipelines loaded by the service, runs
+// them according to their triggers, and records the results of their execution.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/mqttsub"
+)
+
+// defaultTimeout matches the timeout goplumber uses for pipelines that don't
+// declare their own.
+const defaultTimeout = 30 * time.Second
+
+var (
+	// ErrAlreadyRunning is returned when a pipeline is asked to run while it
+	// is already executing.
+	ErrAlreadyRunning = errors.New("pipeline is already running")
+
+	// ErrDraining is returned when a pipeline is asked to run after the
+	// Registry has started draining.
+	ErrDraining = errors.New("pipelines are draining for shutdown")
+)
+
+// abortWait is how long Drain waits for canceled executions to record their
+// results.
+const abortWait = 5 * time.Second
+
+// Registry holds the pipelines loaded by the service.
+type Registry struct {
+	mux        sync.RWMutex
+	entries    map[string]*Entry
+	ctx        context.Context // used for executions
+	cancel     context.CancelFunc
+	started    bool
+	draining   bool
+	drained    chan struct{}  // closed when the Registry starts draining
+	inFlight   sync.WaitGroup // executions in progress
+	state      *stateFile
+	lastReload *Reload
+	slots      chan struct{} // limits concurrent scheduled executions
+	subscriber Subscriber
+	history    *History
+	logSize    int // the size of each pipeline's LogBuffer
+}
+
+// Definition is a loaded pipeline, along with how it should be scheduled.
+type Definition struct {
+	// Source is the file from which the pipeline was loaded.
+	Source   string
+	Config   *goplumber.PipelineConfig
+	Pipeline *goplumber.Pipeline
+	// Schedule is nil if the pipeline only executes after other pipelines,
+	// when messages are published, when requests are posted to its hook, or
+	// when files are delivered to its drop folder.
+	Schedule Schedule
+	// After lists the pipelines after which this one executes.
+	After []Dependency
+	// Subscription, if it's set, makes the pipeline execute when messages are
+	// published to an MQTT topic.
+	Subscription *Subscription
+	// Webhook, if it's set, makes the pipeline execute when requests are
+	// posted to its hook.
+	Webhook *Webhook
+	// DropFolder, if it's set, makes the pipeline execute for each file
+	// delivered to a directory.
+	DropFolder *DropFolder
+	// FailurePolicy determines how the schedule changes while the pipeline's
+	// executions are failing.
+	FailurePolicy FailurePolicy
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		entries: map[string]*Entry{},
+		ctx:     ctx,
+		cancel:  cancel,
+		drained: make(chan struct{}),
+	}
+}
+
+// Add registers a pipeline. If the Registry has been started, the pipeline's
+// schedule starts immediately.
+//
+// Pipeline names must be unique within a Registry, and the pipelines the new
+// one executes after must already be registered.
+func (r *Registry) Add(def Definition) (*Entry, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	name := def.Config.Name
+	if _, exists := r.entries[name]; !exists {
+		graph := map[string]Definition{name: def}
+		for other, e := range r.entries {
+			graph[other] = e.Definition()
+		}
+		if err := checkDependencies(graph, map[string]bool{name: true})[name]; err != nil {
+			return nil, err
+		}
+	}
+	return r.add(def)
+}
+
+// add registers a pipeline; the caller must hold the write lock.
+func (r *Registry) add(def Definition) (*Entry, error) {
+	name := def.Config.Name
+	if _, exists := r.entries[name]; exists {
+		return nil, errors.Errorf("a pipeline named %q is already registered", name)
+	}
+
+	e := &Entry{
+		registry: r,
+		name:     name,
+		reset:    make(chan struct{}, 1),
+		trigger:  make(chan struct{}, 1),
+		messages: make(chan mqttsub.Message, messageQueueSize),
+		dropped:  make(chan struct{}, 1),
+		added:    time.Now(),
+		def:      def,
+	}
+	if r.state != nil {
+		e.paused = r.state.get(name).Paused
+	}
+	if r.logSize > 0 {
+		e.logs = newLogBuffer(r.logSize)
+	}
+	r.entries[name] = e
+	if r.started && !r.draining {
+		e.start(r.ctx)
+	}
+	return e, nil
+}
+
+// UseStateFile loads the pipelines' persisted state, such as whether they're
+// paused, from the given file, and saves future changes to it.
+//
+// The state is applied to pipelines already in the Registry as well as those
+// added later. If the file doesn't exist, it's created on the first change.
+func (r *Registry) UseStateFile(path string) error {
+	sf, err := loadStateFile(path)
+	if err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	r.state = sf
+	entries := make([]*Entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mux.Unlock()
+
+	for _, e := range entries {
+		e.mux.Lock()
+		e.paused = sf.get(e.Name()).Paused
+		e.mux.Unlock()
+	}
+	return nil
+}
+
+// LimitConcurrency limits the number of scheduled executions that may run at
+// once; when the limit is reached, pipelines wait to execute until others
+// complete. If n isn't positive, there's no limit. Executions requested via
+// Entry.Run aren't limited and don't count towards the limit.
+//
+// It should be called before the Registry is started.
+func (r *Registry) LimitConcurrency(n int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if n > 0 {
+		r.slots = make(chan struct{}, n)
+	} else {
+		r.slots = nil
+	}
+}
+
+// acquire waits until a scheduled execution of the named pipeline is allowed
+// to run under the Registry's concurrency limit, then returns a function that
+// must be called when it completes. It returns false if ctx is canceled or
+// done is closed first.
+func (r *Registry) acquire(ctx context.Context, done <-chan struct{}, name string) (func(), bool) {
+	r.mux.RLock()
+	slots := r.slots
+	r.mux.RUnlock()
+	if slots == nil {
+		return func() {}, true
+	}
+
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	log.Debugf("Pipeline %s is waiting for other pipelines to complete.", name)
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-ctx.Done():
+		return nil, false
+	case <-done:
+		return nil, false
+	}
+}
+
+// Get returns the Entry for the named pipeline, if it exists.
+func (r *Registry) Get(name string) (*Entry, bool) {
+	r.mux.RLock()
+	e, ok := r.entries[name]
+	r.mux.RUnlock()
+	return e, ok
+}
+
+// List returns all registered entries, sorted by name.
+func (r *Registry) List() []*Entry {
+	r.mux.RLock()
+	entries := make([]*Entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mux.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// Start runs every registered pipeline on its schedule until ctx is canceled.
+//
+// The context is also used for executions requested via Entry.Run.
+func (r *Registry) Start(ctx context.Context) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.started = true
+	log.Debugf("Starting %d pipelines.", len(r.entries))
+	for _, e := range r.entries {
+		e.start(r.ctx)
+	}
+}
+
+// Drain prepares the pipelines for shutdown: it stops their schedules and
+// rejects requests to run them, then waits up to timeout for executions in
+// progress to complete. Executions that are still running after that are
+// canceled, and the names of their pipelines are returned.
+func (r *Registry) Drain(timeout time.Duration) []string {
+	r.mux.Lock()
+	if !r.draining {
+		r.draining = true
+		close(r.drained)
+	}
+	for _, e := range r.entries {
+		e.stopSchedule()
+	}
+	r.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(done)
+	}()
+
+	log.Infof("Waiting up to %s for running pipelines to complete.", timeout)
+	select {
+	case <-done:
+		log.Info("Pipelines drained.")
+		return nil
+	case <-time.After(timeout):
+	}
+
+	var aborted []string
+	for _, e := range r.List() {
+		if e.Status().Running {
+			aborted = append(aborted, e.Name())
+			e.log().Warn(
+				"Aborting pipeline that didn't complete before the drain timeout.")
+		}
+	}
+	r.cancel()
+
+	select {
+	case <-done:
+	case <-time.After(abortWait):
+		log.Warn("Canceled pipelines are still running.")
+	}
+	return aborted
+}
+
+// Draining returns a channel that's closed when the Registry starts draining,
+// so that work that would delay the service's shutdown, such as streaming
+// logs, can stop.
+func (r *Registry) Draining() <-chan struct{} {
+	return r.drained
+}
+
+// Entry is a pipeline registered with a Registry, along with its execution
+// history.
+type Entry struct {
+	registry *Registry
+	name     string
+	reset    chan struct{} // tells runForever the schedule changed
+	trigger  chan struct{} // tells runForever a prerequisite completed
+	messages chan mqttsub.Message
+	dropped  chan struct{} // tells runForever files may be ready
+	added    time.Time
+	logs     *LogBuffer // if its logs are captured
+
+	mux       sync.RWMutex
+	def       Definition
+	loadError string
+	stop      context.CancelFunc
+	running   bool
+	finished  chan struct{} // closed when the running execution completes
+	removed   bool          // from the Registry
+	paused    bool
+	last      *Execution
+	lastOK    time.Time // when the last successful execution completed
+	next      time.Time
+	failures  int       // consecutive failed executions
+	openedAt  time.Time // when the circuit breaker last opened, if it's open
+	output    string    // digest of the last successful execution's output
+	cancelSub func()    // cancels the pipeline's MQTT subscription
+	// cancelWatch stops checking the pipeline's drop folder
+	cancelWatch func()
+	unmoved     map[string]unmovedFile // processed files that couldn't be moved, by name
+}
+
+// Trigger identifies what caused an execution.
+type Trigger string
+
+// These are the Triggers of executions.
+const (
+	TriggerSchedule   Trigger = "schedule"
+	TriggerDependency Trigger = "dependency"
+	TriggerMQTT       Trigger = "mqtt"
+	TriggerWebhook    Trigger = "webhook"
+	TriggerDropFolder Trigger = "dropFolder"
+	TriggerAPI        Trigger = "api"
+)
+
+// Execution describes a single run of a pipeline.
+type Execution struct {
+	ID          string          `json:"id"`
+	Pipeline    string          `json:"pipeline"`
+	Trigger     Trigger         `json:"trigger"`
+	State       goplumber.State `json:"state"`
+	StartedAt   time.Time       `json:"startedAt"`
+	CompletedAt time.Time       `json:"completedAt"`
+	Duration    string          `json:"duration,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	// OutputChanged is true if the execution succeeded and its output differs
+	// from that of the pipeline's previous successful execution.
+	OutputChanged bool `json:"outputChanged,omitempty"`
+	// Topic is the MQTT topic of the message that triggered the execution, if
+	// it was triggered by one.
+	Topic string `json:"topic,omitempty"`
+	// File is the name of the file in the pipeline's drop folder that
+	// triggered the execution, if it was triggered by one.
+	File string `json:"file,omitempty"`
+	// TraceID identifies the execution's trace, if tracing is enabled.
+	TraceID string       `json:"traceId,omitempty"`
+	Tasks   []TaskResult `json:"tasks,omitempty"`
+}
+
+// UnmarshalJSON allows Executions to be read from the run history.
+func (exec *Execution) UnmarshalJSON(data []byte) error {
+	type plain Execution
+	aux := struct {
+		*plain
+		State string `json:"state"`
+	}{plain: (*plain)(exec)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	state, err := parseState(aux.State)
+	exec.State = state
+	return err
+}
+
+// Status is a snapshot of a pipeline's definition and execution state.
+type Status struct {
+	Name           string                     `json:"name"`
+	Description    string                     `json:"description"`
+	Schedule       string                     `json:"schedule,omitempty"`
+	Interval       string                     `json:"interval,omitempty"`
+	After          []Dependency               `json:"after,omitempty"`
+	Subscription   *Subscription              `json:"subscription,omitempty"`
+	Webhook        *Webhook                   `json:"webhook,omitempty"`
+	DropFolder     *DropFolder                `json:"dropFolder,omitempty"`
+	TimeoutSeconds int                        `json:"timeoutSeconds"`
+	Tasks          map[string]*goplumber.Task `json:"tasks"`
+	Source         string                     `json:"source,omitempty"`
+	LoadError      string                     `json:"loadError,omitempty"`
+	Running        bool                       `json:"running"`
+	Paused         bool                       `json:"paused"`
+	Failures       int                        `json:"consecutiveFailures"`
+	CircuitBreaker BreakerState               `json:"circuitBreaker,omitempty"`
+	LastExecution  *Execution                 `json:"lastExecution,omitempty"`
+	LastSuccess    *time.Time                 `json:"lastSuccess,omitempty"`
+	NextExecution  *time.Time                 `json:"nextExecution,omitempty"`
+}
+
+// Name returns the pipeline's name.
+func (e *Entry) Name() string {
+	return e.name
+}
+
+// log returns an entry for logging about the pipeline with its tagged logger,
+// so that its level can be set independently of the others. If the pipeline's
+// logs are captured, the entry is added to its LogBuffer.
+func (e *Entry) log() *log.Entry {
+	entry := logger.Tagged(e.name).WithField("pipeline", e.name)
+	if e.logs != nil {
+		entry = entry.WithContext(withLogs(e.logs))
+	}
+	return entry
+}
+
+// Definition returns the pipeline's current definition.
+func (e *Entry) Definition() Definition {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.def
+}
+
+// Timeout returns the amount of time the pipeline is allowed to run, or zero
+// if the pipeline should never time out.
+func (e *Entry) Timeout() time.Duration {
+	return timeout(e.Definition().Config)
+}
+
+func timeout(conf *goplumber.PipelineConfig) time.Duration {
+	if conf.TimeoutSecs == nil || *conf.TimeoutSecs == 0 {
+		return defaultTimeout
+	}
+	if *conf.TimeoutSecs < 0 {
+		return 0
+	}
+	return time.Duration(*conf.TimeoutSecs) * time.Second
+}
+
+// Status returns a snapshot of the pipeline's current state.
+func (e *Entry) Status() Status {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+
+	s := Status{
+		Name:           e.name,
+		Description:    e.def.Config.Description,
+		Schedule:       scheduleString(e.def.Schedule),
+		After:          e.def.After,
+		Subscription:   e.def.Subscription,
+		Webhook:        e.def.Webhook,
+		DropFolder:     e.def.DropFolder,
+		TimeoutSeconds: int(timeout(e.def.Config).Seconds()),
+		Tasks:          e.def.Config.Tasks,
+		Source:         e.def.Source,
+		LoadError:      e.loadError,
+		Running:        e.running,
+		Paused:         e.paused,
+		Failures:       e.failures,
+	}
+	if e.def.FailurePolicy.BreakerThreshold > 0 {
+		s.CircuitBreaker = e.breakerState(time.Now())
+	}
+	if interval, ok := interval(e.def.Schedule); ok {
+		s.Interval = interval.String()
+	}
+	if e.last != nil {
+		last := *e.last
+		s.LastExecution = &last
+	}
+	if !e.lastOK.IsZero() {
+		lastOK := e.lastOK
+		s.LastSuccess = &lastOK
+	}
+	if !e.next.IsZero() && !e.paused {
+		next := e.next
+		s.NextExecution = &next
+	}
+	return s
+}
+
+// Pause disables the pipeline until it's resumed: its schedule, dependencies,
+// subscription, webhook, and drop folder no longer execute it.
+//
+// An execution already in progress is allowed to complete, and the pipeline
+// may still be run on demand. If the Registry has a state file, the change
+// is persisted, and if that fails, the pipeline's state is left unchanged.
+func (e *Entry) Pause() error {
+	return e.setPaused(true)
+}
+
+// Resume re-enables the pipeline after a call to Pause.
+func (e *Entry) Resume() error {
+	return e.setPaused(false)
+}
+
+func (e *Entry) setPaused(paused bool) error {
+	e.registry.mux.RLock()
+	state := e.registry.state
+	e.registry.mux.RUnlock()
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if state != nil {
+		if err := state.set(e.Name(), pipelineState{Paused: paused}); err != nil {
+			return err
+		}
+	}
+	e.paused = paused
+
+	if paused {
+		e.log().Infof("Pipeline %s paused.", e.Name())
+	} else {
+		e.log().Infof("Pipeline %s resumed.", e.Name())
+	}
+	return nil
+}
+
+// Run executes the pipeline immediately, independent of its schedule. If a
+// scheduled execution is due while it's running, it starts once this one
+// completes.
+//
+// If wait is true, Run returns after the execution completes; otherwise, it
+// returns as soon as the execution starts, in which case the returned Execution
+// only has its ID, State, and StartedAt fields set. If the pipeline is already
+// running, Run returns ErrAlreadyRunning, and if the Registry is draining, it
+// returns ErrDraining.
+func (e *Entry) Run(wait bool) (*Execution, error) {
+	ctx, exec, def, err := e.begin(TriggerAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	if !wait {
+		started := *exec
+		go e.execute(ctx, exec, def)
+		return &started, nil
+	}
+
+	e.execute(ctx, exec, def)
+	return exec, nil
+}
+
+// start runs the pipeline on its schedule until ctx is canceled or the entry is
+// stopped, subscribes it to its MQTT topic, and watches its drop folder, if it
+// has them. Its executions use ctx, so they're unaffected by stopping the
+// entry.
+//
+// The caller must hold the Registry's write lock.
+func (e *Entry) start(ctx context.Context) {
+	schedCtx, cancel := context.WithCancel(ctx)
+	e.mux.Lock()
+	e.stop = cancel
+	e.mux.Unlock()
+	e.subscribe()
+	e.watchFolder()
+	go e.runForever(ctx, schedCtx.Done())
+}
+
+// stopSchedule stops the pipeline's scheduled executions, cancels its
+// subscription, and stops watching its drop folder. An execution already in
+// progress is allowed to complete.
+func (e *Entry) stopSchedule() {
+	e.unsubscribe()
+	e.unwatchFolder()
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.stop != nil {
+		e.stop()
+		e.stop = nil
+	}
+	e.next = time.Time{}
+}
+
+// runForever executes the pipeline according to its schedule until ctx is
+// canceled or done is closed.
+//
+// The pipeline also executes when a pipeline it depends on triggers it, for
+// each message delivered by its subscription, and for each file delivered to
+// its drop folder, which is then moved according to the execution's result.
+//
+// Only scheduled executions move the schedule forward, so executions with
+// other triggers don't delay it.
+//
+// If the pipeline is paused or its circuit breaker is open when its timer
+// fires or it's triggered, that execution is skipped, as are triggered
+// executions while it's already running. A scheduled execution that's due,
+// or a file that's delivered, while the pipeline is run on demand waits for
+// that execution to complete instead. Files are only read once the pipeline
+// isn't paused and its circuit breaker isn't open, so they're left alone
+// until they can be processed. If the Registry's concurrency limit has been
+// reached, the execution waits.
+func (e *Entry) runForever(ctx context.Context, done <-chan struct{}) {
+	started := time.Now()
+	var lastRun time.Time
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	armed := false
+	var trigger Trigger          // of the current execution
+	var message *mqttsub.Message // that triggered the current execution
+	var file *DroppedFile        // that triggered the current execution
+	reschedule := func() {
+		if armed && !timer.Stop() {
+			<-timer.C
+		}
+		var wait time.Duration
+		wait, armed = e.schedule(started, lastRun)
+		if armed {
+			timer.Reset(wait)
+		}
+	}
+	reschedule()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-e.reset:
+			reschedule()
+			continue
+		case <-e.trigger:
+			trigger = TriggerDependency
+		case msg := <-e.messages:
+			trigger = TriggerMQTT
+			message = &msg
+		case <-e.dropped:
+			trigger = TriggerDropFolder
+		case <-timer.C:
+			trigger = TriggerSchedule
+			armed = false
+		}
+
+		e.mux.RLock()
+		paused := e.paused
+		breaker := e.breakerState(time.Now())
+		e.mux.RUnlock()
+
+		switch {
+		case paused:
+			e.log().Debugf("Pipeline %s is paused; skipping %s execution.", e.Name(), trigger)
+		case breaker == BreakerOpen:
+			e.log().Debugf("Pipeline %s's circuit breaker is open; skipping %s execution.", e.Name(), trigger)
+		default:
+			if trigger == TriggerDropFolder {
+				if file = e.nextDroppedFile(); file == nil {
+					break
+				}
+			}
+			exec, ok := e.runScheduled(ctx, done, trigger, message, file)
+			if !ok {
+				return
+			}
+			if file != nil && exec != nil && e.finishDroppedFile(ctx, file, exec) {
+				e.notifyDropped() // process the next file, if there is one
+			}
+		}
+
+		message = nil
+		file = nil
+		if trigger == TriggerSchedule {
+			lastRun = time.Now()
+		}
+		if !armed {
+			reschedule()
+		}
+	}
+}
+
+// runScheduled executes the pipeline for the given trigger, with the message or
+// file that triggered it if either isn't nil, waiting for the Registry's
+// concurrency limit if necessary. If the pipeline is already running, a
+// scheduled or drop folder execution waits for it to complete, while others
+// are skipped. It returns the completed Execution, or nil if it was skipped,
+// and false if the schedule should stop.
+func (e *Entry) runScheduled(ctx context.Context, done <-chan struct{}, trigger Trigger, msg *mqttsub.Message, file *DroppedFile) (*Execution, bool) {
+	for {
+		exec, err := e.runLimited(ctx, done, trigger, msg, file)
+		switch {
+		case err == nil:
+			return exec, true
+		case err != ErrAlreadyRunning:
+			return nil, false
+		case trigger != TriggerSchedule && trigger != TriggerDropFolder:
+			e.log().Debugf("Pipeline %s is already running; skipping execution.", e.Name())
+			return nil, true
+		}
+
+		e.log().Debugf("Pipeline %s is already running; "+
+			"its %s execution will start when it completes.", e.Name(), trigger)
+		e.mux.RLock()
+		finished := e.finished
+		e.mux.RUnlock()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return nil, false
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// runLimited executes the pipeline once the Registry's concurrency limit allows
+// it, as described by runScheduled. It returns ErrAlreadyRunning if the
+// pipeline is already running, and ErrDraining if the schedule should stop.
+func (e *Entry) runLimited(ctx context.Context, done <-chan struct{}, trigger Trigger, msg *mqttsub.Message, file *DroppedFile) (*Execution, error) {
+	release, ok := e.registry.acquire(ctx, done, e.Name())
+	if !ok {
+		return nil, ErrDraining
+	}
+	defer release()
+
+	execCtx, exec, def, err := e.begin(trigger)
+	if err != nil {
+		return nil, err
+	}
+	if trigger == TriggerSchedule {
+		e.mux.Lock()
+		e.next = time.Time{}
+		e.mux.Unlock()
+	}
+	if msg != nil {
+		exec.Topic = msg.Topic
+		execCtx = withMessage(execCtx, msg)
+	}
+	if file != nil {
+		exec.File = file.Name
+		execCtx = withDroppedFile(execCtx, file)
+	}
+	e.execute(execCtx, exec, def)
+	return exec, nil
+}
+
+// schedule records when the pipeline should next execute and returns how long
+// to wait until then. If lastRun is zero, that's the pipeline's first execution
+// since its schedule started; otherwise, it's the one after lastRun, delayed
+// according to the pipeline's FailurePolicy if its executions are failing.
+// If the pipeline doesn't have a Schedule, it returns false.
+//
+// If the entry has been stopped, the next execution isn't recorded.
+func (e *Entry) schedule(started, lastRun time.Time) (time.Duration, bool) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.def.Schedule == nil {
+		e.next = time.Time{}
+		return 0, false
+	}
+
+	var next time.Time
+	if lastRun.IsZero() {
+		next = e.def.Schedule.First(started)
+	} else {
+		next = e.def.Schedule.Next(lastRun)
+		next = e.def.FailurePolicy.backoff(lastRun, next, e.failures)
+	}
+	if e.breakerState(time.Now()) == BreakerOpen {
+		if cooldown := e.openedAt.Add(e.def.FailurePolicy.BreakerCooldown); next.Before(cooldown) {
+			next = cooldown
+		}
+	}
+	if e.stop == nil {
+		return time.Until(next), true
+	}
+
+	e.next = next.UTC()
+	e.log().WithFields(log.Fields{
+		"schedule":      e.def.Schedule.String(),
+		"nextExecution": e.next.Format(time.RFC3339),
+		"failures":      e.failures,
+	}).Debug("Scheduled pipeline.")
+	return time.Until(next), true
+}
+
+// begin marks the pipeline as running and returns a new Execution for the
+// given trigger along with the context and definition it should use. If the
+// pipeline is already running, it returns ErrAlreadyRunning, and if the
+// Registry is draining, it returns ErrDraining.
+func (e *Entry) begin(trigger Trigger) (context.Context, *Execution, Definition, error) {
+	r := e.registry
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if r.draining {
+		return nil, nil, Definition{}, ErrDraining
+	}
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.running {
+		return nil, nil, Definition{}, ErrAlreadyRunning
+	}
+	e.running = true
+	e.finished = make(chan struct{})
+	r.inFlight.Add(1)
+	return r.ctx, &Execution{
+		ID:        uuid.New(),
+		Pipeline:  e.name,
+		Trigger:   trigger,
+		State:     goplumber.Running,
+		StartedAt: time.Now().UTC(),
+	}, e.def, nil
+}
+
+// execute runs the pipeline's definition and records the result in exec.
+func (e *Entry) execute(ctx context.Context, exec *Execution, def Definition) {
+	defer e.registry.inFlight.Done()
+	ctx, rec := withRecorder(ctx, def.Config)
+	rec.log = e.log().WithField("runID", exec.ID)
+	ctx, span := startRunSpan(ctx, exec)
+
+	var result goplumber.Status
+	defer func() {
+		if r := recover(); r != nil {
+			if err, isErr := r.(error); isErr {
+				result.Err = errors.Wrap(err, "pipeline panicked")
+			} else {
+				result.Err = errors.Errorf("pipeline panicked: %v", r)
+			}
+			result.State = goplumber.Failed
+			result.CompletedAt = time.Now().UTC()
+		}
+		endRunSpan(span, result)
+		e.record(exec, result, rec.results(def.Config), rec.digest(def.Config))
+	}()
+
+	var cancel context.CancelFunc
+	if limit := timeout(def.Config); limit > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limit)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	rec.log.Debugf("Starting pipeline %s.", e.Name())
+	result = def.Pipeline.Execute(ctx)
+}
+
+// record stores the result of an execution, whose output has the given digest,
+// logs it, adds it to the Registry's History, and triggers the pipelines that
+// depend on it.
+func (e *Entry) record(exec *Execution, result goplumber.Status, tasks []TaskResult, output string) {
+	if !result.StartedAt.IsZero() {
+		exec.StartedAt = result.StartedAt
+	}
+	exec.State = result.State
+	exec.CompletedAt = result.CompletedAt
+	exec.Duration = exec.CompletedAt.Sub(exec.StartedAt).String()
+	if result.Err != nil {
+		exec.Error = result.Err.Error()
+	}
+	exec.Tasks = tasks
+
+	succeeded := result.State == goplumber.Success
+	e.mux.Lock()
+	if succeeded {
+		exec.OutputChanged = output != e.output
+		e.output = output
+		e.lastOK = exec.CompletedAt
+	}
+	e.running = false
+	close(e.finished)
+	e.last = exec
+	from, to := e.recordFailure(result.State == goplumber.Failed, time.Now())
+	failures := e.failures
+	cooldown := e.def.FailurePolicy.BreakerCooldown
+	e.mux.Unlock()
+
+	entry := e.log().WithFields(log.Fields{
+		"runID":    exec.ID,
+		"status":   result.State,
+		"duration": exec.Duration,
+		"error":    result.Err,
+	})
+	if result.State == goplumber.Failed {
+		entry.Error("Pipeline failed.")
+	} else {
+		entry.Info("Pipeline completed successfully.")
+	}
+
+	switch {
+	case to == BreakerOpen && from != BreakerOpen:
+		e.log().Warnf("Pipeline failed %d times in a row; "+
+			"its circuit breaker is open, so its scheduled executions will stop for %s.",
+			failures, cooldown)
+	case to == BreakerClosed && from != BreakerClosed:
+		e.log().Info("Pipeline succeeded; its circuit breaker is closed.")
+	}
+
+	observeExecution(exec)
+	e.registry.mux.RLock()
+	history := e.registry.history
+	_, replaced := e.registry.entries[e.name]
+	e.registry.mux.RUnlock()
+	if history != nil {
+		history.add(*exec)
+	}
+
+	// the pipeline was removed while it was running, and this was its last
+	// use of its logger, unless another pipeline with its name was added
+	e.mux.RLock()
+	removed := e.removed
+	e.mux.RUnlock()
+	if removed && !replaced {
+		logger.RemoveTagged(e.name)
+	}
+
+	e.registry.runDependents(e.Name(), succeeded, exec.OutputChanged)
+}
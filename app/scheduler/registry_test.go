@@ -0,0 +1,408 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+)
+
+// testPipe writes its output, or fails if it has an error.
+type testPipe struct {
+	Output string `json:"output"`
+	Fail   string `json:"fail"`
+}
+
+func (tp *testPipe) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	if tp.Fail != "" {
+		return errors.New(tp.Fail)
+	}
+	_, err := w.Write([]byte(tp.Output))
+	return err
+}
+
+// blockingPipe waits for its channel to close before completing.
+type blockingPipe chan struct{}
+
+func (bp blockingPipe) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	select {
+	case <-bp:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// testClient is a Client for a task type used by a test's pipelines.
+type testClient struct {
+	taskType string
+	client   goplumber.Client
+}
+
+// pipeClient returns a testClient that uses pipe for every task of its type.
+func pipeClient(taskType string, pipe goplumber.Pipe) testClient {
+	return testClient{taskType, goplumber.PipeFunc(
+		func(task *goplumber.Task) (goplumber.Pipe, error) { return pipe, nil })}
+}
+
+// getTestPlumber returns an instrumented Plumber with "test" tasks, which
+// are testPipes, and the given clients.
+func getTestPlumber(clients ...testClient) goplumber.Plumber {
+	p := goplumber.NewPlumber()
+	p.SetClient("test", goplumber.SimpleJSONPipe(func() goplumber.Pipe { return &testPipe{} }))
+	for _, c := range clients {
+		p.SetClient(c.taskType, c.client)
+	}
+	Instrument(p)
+	return p
+}
+
+func newTestPipeline(w *expect.TWrapper, plumber goplumber.Plumber, conf string) (*goplumber.PipelineConfig, *goplumber.Pipeline) {
+	w.Helper()
+	pConf := &goplumber.PipelineConfig{}
+	w.ShouldSucceed(json.Unmarshal([]byte(conf), pConf))
+	p := w.ShouldHaveResult(plumber.NewPipeline(pConf)).(*goplumber.Pipeline)
+	return pConf, p
+}
+
+// defOption sets one of a test Definition's triggers.
+type defOption func(def *Definition)
+
+func withAfter(deps ...Dependency) defOption {
+	return func(def *Definition) { def.After = deps }
+}
+
+func withSubscription(topic string) defOption {
+	return func(def *Definition) {
+		def.Subscription = &Subscription{Client: "gwMQTT", Topic: topic, QoS: 1}
+	}
+}
+
+func withWebhook(hook *Webhook) defOption {
+	return func(def *Definition) { def.Webhook = hook }
+}
+
+func withDropFolder(folder *DropFolder) defOption {
+	return func(def *Definition) { def.DropFolder = folder }
+}
+
+// newTestDefinition returns a Definition for the pipeline configuration, as
+// though it were loaded from source, with the schedule and other triggers.
+func newTestDefinition(w *expect.TWrapper, plumber goplumber.Plumber, source, conf string, schedule Schedule, opts ...defOption) Definition {
+	w.Helper()
+	pConf, p := newTestPipeline(w, plumber, conf)
+	def := Definition{Source: source, Config: pConf, Pipeline: p, Schedule: schedule}
+	for _, opt := range opts {
+		opt(&def)
+	}
+	return def
+}
+
+// namedPipeline returns the configuration of a pipeline with the given name,
+// which outputs its name.
+func namedPipeline(name string) string {
+	return `{
+	  "name": "` + name + `",
+	  "tasks": { "only": { "type": "test", "raw": { "output": "` + name + `" } } }
+	}`
+}
+
+// scheduledAfterRun returns true if the pipeline has executed and its next
+// execution has been scheduled.
+func scheduledAfterRun(s Status) bool {
+	return s.LastExecution != nil && s.NextExecution != nil
+}
+
+const okPipeline = `{
+  "name": "ok",
+  "description": "always succeeds",
+  "timeoutSeconds": 5,
+  "tasks": {
+    "first": { "type": "test", "raw": { "output": "hello" } },
+    "second": {
+      "type": "test",
+      "raw": { "output": "world" },
+      "ifSuccessful": [ "first" ]
+    }
+  }
+}`
+
+const failPipeline = `{
+  "name": "fail",
+  "tasks": {
+    "broken": { "type": "test", "raw": { "fail": "no good" } }
+  }
+}`
+
+func TestRegistry_Add(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	conf, p := newTestPipeline(w, plumber, okPipeline)
+	w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Minute)}))
+	w.ShouldFail(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Minute)}))
+
+	conf, p = newTestPipeline(w, plumber, failPipeline)
+	w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Minute)}))
+
+	entries := reg.List()
+	w.ShouldHaveLength(entries, 2)
+	w.ShouldBeEqual(entries[0].Name(), "fail")
+	w.ShouldBeEqual(entries[1].Name(), "ok")
+
+	_, ok := reg.Get("missing")
+	w.ShouldBeFalse(ok)
+}
+
+func TestEntry_Status(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	conf, p := newTestPipeline(w, plumber, okPipeline)
+	ok := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Minute)})).(*Entry)
+	conf, p = newTestPipeline(w, plumber, failPipeline)
+	fail := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Minute)})).(*Entry)
+
+	s := ok.Status()
+	w.ShouldBeEqual(s.Description, "always succeeds")
+	w.ShouldBeEqual(s.Interval, "1m0s")
+	w.ShouldBeEqual(s.TimeoutSeconds, 5)
+	w.ShouldContain(s.Tasks, []string{"first", "second"})
+	w.ShouldBeNil(s.LastExecution)
+	w.ShouldBeEqual(fail.Status().TimeoutSeconds, 30)
+
+	w.ShouldHaveResult(ok.Run(true))
+	s = ok.Status()
+	w.ShouldNotBeNil(s.LastExecution)
+	w.ShouldBeEqual(s.LastExecution.State, goplumber.Success)
+	w.ShouldBeEmptyStr(s.LastExecution.Error)
+
+	w.ShouldHaveResult(fail.Run(true))
+	s = fail.Status()
+	w.ShouldNotBeNil(s.LastExecution)
+	w.ShouldBeEqual(s.LastExecution.State, goplumber.Failed)
+	w.ShouldContainStr(s.LastExecution.Error, "no good")
+}
+
+func TestRegistry_Start(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	reg := NewRegistry()
+	conf, p := newTestPipeline(w, getTestPlumber(), okPipeline)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !scheduledAfterRun(e.Status()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s := e.Status()
+	w.ShouldNotBeNil(s.LastExecution)
+	w.ShouldNotBeNil(s.NextExecution)
+	w.ShouldBeTrue(s.NextExecution.After(s.LastExecution.CompletedAt))
+}
+
+func TestEntry_Run(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	reg := NewRegistry()
+
+	conf, p := newTestPipeline(w, getTestPlumber(), `{
+	  "name": "partial",
+	  "tasks": {
+	    "first": { "type": "test", "raw": { "output": "hello" } },
+	    "second": { "type": "test", "raw": { "fail": "oops" }, "ifSuccessful": [ "first" ] },
+	    "third": { "type": "test", "ifSuccessful": [ "second" ] }
+	  }
+	}`)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldNotBeEmptyStr(exec.ID)
+	w.ShouldBeEqual(exec.State, goplumber.Failed)
+	w.ShouldContainStr(exec.Error, "oops")
+	w.ShouldHaveLength(exec.Tasks, 3)
+
+	w.ShouldBeEqual(exec.Tasks[0].Name, "first")
+	w.ShouldBeEqual(exec.Tasks[0].Type, "test")
+	w.ShouldBeEqual(exec.Tasks[0].State, goplumber.Success)
+	w.ShouldBeEmptyStr(exec.Tasks[0].Error)
+	w.ShouldNotBeEmptyStr(exec.Tasks[0].Duration)
+
+	w.ShouldBeEqual(exec.Tasks[1].Name, "second")
+	w.ShouldBeEqual(exec.Tasks[1].State, goplumber.Failed)
+	w.ShouldContainStr(exec.Tasks[1].Error, "oops")
+
+	w.ShouldBeEqual(exec.Tasks[2].Name, "third")
+	w.ShouldBeEqual(exec.Tasks[2].State, goplumber.Waiting)
+
+	w.ShouldBeEqual(e.Status().LastExecution.ID, exec.ID)
+}
+
+func TestEntry_RunAsync(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	block := make(blockingPipe)
+	plumber := getTestPlumber(pipeClient("block", block))
+
+	reg := NewRegistry()
+	conf, p := newTestPipeline(w, plumber, `{
+	  "name": "slow",
+	  "tasks": { "wait": { "type": "block" } }
+	}`)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+
+	exec := w.ShouldHaveResult(e.Run(false)).(*Execution)
+	w.ShouldBeEqual(exec.State, goplumber.Running)
+	w.ShouldBeTrue(e.Status().Running)
+
+	_, err := e.Run(true)
+	w.ShouldBeEqual(err, ErrAlreadyRunning)
+
+	close(block)
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Status().Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s := e.Status()
+	w.ShouldBeFalse(s.Running)
+	w.ShouldBeEqual(s.LastExecution.ID, exec.ID)
+	w.ShouldBeEqual(s.LastExecution.State, goplumber.Success)
+	w.ShouldHaveLength(s.LastExecution.Tasks, 1)
+}
+
+func TestEntry_RunDuringSchedule(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	block := make(blockingPipe)
+	plumber := getTestPlumber(pipeClient("block", block))
+
+	reg := NewRegistry()
+	conf, p := newTestPipeline(w, plumber, `{
+	  "name": "slow",
+	  "tasks": { "wait": { "type": "block" } }
+	}`)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+
+	// the first scheduled execution is due while the pipeline is run on demand
+	onDemand := w.ShouldHaveResult(e.Run(false)).(*Execution)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	w.ShouldBeNil(e.Status().LastExecution)
+
+	// so it runs once the other completes
+	close(block)
+	w.ShouldBeEqual(waitForExecution(w, e, onDemand).Trigger, TriggerSchedule)
+}
+
+func TestEntry_Pause(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "scheduler")).(string)
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "pipelines.json")
+
+	reg := NewRegistry()
+	w.ShouldSucceed(reg.UseStateFile(statePath))
+	conf, p := newTestPipeline(w, getTestPlumber(), okPipeline)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+	w.ShouldBeFalse(e.Status().Paused)
+
+	w.ShouldSucceed(e.Pause())
+	w.ShouldBeTrue(e.Status().Paused)
+
+	// a paused pipeline skips its scheduled executions
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	s := e.Status()
+	w.ShouldBeNil(s.LastExecution)
+	w.ShouldBeNil(s.NextExecution)
+
+	// but it can still be run on demand
+	w.ShouldHaveResult(e.Run(true))
+
+	// the paused state is loaded by new registries
+	reg2 := NewRegistry()
+	conf, p = newTestPipeline(w, getTestPlumber(), okPipeline)
+	e2 := w.ShouldHaveResult(reg2.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+	w.ShouldBeFalse(e2.Status().Paused)
+	w.ShouldSucceed(reg2.UseStateFile(statePath))
+	w.ShouldBeTrue(e2.Status().Paused)
+
+	w.ShouldSucceed(e2.Resume())
+	w.ShouldBeFalse(e2.Status().Paused)
+
+	reg3 := NewRegistry()
+	w.ShouldSucceed(reg3.UseStateFile(statePath))
+	conf, p = newTestPipeline(w, getTestPlumber(), okPipeline)
+	e3 := w.ShouldHaveResult(reg3.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+	w.ShouldBeFalse(e3.Status().Paused)
+}
+
+func TestRegistry_Drain(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	block := make(blockingPipe)
+	plumber := getTestPlumber(pipeClient("block", block))
+
+	newRegistry := func() (*Registry, *Entry, context.CancelFunc) {
+		reg := NewRegistry()
+		conf, p := newTestPipeline(w, plumber, `{
+		  "name": "slow",
+		  "timeoutSeconds": -1,
+		  "tasks": { "wait": { "type": "block" } }
+		}`)
+		e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry)
+		ctx, cancel := context.WithCancel(context.Background())
+		reg.Start(ctx)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for !e.Status().Running && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.ShouldBeTrue(e.Status().Running)
+		return reg, e, cancel
+	}
+
+	// executions that don't complete in time are canceled
+	reg, e, cancel := newRegistry()
+	defer cancel()
+	w.ShouldBeEqual(reg.Drain(50*time.Millisecond), []string{"slow"})
+	s := e.Status()
+	w.ShouldBeFalse(s.Running)
+	w.ShouldBeEqual(s.LastExecution.State, goplumber.Failed)
+	w.ShouldBeNil(s.NextExecution)
+	_, err := e.Run(true)
+	w.ShouldBeEqual(err, ErrDraining)
+
+	// executions that complete in time aren't affected
+	reg, e, cancel = newRegistry()
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(block)
+	}()
+	w.ShouldBeEmpty(reg.Drain(5 * time.Second))
+	s = e.Status()
+	w.ShouldBeEqual(s.LastExecution.State, goplumber.Success)
+	w.ShouldBeNil(s.NextExecution)
+}
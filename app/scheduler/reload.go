@@ -0,0 +1,268 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+)
+
+// Reload describes an attempt to replace the Registry's pipeline definitions.
+type Reload struct {
+	Time time.Time `json:"time"`
+	// Error is set if the reload failed, in which case none of the pipelines
+	// were changed.
+	Error string `json:"error,omitempty"`
+	// Added, Removed, Updated, and Unchanged list the names of the pipelines
+	// that were affected. Updated pipelines are those whose definitions
+	// changed; every pipeline uses a newly loaded definition, though, since
+	// changes to templates or custom task types don't alter the definitions.
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	// Failed maps the source of each pipeline that couldn't be loaded to the
+	// reason why. Pipelines loaded from those sources keep their previous
+	// definitions.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// Update replaces the Registry's pipeline definitions.
+//
+// Pipelines are matched by name. An existing pipeline uses its new definition
+// starting with its next execution; an execution already in progress completes
+// with the previous one. New pipelines are added, and started if the Registry
+// has been started, and pipelines without a new definition are removed, after
+// any execution in progress completes.
+//
+// failed maps the Source of each definition that couldn't be loaded to the
+// reason why. Existing pipelines loaded from those sources keep their current
+// definitions and report the error in their Status.
+func (r *Registry) Update(defs []Definition, failed map[string]error) Reload {
+	return r.update(defs, failed, false)
+}
+
+// Replace replaces the Registry's pipeline definitions like Update, but only if
+// every definition loaded and is valid. Otherwise, the Reload has an Error and
+// lists the failures, and every pipeline keeps its current definition.
+func (r *Registry) Replace(defs []Definition, failed map[string]error) Reload {
+	return r.update(defs, failed, true)
+}
+
+// update replaces the Registry's pipeline definitions. If strict is true and
+// any of them failed to load or are invalid, none of them are replaced.
+func (r *Registry) update(defs []Definition, failed map[string]error, strict bool) Reload {
+	reload := Reload{
+		Time:      time.Now().UTC(),
+		Added:     []string{},
+		Removed:   []string{},
+		Updated:   []string{},
+		Unchanged: []string{},
+	}
+
+	r.mux.Lock()
+	defer func() {
+		r.lastReload = &reload
+		r.mux.Unlock()
+	}()
+
+	failures := make(map[string]error, len(failed))
+	for source, err := range failed {
+		failures[source] = err
+	}
+
+	candidates := make(map[string]Definition, len(defs))
+	for _, def := range defs {
+		name := def.Config.Name
+		if _, dup := candidates[name]; dup {
+			failures[def.Source] = errors.Errorf("a pipeline named %q is already loaded", name)
+			continue
+		}
+		candidates[name] = def
+	}
+	r.removeInvalidDependencies(candidates, failures)
+
+	if strict && len(failures) != 0 {
+		reload = Reload{
+			Time:   reload.Time,
+			Error:  "some pipelines failed to load, so none of them were changed",
+			Failed: failedSources(failures),
+		}
+		for _, e := range r.entries {
+			if err, ok := failures[e.Definition().Source]; ok {
+				e.setLoadError(err)
+			}
+		}
+		log.WithField("failed", reload.Failed).Error("Failed to reload pipelines; " +
+			"they will continue using their previous definitions.")
+		return reload
+	}
+
+	keep := make(map[string]bool, len(candidates))
+	for _, def := range defs {
+		name := def.Config.Name
+		if _, valid := candidates[name]; keep[name] || !valid {
+			continue
+		}
+		keep[name] = true
+
+		e, exists := r.entries[name]
+		if !exists {
+			if _, err := r.add(def); err != nil {
+				failures[def.Source] = err
+				continue
+			}
+			reload.Added = append(reload.Added, name)
+			continue
+		}
+
+		if e.update(def) {
+			reload.Updated = append(reload.Updated, name)
+		} else {
+			reload.Unchanged = append(reload.Unchanged, name)
+		}
+	}
+
+	for name, e := range r.entries {
+		if keep[name] {
+			continue
+		}
+
+		source := e.Definition().Source
+		if err, ok := failures[source]; ok {
+			e.setLoadError(err)
+			e.log().WithError(err).Errorf("Failed to reload pipeline %s from %q; "+
+				"it will continue using its previous definition.", name, source)
+			continue
+		}
+
+		delete(r.entries, name)
+		e.remove()
+		reload.Removed = append(reload.Removed, name)
+	}
+
+	reload.Failed = failedSources(failures)
+
+	for _, names := range [][]string{reload.Added, reload.Removed, reload.Updated, reload.Unchanged} {
+		sort.Strings(names)
+	}
+	log.WithFields(log.Fields{
+		"added":   reload.Added,
+		"removed": reload.Removed,
+		"updated": reload.Updated,
+		"failed":  len(reload.Failed),
+	}).Info("Loaded pipelines.")
+	return reload
+}
+
+// failedSources returns the reason each source failed to load, or nil if none
+// of them failed.
+func failedSources(failures map[string]error) map[string]string {
+	if len(failures) == 0 {
+		return nil
+	}
+	failed := make(map[string]string, len(failures))
+	for source, err := range failures {
+		failed[source] = err.Error()
+	}
+	return failed
+}
+
+// ReloadFailed records an attempt to reload the pipelines that failed before
+// any of their definitions were loaded. The pipelines are unaffected.
+func (r *Registry) ReloadFailed(err error) Reload {
+	reload := Reload{Time: time.Now().UTC(), Error: err.Error()}
+
+	r.mux.Lock()
+	r.lastReload = &reload
+	r.mux.Unlock()
+
+	log.WithError(err).Error("Failed to reload pipelines; " +
+		"they will continue using their previous definitions.")
+	return reload
+}
+
+// LastReload returns the result of the latest call to Update, Replace, or
+// ReloadFailed.
+func (r *Registry) LastReload() (Reload, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if r.lastReload == nil {
+		return Reload{}, false
+	}
+	return *r.lastReload, true
+}
+
+// update replaces the pipeline's definition, returning true if it changed. If
+// its Subscription or DropFolder changed and its schedule is running, it's
+// resubscribed or its folder is watched again.
+//
+// The caller must hold the Registry's write lock.
+func (e *Entry) update(def Definition) bool {
+	e.mux.Lock()
+	old := e.def
+	e.def = def
+	e.loadError = ""
+	running := e.stop != nil
+	e.mux.Unlock()
+
+	subChanged := !reflect.DeepEqual(old.Subscription, def.Subscription)
+	if subChanged && running {
+		e.subscribe()
+	}
+	folderChanged := !reflect.DeepEqual(old.DropFolder, def.DropFolder)
+	if folderChanged && running {
+		e.watchFolder()
+	}
+
+	scheduleChanged := scheduleString(old.Schedule) != scheduleString(def.Schedule) ||
+		old.FailurePolicy != def.FailurePolicy
+	if scheduleChanged {
+		select {
+		case e.reset <- struct{}{}:
+		default: // a reset is already pending
+		}
+	}
+	return old.Source != def.Source || scheduleChanged || subChanged || folderChanged ||
+		!reflect.DeepEqual(old.After, def.After) ||
+		!reflect.DeepEqual(old.Webhook, def.Webhook) || !sameConfig(old, def)
+}
+
+// remove stops the schedule of a pipeline that's been removed from the
+// Registry, and discards its logger. If it's running, its logger is discarded
+// after the execution completes, instead.
+func (e *Entry) remove() {
+	e.stopSchedule()
+	e.mux.Lock()
+	e.removed = true
+	running := e.running
+	e.mux.Unlock()
+	if !running {
+		logger.RemoveTagged(e.name)
+	}
+}
+
+func (e *Entry) setLoadError(err error) {
+	e.mux.Lock()
+	e.loadError = err.Error()
+	e.mux.Unlock()
+}
+
+// sameConfig returns true if both definitions have the same configuration.
+func sameConfig(a, b Definition) bool {
+	aData, aErr := json.Marshal(a.Config)
+	bData, bErr := json.Marshal(b.Config)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
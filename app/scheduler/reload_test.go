@@ -0,0 +1,215 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
+)
+
+func TestRegistry_Update(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour))))
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "fail.json", failPipeline, Every(time.Hour))))
+	_, ok := reg.LastReload()
+	w.ShouldBeFalse(ok)
+
+	reload := reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", `{
+		  "name": "ok",
+		  "description": "still succeeds",
+		  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+		}`, Every(time.Hour)),
+		newTestDefinition(w, plumber, "new.json", `{
+		  "name": "new",
+		  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+		}`, Every(time.Hour)),
+	}, map[string]error{"fail.json": errors.New("bad definition")})
+
+	w.ShouldBeEmptyStr(reload.Error)
+	w.ShouldBeEqual(reload.Added, []string{"new"})
+	w.ShouldBeEqual(reload.Updated, []string{"ok"})
+	w.ShouldBeEqual(reload.Removed, []string{})
+	w.ShouldBeEqual(reload.Unchanged, []string{})
+	w.ShouldBeEqual(reload.Failed, map[string]string{"fail.json": "bad definition"})
+	last, ok := reg.LastReload()
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(last, reload)
+
+	// the pipeline that failed to load keeps its previous definition
+	fail, ok := reg.Get("fail")
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(fail.Status().LoadError, "bad definition")
+	w.ShouldContain(fail.Status().Tasks, []string{"broken"})
+
+	e, ok := reg.Get("ok")
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(e.Status().Description, "still succeeds")
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldHaveLength(exec.Tasks, 1)
+	w.ShouldBeEqual(exec.Tasks[0].Name, "only")
+
+	// reloading an identical definition leaves it unchanged, and pipelines
+	// without definitions are removed, along with their log levels
+	logger.SetTagLevel("new", logger.DebugLevel)
+	reload = reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", `{
+		  "name": "ok",
+		  "description": "still succeeds",
+		  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+		}`, Every(time.Hour)),
+	}, nil)
+	w.ShouldBeEqual(reload.Unchanged, []string{"ok"})
+	w.ShouldBeEqual(reload.Removed, []string{"fail", "new"})
+	w.ShouldBeNil(reload.Failed)
+	w.ShouldHaveLength(reg.List(), 1)
+	_, hasLevel := logger.TagLevels()["new"]
+	w.ShouldBeFalse(hasLevel)
+
+	// a failure before any pipelines are loaded leaves them alone
+	reload = reg.ReloadFailed(errors.New("invalid configuration"))
+	w.ShouldBeEqual(reload.Error, "invalid configuration")
+	w.ShouldHaveLength(reg.List(), 1)
+	last, _ = reg.LastReload()
+	w.ShouldBeEqual(last.Error, "invalid configuration")
+}
+
+func TestRegistry_Replace(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour))))
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "fail.json", failPipeline, Every(time.Hour))))
+
+	updated := newTestDefinition(w, plumber, "ok.json", `{
+	  "name": "ok",
+	  "description": "still succeeds",
+	  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+	}`, Every(time.Hour))
+	added := newTestDefinition(w, plumber, "new.json", `{
+	  "name": "new",
+	  "tasks": { "only": { "type": "test", "raw": { "output": "hi" } } }
+	}`, Every(time.Hour))
+
+	// if any pipeline fails to load, none of them change
+	reload := reg.Replace([]Definition{updated, added},
+		map[string]error{"fail.json": errors.New("bad definition")})
+	w.ShouldNotBeEmptyStr(reload.Error)
+	w.ShouldBeEqual(reload.Failed, map[string]string{"fail.json": "bad definition"})
+	w.ShouldBeNil(reload.Added)
+	w.ShouldHaveLength(reg.List(), 2)
+	e, _ := reg.Get("ok")
+	w.ShouldBeEqual(e.Status().Description, "always succeeds")
+	fail, _ := reg.Get("fail")
+	w.ShouldBeEqual(fail.Status().LoadError, "bad definition")
+	last, _ := reg.LastReload()
+	w.ShouldBeEqual(last, reload)
+
+	// as with invalid dependencies
+	dependent := newTestDefinition(w, plumber, "dependent.json", namedPipeline("dependent"), nil,
+		withAfter(Dependency{Pipeline: "missing", Condition: OnSuccess}))
+	reload = reg.Replace([]Definition{updated, added, dependent}, nil)
+	w.ShouldNotBeEmptyStr(reload.Error)
+	w.ShouldContain(reload.Failed, []string{"dependent.json"})
+	w.ShouldHaveLength(reg.List(), 2)
+
+	// otherwise, they're replaced as they are by Update
+	reload = reg.Replace([]Definition{updated, added}, nil)
+	w.ShouldBeEmptyStr(reload.Error)
+	w.ShouldBeEqual(reload.Added, []string{"new"})
+	w.ShouldBeEqual(reload.Updated, []string{"ok"})
+	w.ShouldBeEqual(reload.Removed, []string{"fail"})
+	w.ShouldBeEqual(e.Status().Description, "still succeeds")
+}
+
+func TestRegistry_UpdateWhileRunning(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	block := make(blockingPipe)
+	plumber := getTestPlumber(pipeClient("block", block))
+
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "slow.json", `{
+	  "name": "slow",
+	  "tasks": { "wait": { "type": "block" } }
+	}`, Every(time.Hour)))).(*Entry)
+	w.ShouldHaveResult(e.Run(false))
+
+	reload := reg.Update([]Definition{newTestDefinition(w, plumber, "slow.json", `{
+	  "name": "slow",
+	  "tasks": { "fast": { "type": "test" } }
+	}`, Every(time.Hour))}, nil)
+	w.ShouldBeEqual(reload.Updated, []string{"slow"})
+	w.ShouldBeTrue(e.Status().Running)
+
+	// the execution in progress completes with the old definition
+	close(block)
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Status().Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s := e.Status()
+	w.ShouldBeFalse(s.Running)
+	w.ShouldHaveLength(s.LastExecution.Tasks, 1)
+	w.ShouldBeEqual(s.LastExecution.Tasks[0].Name, "wait")
+
+	exec := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldHaveLength(exec.Tasks, 1)
+	w.ShouldBeEqual(exec.Tasks[0].Name, "fast")
+}
+
+func TestRegistry_UpdateSchedule(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	plumber := getTestPlumber()
+	reg := NewRegistry()
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)))).(*Entry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !scheduledAfterRun(e.Status()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	first := e.Status().LastExecution
+	w.ShouldNotBeNil(first)
+
+	// shortening the interval reschedules the next execution
+	reg.Update([]Definition{newTestDefinition(w, plumber, "ok.json", okPipeline, Every(10*time.Millisecond))}, nil)
+	deadline = time.Now().Add(5 * time.Second)
+	for e.Status().LastExecution.ID == first.ID && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.ShouldNotBeEqual(e.Status().LastExecution.ID, first.ID)
+
+	// pipelines added after the Registry starts are scheduled, too
+	reg.Update([]Definition{
+		newTestDefinition(w, plumber, "ok.json", okPipeline, Every(time.Hour)),
+		newTestDefinition(w, plumber, "fail.json", failPipeline, Every(time.Hour)),
+	}, nil)
+	fail, ok := reg.Get("fail")
+	w.ShouldBeTrue(ok)
+	deadline = time.Now().Add(5 * time.Second)
+	for !scheduledAfterRun(fail.Status()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.ShouldNotBeNil(fail.Status().LastExecution)
+
+	// removed pipelines are no longer scheduled
+	reg.Update(nil, nil)
+	w.ShouldHaveLength(reg.List(), 0)
+	w.ShouldBeNil(fail.Status().NextExecution)
+}
@@ -0,0 +1,267 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule determines when a pipeline executes.
+type Schedule interface {
+	// First returns when the pipeline should first execute after its
+	// schedule starts at the given time.
+	First(start time.Time) time.Time
+	// Next returns when the pipeline should execute after an execution that
+	// completed at the given time.
+	Next(completed time.Time) time.Time
+	// String describes the schedule.
+	String() string
+}
+
+// Every returns a Schedule that executes a pipeline as soon as its schedule
+// starts, then again each time the interval elapses after an execution
+// completes.
+func Every(interval time.Duration) Schedule {
+	return intervalSchedule(interval)
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) First(start time.Time) time.Time {
+	return start
+}
+
+func (s intervalSchedule) Next(completed time.Time) time.Time {
+	return completed.Add(time.Duration(s))
+}
+
+func (s intervalSchedule) String() string {
+	return "every " + time.Duration(s).String()
+}
+
+// cronParser accepts standard 5-field cron expressions, which may have an
+// additional leading field for seconds, as well as descriptors like "@daily".
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour |
+	cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Cron returns a Schedule that executes a pipeline at the times matching the
+// cron expression, which are evaluated in the given time zone, such as
+// "America/Chicago". If the time zone is empty, the service's local time zone
+// is used.
+func Cron(spec, timezone string) (Schedule, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time zone %q", timezone)
+		}
+	}
+
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return nil, errors.Errorf("invalid cron expression %q: "+
+			"use the trigger's timezone to set its time zone", spec)
+	}
+
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %q", spec)
+	}
+	return &cronSchedule{spec: spec, location: location, schedule: schedule}, nil
+}
+
+type cronSchedule struct {
+	spec     string
+	location *time.Location
+	schedule cron.Schedule
+}
+
+func (s *cronSchedule) First(start time.Time) time.Time {
+	return s.Next(start)
+}
+
+func (s *cronSchedule) Next(completed time.Time) time.Time {
+	return s.schedule.Next(completed.In(s.location))
+}
+
+func (s *cronSchedule) String() string {
+	return "cron " + s.spec + " " + s.location.String()
+}
+
+// StartOptions adjust when a Schedule executes a pipeline.
+type StartOptions struct {
+	// RunOnStart executes the pipeline as soon as its schedule starts, after
+	// the InitialDelay, rather than waiting for the schedule's first time.
+	RunOnStart   bool
+	InitialDelay time.Duration
+	// Jitter delays each execution by a random amount up to this duration,
+	// so pipelines with the same schedule don't all execute at once.
+	Jitter time.Duration
+}
+
+// WithStartOptions returns a Schedule that executes a pipeline according to
+// the given Schedule, adjusted by the options.
+func WithStartOptions(schedule Schedule, opts StartOptions) Schedule {
+	return &startSchedule{Schedule: schedule, opts: opts}
+}
+
+type startSchedule struct {
+	Schedule
+	opts StartOptions
+}
+
+func (s *startSchedule) First(start time.Time) time.Time {
+	if s.opts.RunOnStart {
+		return s.jitter(start.Add(s.opts.InitialDelay))
+	}
+	// an interval schedule's first time is its start, so use the time after
+	return s.jitter(s.Schedule.Next(start))
+}
+
+func (s *startSchedule) Next(completed time.Time) time.Time {
+	return s.jitter(s.Schedule.Next(completed))
+}
+
+func (s *startSchedule) jitter(t time.Time) time.Time {
+	if s.opts.Jitter <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(s.opts.Jitter))))
+}
+
+func (s *startSchedule) String() string {
+	desc := s.Schedule.String()
+	if s.opts.RunOnStart {
+		desc += fmt.Sprintf(", run on start after %s", s.opts.InitialDelay)
+	} else {
+		desc += ", not on start"
+	}
+	if s.opts.Jitter > 0 {
+		desc += fmt.Sprintf(", jitter %s", s.opts.Jitter)
+	}
+	return desc
+}
+
+// scheduleString describes the Schedule, which may be nil.
+func scheduleString(schedule Schedule) string {
+	if schedule == nil {
+		return ""
+	}
+	return schedule.String()
+}
+
+// interval returns the interval of an interval Schedule.
+func interval(schedule Schedule) (time.Duration, bool) {
+	if s, ok := schedule.(*startSchedule); ok {
+		schedule = s.Schedule
+	}
+	d, ok := schedule.(intervalSchedule)
+	return time.Duration(d), ok
+}
+
+// triggerConfig is the part of a pipeline's configuration that determines
+// when it executes. goplumber only handles intervals, so this is parsed
+// separately from the rest of the pipeline.
+type triggerConfig struct {
+	Trigger triggerSettings `json:"trigger"`
+}
+
+type triggerSettings struct {
+	Interval     *goplumber.Interval `json:"interval"`
+	Cron         string              `json:"cron"`
+	Timezone     string              `json:"timezone"`
+	RunOnStart   *bool               `json:"runOnStart"`
+	InitialDelay *goplumber.Interval `json:"initialDelay"`
+	Jitter       *goplumber.Interval `json:"jitter"`
+	After        []Dependency        `json:"after"`
+	MQTT         *Subscription       `json:"mqtt"`
+	Webhook      *Webhook            `json:"webhook"`
+	DropFolder   *dropFolderConfig   `json:"dropFolder"`
+}
+
+// ParseTrigger returns the Schedule described by the "trigger" of the given
+// pipeline configuration, which must have either a positive "interval", or a
+// "cron" expression with an optional "timezone". If it has neither, but lists
+// pipelines it executes "after", or has an "mqtt" subscription, a "webhook", or
+// a "dropFolder", the Schedule is nil.
+//
+// The trigger may also have StartOptions: "runOnStart", which defaults to true
+// for intervals and false for cron expressions, an "initialDelay", which
+// requires runOnStart, and "jitter".
+func ParseTrigger(pipelineConf []byte) (Schedule, error) {
+	var tc triggerConfig
+	if err := json.Unmarshal(pipelineConf, &tc); err != nil {
+		return nil, errors.Wrap(err, "invalid trigger")
+	}
+	trigger := tc.Trigger
+
+	schedule, err := parseSchedule(trigger)
+	if err != nil {
+		return nil, err
+	}
+	if trigger.RunOnStart == nil && trigger.InitialDelay == nil && trigger.Jitter == nil {
+		return schedule, nil
+	}
+
+	_, isInterval := schedule.(intervalSchedule)
+	opts := StartOptions{RunOnStart: isInterval}
+	if trigger.RunOnStart != nil {
+		opts.RunOnStart = *trigger.RunOnStart
+	}
+	if trigger.InitialDelay != nil {
+		opts.InitialDelay = trigger.InitialDelay.Duration()
+	}
+	if trigger.Jitter != nil {
+		opts.Jitter = trigger.Jitter.Duration()
+	}
+
+	switch {
+	case opts.InitialDelay < 0:
+		return nil, errors.Errorf("the trigger's initialDelay can't be negative, not %s", opts.InitialDelay)
+	case opts.Jitter < 0:
+		return nil, errors.Errorf("the trigger's jitter can't be negative, not %s", opts.Jitter)
+	case schedule == nil:
+		return nil, errors.New("the trigger's runOnStart, initialDelay, and jitter " +
+			"require an interval or a cron expression")
+	case trigger.InitialDelay != nil && !opts.RunOnStart:
+		return nil, errors.New("the trigger's initialDelay requires runOnStart")
+	}
+	return WithStartOptions(schedule, opts), nil
+}
+
+// parseSchedule returns the Schedule described by a trigger's interval or cron
+// expression.
+func parseSchedule(trigger triggerSettings) (Schedule, error) {
+	switch {
+	case trigger.Interval != nil && trigger.Cron != "":
+		return nil, errors.New("the trigger must have an interval or a cron expression, not both")
+	case trigger.Cron != "":
+		return Cron(trigger.Cron, trigger.Timezone)
+	case trigger.Timezone != "":
+		return nil, errors.New("the trigger's timezone requires a cron expression")
+	case trigger.Interval != nil:
+		d := trigger.Interval.Duration()
+		if d <= 0 {
+			return nil, errors.Errorf("the trigger's interval must be positive, not %s", d)
+		}
+		return Every(d), nil
+	case len(trigger.After) != 0 || trigger.MQTT != nil || trigger.Webhook != nil ||
+		trigger.DropFolder != nil:
+		return nil, nil
+	default:
+		return nil, errors.New("the trigger must have an interval, a cron expression, " +
+			"pipelines to run after, an mqtt subscription, a webhook, or a dropFolder")
+	}
+}
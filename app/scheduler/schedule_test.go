@@ -0,0 +1,199 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+func TestParseTrigger(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	start := time.Date(2019, 3, 10, 5, 30, 0, 0, time.UTC)
+
+	sched := w.ShouldHaveResult(ParseTrigger([]byte(
+		`{"trigger": {"interval": {"minutes": 2}}}`))).(Schedule)
+	w.ShouldBeEqual(sched.String(), "every 2m0s")
+	w.ShouldBeEqual(sched.First(start), start)
+	w.ShouldBeEqual(sched.Next(start), start.Add(2*time.Minute))
+
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(
+		`{"trigger": {"cron": "0 6 * * *", "timezone": "UTC"}}`))).(Schedule)
+	w.ShouldBeEqual(sched.String(), "cron 0 6 * * * UTC")
+	w.ShouldBeEqual(sched.First(start), time.Date(2019, 3, 10, 6, 0, 0, 0, time.UTC))
+	w.ShouldBeEqual(sched.Next(start.Add(time.Hour)), time.Date(2019, 3, 11, 6, 0, 0, 0, time.UTC))
+
+	// cron expressions are evaluated in their time zone
+	chicago := w.ShouldHaveResult(time.LoadLocation("America/Chicago")).(*time.Location)
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(
+		`{"trigger": {"cron": "0 6 * * *", "timezone": "America/Chicago"}}`))).(Schedule)
+	w.ShouldBeTrue(sched.First(start).Equal(time.Date(2019, 3, 10, 6, 0, 0, 0, chicago)))
+
+	// an optional seconds field and descriptors are allowed
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(
+		`{"trigger": {"cron": "30 */15 * * * *", "timezone": "UTC"}}`))).(Schedule)
+	w.ShouldBeEqual(sched.First(start), start.Add(30*time.Second))
+	w.ShouldBeEqual(sched.Next(start.Add(time.Minute)), start.Add(15*time.Minute+30*time.Second))
+	w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {"cron": "@daily"}}`)))
+
+	for _, invalid := range []string{
+		`{}`,
+		`{"trigger": {}}`,
+		`{"trigger": {"interval": {}}}`,
+		`{"trigger": {"interval": {"minutes": -1}}}`,
+		`{"trigger": {"interval": {"minutes": 1}, "cron": "* * * * *"}}`,
+		`{"trigger": {"interval": {"minutes": 1}, "timezone": "UTC"}}`,
+		`{"trigger": {"cron": "0 6 * *"}}`,
+		`{"trigger": {"cron": "0 25 * * *"}}`,
+		`{"trigger": {"cron": "CRON_TZ=UTC 0 6 * * *"}}`,
+		`{"trigger": {"cron": "0 6 * * *", "timezone": "Mars/Olympus_Mons"}}`,
+		`{"trigger": "every day"}`,
+	} {
+		_, err := ParseTrigger([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestRegistry_StartCron(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	reg := NewRegistry()
+	conf, p := newTestPipeline(w, getTestPlumber(), okPipeline)
+	sched := w.ShouldHaveResult(Cron("0 0 1 1 *", "UTC")).(Schedule)
+	e := w.ShouldHaveResult(reg.Add(Definition{Config: conf, Pipeline: p, Schedule: sched})).(*Entry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Status().NextExecution == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// unlike interval pipelines, cron pipelines wait for their first time
+	s := e.Status()
+	w.ShouldBeEqual(s.Schedule, "cron 0 0 1 1 * UTC")
+	w.ShouldBeEmptyStr(s.Interval)
+	w.ShouldBeNil(s.LastExecution)
+	w.ShouldNotBeNil(s.NextExecution)
+	w.ShouldBeEqual(s.NextExecution.YearDay(), 1)
+	w.ShouldBeTrue(s.NextExecution.After(time.Now()))
+}
+
+func TestParseTrigger_StartOptions(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	start := time.Date(2019, 3, 10, 5, 30, 0, 0, time.UTC)
+
+	sched := w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {
+	  "interval": {"minutes": 2},
+	  "initialDelay": {"seconds": 10}
+	}}`))).(Schedule)
+	w.ShouldBeEqual(sched.String(), "every 2m0s, run on start after 10s")
+	w.ShouldBeEqual(sched.First(start), start.Add(10*time.Second))
+	w.ShouldBeEqual(sched.Next(start), start.Add(2*time.Minute))
+	d, ok := interval(sched)
+	w.ShouldBeTrue(ok)
+	w.ShouldBeEqual(d, 2*time.Minute)
+
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {
+	  "interval": {"minutes": 2},
+	  "runOnStart": false
+	}}`))).(Schedule)
+	w.ShouldBeEqual(sched.First(start), start.Add(2*time.Minute))
+
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {
+	  "cron": "0 6 * * *",
+	  "timezone": "UTC",
+	  "runOnStart": true
+	}}`))).(Schedule)
+	w.ShouldBeEqual(sched.First(start), start)
+	w.ShouldBeEqual(sched.Next(start), time.Date(2019, 3, 10, 6, 0, 0, 0, time.UTC))
+
+	sched = w.ShouldHaveResult(ParseTrigger([]byte(`{"trigger": {
+	  "cron": "0 6 * * *",
+	  "timezone": "UTC",
+	  "jitter": {"seconds": 30}
+	}}`))).(Schedule)
+	w.ShouldBeEqual(sched.String(), "cron 0 6 * * * UTC, not on start, jitter 30s")
+	_, ok = interval(sched)
+	w.ShouldBeFalse(ok)
+	sixAM := time.Date(2019, 3, 10, 6, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		first := sched.First(start)
+		w.ShouldBeFalse(first.Before(sixAM))
+		w.ShouldBeTrue(first.Before(sixAM.Add(30 * time.Second)))
+	}
+
+	for _, invalid := range []string{
+		`{"trigger": {"interval": {"minutes": 1}, "initialDelay": {"minutes": -1}}}`,
+		`{"trigger": {"interval": {"minutes": 1}, "jitter": {"minutes": -1}}}`,
+		`{"trigger": {"interval": {"minutes": 1}, "runOnStart": false, "initialDelay": {"minutes": 1}}}`,
+		`{"trigger": {"cron": "0 6 * * *", "initialDelay": {"minutes": 1}}}`,
+		`{"trigger": {"cron": "0 6 * * *", "runOnStart": "yes"}}`,
+	} {
+		_, err := ParseTrigger([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestRegistry_LimitConcurrency(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	block := make(blockingPipe)
+	plumber := getTestPlumber(pipeClient("block", block))
+
+	reg := NewRegistry()
+	reg.LimitConcurrency(1)
+	var entries []*Entry
+	for _, name := range []string{"a", "b"} {
+		conf, p := newTestPipeline(w, plumber, `{
+		  "name": "`+name+`",
+		  "tasks": { "wait": { "type": "block" } }
+		}`)
+		entries = append(entries, w.ShouldHaveResult(reg.Add(
+			Definition{Config: conf, Pipeline: p, Schedule: Every(time.Hour)})).(*Entry))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+
+	running := func() (n int) {
+		for _, e := range entries {
+			if e.Status().Running {
+				n++
+			}
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for running() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	w.ShouldBeEqual(running(), 1)
+
+	// executions on demand aren't limited
+	for _, e := range entries {
+		if !e.Status().Running {
+			w.ShouldHaveResult(e.Run(false))
+		}
+	}
+	w.ShouldBeEqual(running(), 2)
+
+	// once the first scheduled execution completes, the other pipeline runs
+	close(block)
+	for _, e := range entries {
+		deadline = time.Now().Add(5 * time.Second)
+		for !scheduledAfterRun(e.Status()) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.ShouldBeTrue(scheduledAfterRun(e.Status()))
+	}
+}
@@ -0,0 +1,102 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// pipelineState is the operator-controlled state of a pipeline that persists
+// across restarts. Paused is the pipeline's disabled flag; see Entry.Pause.
+type pipelineState struct {
+	Paused bool `json:"paused"`
+}
+
+// stateFile persists pipeline state in a JSON file as an object keyed by the
+// pipelines' names.
+type stateFile struct {
+	path   string
+	mux    sync.Mutex
+	states map[string]pipelineState
+}
+
+// loadStateFile reads pipeline state from the given path. If the file doesn't
+// exist, the state is empty and the file is created on the first change.
+func loadStateFile(path string) (*stateFile, error) {
+	sf := &stateFile{path: path, states: map[string]pipelineState{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read pipeline state from %q", path)
+	}
+	if err := json.Unmarshal(data, &sf.states); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse pipeline state from %q", path)
+	}
+	return sf, nil
+}
+
+// get returns the stored state of the named pipeline.
+func (sf *stateFile) get(name string) pipelineState {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	return sf.states[name]
+}
+
+// set updates the state of the named pipeline and saves the file.
+func (sf *stateFile) set(name string, state pipelineState) error {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+
+	old, existed := sf.states[name]
+	sf.states[name] = state
+	if err := sf.save(); err != nil {
+		if existed {
+			sf.states[name] = old
+		} else {
+			delete(sf.states, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the state to a temporary file, then renames it over the
+// original so that the file is never left partially written.
+func (sf *stateFile) save() error {
+	data, err := json.MarshalIndent(sf.states, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal pipeline state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(sf.path), filepath.Base(sf.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to save pipeline state")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "unable to save pipeline state")
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "unable to save pipeline state")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to save pipeline state")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), sf.path), "unable to save pipeline state")
+}
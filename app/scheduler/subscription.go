@@ -0,0 +1,178 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/mqttsub"
+)
+
+// messageQueueSize is how many messages may wait for a pipeline to execute;
+// once it's full, new messages are dropped until the pipeline catches up.
+const messageQueueSize = 16
+
+// Subscription makes a pipeline execute each time a message is published to a
+// matching MQTT topic.
+type Subscription struct {
+	// Client is the name of the MQTT client used to subscribe.
+	Client string `json:"client"`
+	// Topic may include the wildcards "+" and "#".
+	Topic string `json:"topic"`
+	QoS   byte   `json:"qos"`
+}
+
+// Subscriber subscribes pipelines to MQTT topics.
+type Subscriber interface {
+	// Subscribe calls handle with each message matching the Subscription
+	// until the returned function is called. handle must not block.
+	Subscribe(sub Subscription, handle mqttsub.Handler) (func(), error)
+}
+
+// ParseSubscription returns the Subscription described by the "mqtt" of the
+// given pipeline configuration's trigger, or nil if it doesn't have one. It
+// must name the "client" and "topic", and its "qos" defaults to 0.
+func ParseSubscription(pipelineConf []byte) (*Subscription, error) {
+	var tc triggerConfig
+	if err := json.Unmarshal(pipelineConf, &tc); err != nil {
+		return nil, errors.Wrap(err, "invalid trigger")
+	}
+
+	sub := tc.Trigger.MQTT
+	switch {
+	case sub == nil:
+		return nil, nil
+	case sub.Client == "":
+		return nil, errors.New("the trigger's mqtt subscription must name a client")
+	case sub.QoS > 2:
+		return nil, errors.Errorf("the trigger's mqtt qos must be 0, 1, or 2, not %d", sub.QoS)
+	}
+	if err := mqttsub.ValidateFilter(sub.Topic); err != nil {
+		return nil, errors.WithMessage(err, "invalid mqtt trigger")
+	}
+	return sub, nil
+}
+
+// UseSubscriber sets the Subscriber used for pipelines with Subscriptions.
+// Without one, those pipelines don't execute when messages are published.
+//
+// It should be called before the Registry is started.
+func (r *Registry) UseSubscriber(s Subscriber) {
+	r.mux.Lock()
+	r.subscriber = s
+	r.mux.Unlock()
+}
+
+// subscribe subscribes the pipeline to its topic, if it has a Subscription,
+// replacing any previous subscription. The caller must hold the Registry's
+// lock.
+func (e *Entry) subscribe() {
+	e.unsubscribe()
+
+	sub := e.Definition().Subscription
+	if sub == nil || e.registry.subscriber == nil {
+		return
+	}
+	cancel, err := e.registry.subscriber.Subscribe(*sub, e.deliver)
+	if err != nil {
+		e.log().WithError(err).Errorf("Failed to subscribe pipeline %s to MQTT topic %q.",
+			e.Name(), sub.Topic)
+		return
+	}
+	e.log().Debugf("Pipeline %s subscribed to MQTT topic %q.", e.Name(), sub.Topic)
+
+	e.mux.Lock()
+	e.cancelSub = cancel
+	e.mux.Unlock()
+}
+
+// unsubscribe cancels the pipeline's subscription, if it has one.
+func (e *Entry) unsubscribe() {
+	e.mux.Lock()
+	cancel := e.cancelSub
+	e.cancelSub = nil
+	e.mux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// deliver queues a message for the pipeline's schedule to execute it. If the
+// schedule isn't running, the message is ignored, and if the queue is full,
+// it's dropped.
+func (e *Entry) deliver(msg mqttsub.Message) {
+	e.mux.RLock()
+	running := e.stop != nil
+	e.mux.RUnlock()
+	if !running {
+		return
+	}
+
+	e.log().Debugf("Received a message on MQTT topic %q for pipeline %s.", msg.Topic, e.Name())
+	select {
+	case e.messages <- msg:
+	default:
+		e.log().Warnf("Dropped a message on MQTT topic %q "+
+			"because too many are waiting for the pipeline.", msg.Topic)
+	}
+}
+
+type messageCtxKey int
+
+const messageKey messageCtxKey = 0
+
+// withMessage returns a context for an execution triggered by msg.
+func withMessage(ctx context.Context, msg *mqttsub.Message) context.Context {
+	return context.WithValue(ctx, messageKey, msg)
+}
+
+// MessageClient returns a Client for "message" tasks, which output the payload
+// or topic of the MQTT message that triggered the pipeline's execution:
+//
+//	{"type": "message", "raw": {"field": "topic"}}
+//
+// The "field" defaults to "payload". When the execution wasn't triggered by a
+// message, the task outputs its "default", if it has one, or nothing.
+func MessageClient() goplumber.Client {
+	return goplumber.PipeFunc(func(task *goplumber.Task) (goplumber.Pipe, error) {
+		mt := &messageTask{Field: "payload"}
+		if len(task.Raw) != 0 {
+			if err := json.Unmarshal(task.Raw, mt); err != nil {
+				return nil, errors.Wrap(err, "failed to create message task")
+			}
+		}
+		if mt.Field != "payload" && mt.Field != "topic" {
+			return nil, errors.Errorf("invalid message field %q; "+
+				"it must be \"payload\" or \"topic\"", mt.Field)
+		}
+		return mt, nil
+	})
+}
+
+type messageTask struct {
+	Field   string          `json:"field"`
+	Default json.RawMessage `json:"default"`
+}
+
+func (mt *messageTask) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	msg, ok := ctx.Value(messageKey).(*mqttsub.Message)
+	if !ok {
+		_, err := w.Write(mt.Default)
+		return err
+	}
+	if mt.Field == "topic" {
+		_, err := io.WriteString(w, msg.Topic)
+		return err
+	}
+	_, err := w.Write(msg.Payload)
+	return err
+}
@@ -0,0 +1,225 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/mqttsub"
+)
+
+// fakeSubscriber records subscriptions and delivers messages published with
+// publish to those with matching topics.
+type fakeSubscriber struct {
+	mux    sync.Mutex
+	subs   map[int]Subscription
+	handle map[int]mqttsub.Handler
+	nextID int
+}
+
+func newFakeSubscriber() *fakeSubscriber {
+	return &fakeSubscriber{subs: map[int]Subscription{}, handle: map[int]mqttsub.Handler{}}
+}
+
+func (fs *fakeSubscriber) Subscribe(sub Subscription, handle mqttsub.Handler) (func(), error) {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	id := fs.nextID
+	fs.nextID++
+	fs.subs[id] = sub
+	fs.handle[id] = handle
+	return func() {
+		fs.mux.Lock()
+		delete(fs.subs, id)
+		delete(fs.handle, id)
+		fs.mux.Unlock()
+	}, nil
+}
+
+// topics returns the topics with subscriptions.
+func (fs *fakeSubscriber) topics() map[string]bool {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	topics := map[string]bool{}
+	for _, sub := range fs.subs {
+		topics[sub.Topic] = true
+	}
+	return topics
+}
+
+func (fs *fakeSubscriber) publish(topic, payload string) {
+	fs.mux.Lock()
+	var handlers []mqttsub.Handler
+	for id, sub := range fs.subs {
+		if mqttsub.Match(sub.Topic, topic) {
+			handlers = append(handlers, fs.handle[id])
+		}
+	}
+	fs.mux.Unlock()
+	for _, handle := range handlers {
+		handle(mqttsub.Message{Topic: topic, Payload: []byte(payload)})
+	}
+}
+
+// subscribedPipeline outputs the payload and topic of the message that
+// triggered it.
+const subscribedPipeline = `{
+  "name": "config",
+  "tasks": {
+    "payload": { "type": "message", "raw": { "default": "none" } },
+    "topic": { "type": "message", "raw": { "field": "topic" } }
+  }
+}`
+
+// waitForExecution waits until the pipeline's last execution differs from
+// prev, then returns it.
+func waitForExecution(w *expect.TWrapper, e *Entry, prev *Execution) *Execution {
+	w.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s := e.Status()
+		if s.LastExecution != nil && !s.Running &&
+			(prev == nil || s.LastExecution.ID != prev.ID) {
+			return s.LastExecution
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Errorf("timed out waiting for pipeline %s to execute", e.Name())
+	w.FailNow()
+	return nil
+}
+
+func TestParseSubscription(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	sub, err := ParseSubscription([]byte(`{"trigger": {"interval": {"minutes": 1}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(sub)
+
+	sub = w.ShouldHaveResult(ParseSubscription([]byte(`{"trigger": {"mqtt": {
+	  "client": "gwMQTT", "topic": "rfid/gw/+/config/request", "qos": 1
+	}}}`))).(*Subscription)
+	w.ShouldBeEqual(*sub, Subscription{Client: "gwMQTT", Topic: "rfid/gw/+/config/request", QoS: 1})
+
+	// pipelines may only run when messages are published
+	sched, err := ParseTrigger([]byte(`{"trigger": {"mqtt": {"client": "gwMQTT", "topic": "rfid/#"}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(sched)
+
+	for _, invalid := range []string{
+		`{"trigger": {"mqtt": {"topic": "rfid/#"}}}`,
+		`{"trigger": {"mqtt": {"client": "gwMQTT"}}}`,
+		`{"trigger": {"mqtt": {"client": "gwMQTT", "topic": "rfid/#/request"}}}`,
+		`{"trigger": {"mqtt": {"client": "gwMQTT", "topic": "rfid/#", "qos": 3}}}`,
+		`{"trigger": {"mqtt": "rfid/#"}}`,
+	} {
+		_, err := ParseSubscription([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestMessageClient(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	client := MessageClient()
+	msg := &mqttsub.Message{Topic: "rfid/gw/gw1/config/request", Payload: []byte(`{"id": 1}`)}
+
+	output := func(raw string, ctx context.Context) string {
+		w.Helper()
+		task := &goplumber.Task{TaskType: "message"}
+		if raw != "" {
+			task.Raw = json.RawMessage(raw)
+		}
+		pipe := w.ShouldHaveResult(client.GetPipe(task)).(goplumber.Pipe)
+		buf := &bytes.Buffer{}
+		w.ShouldSucceed(pipe.Execute(ctx, buf, nil))
+		return buf.String()
+	}
+
+	triggered := withMessage(context.Background(), msg)
+	w.ShouldBeEqual(output("", triggered), `{"id": 1}`)
+	w.ShouldBeEqual(output(`{"field": "payload"}`, triggered), `{"id": 1}`)
+	w.ShouldBeEqual(output(`{"field": "topic"}`, triggered), "rfid/gw/gw1/config/request")
+	w.ShouldBeEqual(output(`{"field": "topic", "default": "none"}`, triggered), "rfid/gw/gw1/config/request")
+
+	// without a message, the default is used
+	w.ShouldBeEqual(output("", context.Background()), "")
+	w.ShouldBeEqual(output(`{"default": {"id": 0}}`, context.Background()), `{"id": 0}`)
+
+	w.ShouldHaveError(client.GetPipe(&goplumber.Task{Raw: json.RawMessage(`{"field": "qos"}`)}))
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	subscriber := newFakeSubscriber()
+	reg := NewRegistry()
+	reg.UseSubscriber(subscriber)
+	plumber := getTestPlumber(testClient{"message", MessageClient()})
+
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "config.json", subscribedPipeline, nil,
+		withSubscription("rfid/gw/+/config/request")))).(*Entry)
+	w.ShouldBeEqual(e.Status().Subscription.Topic, "rfid/gw/+/config/request")
+	w.ShouldHaveLength(subscriber.topics(), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg.Start(ctx)
+	w.ShouldBeEqual(subscriber.topics(), map[string]bool{"rfid/gw/+/config/request": true})
+
+	// the message's payload and topic are available to the pipeline
+	subscriber.publish("rfid/gw/gw1/config/request", `{"id": 1}`)
+	exec := waitForExecution(w, e, nil)
+	w.ShouldBeEqual(exec.Topic, "rfid/gw/gw1/config/request")
+	w.ShouldBeEqual(exec.State.String(), "Success")
+
+	// on-demand executions have no message
+	run := w.ShouldHaveResult(e.Run(true)).(*Execution)
+	w.ShouldBeEmptyStr(run.Topic)
+	w.ShouldBeTrue(run.OutputChanged)
+
+	subscriber.publish("rfid/gw/gw1/config/request", `{"id": 1}`)
+	exec = waitForExecution(w, e, run)
+	w.ShouldBeTrue(exec.OutputChanged)
+
+	// messages are ignored while the pipeline is paused
+	w.ShouldSucceed(e.Pause())
+	subscriber.publish("rfid/gw/gw1/config/request", `{"id": 2}`)
+	time.Sleep(50 * time.Millisecond)
+	w.ShouldBeEqual(e.Status().LastExecution.ID, exec.ID)
+	w.ShouldSucceed(e.Resume())
+
+	// changing the topic resubscribes the pipeline
+	reload := reg.Update([]Definition{newTestDefinition(w, plumber, "config.json", subscribedPipeline, nil,
+		withSubscription("rfid/gw/#"))}, nil)
+	w.ShouldBeEqual(reload.Updated, []string{"config"})
+	w.ShouldBeEqual(subscriber.topics(), map[string]bool{"rfid/gw/#": true})
+	subscriber.publish("rfid/gw/gw2/status", `{}`)
+	exec = waitForExecution(w, e, exec)
+	w.ShouldBeEqual(exec.Topic, "rfid/gw/gw2/status")
+
+	reload = reg.Update([]Definition{newTestDefinition(w, plumber, "config.json", subscribedPipeline, nil,
+		withSubscription("rfid/gw/#"))}, nil)
+	w.ShouldBeEqual(reload.Unchanged, []string{"config"})
+	w.ShouldHaveLength(subscriber.topics(), 1)
+
+	// removing or draining pipelines unsubscribes them
+	reg.Update(nil, nil)
+	w.ShouldHaveLength(subscriber.topics(), 0)
+
+	w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "other.json", namedPipeline("other"), nil,
+		withSubscription("rfid/#"))))
+	w.ShouldHaveLength(subscriber.topics(), 1)
+	reg.Drain(time.Second)
+	w.ShouldHaveLength(subscriber.topics(), 0)
+}
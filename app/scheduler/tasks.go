@@ -0,0 +1,301 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// TaskResult describes the execution of a single task within a pipeline run.
+type TaskResult struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	State       goplumber.State `json:"state"`
+	StartedAt   time.Time       `json:"startedAt"`
+	CompletedAt time.Time       `json:"completedAt"`
+	Duration    string          `json:"duration,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	// OutputSize is the number of bytes the task output.
+	OutputSize int64 `json:"outputSize"`
+}
+
+// UnmarshalJSON allows TaskResults to be read from the run history.
+func (tr *TaskResult) UnmarshalJSON(data []byte) error {
+	type plain TaskResult
+	aux := struct {
+		*plain
+		State string `json:"state"`
+	}{plain: (*plain)(tr)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	state, err := parseState(aux.State)
+	tr.State = state
+	return err
+}
+
+// parseState returns the goplumber.State with the given name, which can't be
+// unmarshaled directly.
+func parseState(name string) (goplumber.State, error) {
+	for _, state := range []goplumber.State{
+		goplumber.Waiting, goplumber.Running, goplumber.Success,
+		goplumber.Failed, goplumber.Retrying,
+	} {
+		if state.String() == name {
+			return state, nil
+		}
+	}
+	return goplumber.Waiting, errors.Errorf("unknown state %q", name)
+}
+
+// Instrument wraps the Plumber's clients so that the Pipes they create record
+// their results in the pipeline run that executes them.
+//
+// Clients that are already instrumented are left alone, so it's safe to call
+// this again after adding clients. The "input" client is never wrapped, since
+// goplumber relies on identifying its Pipes by their concrete type.
+func Instrument(plumber goplumber.Plumber) {
+	for taskType, client := range plumber.Clients {
+		if taskType == "input" {
+			continue
+		}
+		if _, done := client.(instrumentedClient); done {
+			continue
+		}
+		plumber.Clients[taskType] = instrumentedClient{client: client}
+	}
+}
+
+// instrumentedClient creates instrumentedPipes.
+type instrumentedClient struct {
+	client goplumber.Client
+}
+
+func (ic instrumentedClient) GetPipe(task *goplumber.Task) (goplumber.Pipe, error) {
+	pipe, err := ic.client.GetPipe(task)
+	if err != nil {
+		return nil, err
+	}
+	ip := &instrumentedPipe{pipe: pipe, task: task}
+	if task.TaskType == "http" {
+		ip.request = parseHTTPRequest(task)
+	}
+	return ip, nil
+}
+
+// instrumentedPipe reports the result of its underlying Pipe to the
+// taskRecorder in the execution context, if there is one, and traces it.
+type instrumentedPipe struct {
+	pipe    goplumber.Pipe
+	task    *goplumber.Task
+	request *httpRequest // if it's an "http" task
+}
+
+func (ip *instrumentedPipe) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	rec, ok := ctx.Value(recorderKey).(*taskRecorder)
+	if !ok {
+		return ip.pipe.Execute(ctx, w, links)
+	}
+
+	ctx, outer := withOuterTask(ctx, ip.task)
+	ctx, span := rec.startTaskSpan(ctx, ip.task)
+	if ip.request != nil {
+		links = traceHTTP(span, ip.request, links)
+	}
+
+	start := time.Now().UTC()
+	output := sha256.New()
+	size := &countingWriter{}
+	err := ip.pipe.Execute(ctx, io.MultiWriter(w, output, size), links)
+	end := time.Now().UTC()
+	rec.add(ip.task, start, end, err, output.Sum(nil), size.n)
+	rec.logTask(ip.task, end.Sub(start), err)
+	span.SetAttribute("task.output_bytes", size.n)
+	span.SetError(err)
+	span.End()
+
+	if ip.request != nil {
+		sent := len(ip.request.Body)
+		if body, ok := links["body"]; ok {
+			sent = len(body)
+		}
+		rec.observeHTTP(outer, end.Sub(start), sent, size.n)
+	}
+	return err
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+type recorderCtxKey int
+
+const recorderKey recorderCtxKey = 0
+
+// taskRecorder collects task results during a single pipeline execution.
+type taskRecorder struct {
+	pipeline string
+	names    map[*goplumber.Task]string // of the pipeline's own tasks
+	log      *log.Entry                 // logs about the execution, if it's set
+
+	mux     sync.Mutex
+	records []taskRecord
+}
+
+type taskRecord struct {
+	task        *goplumber.Task
+	startedAt   time.Time
+	completedAt time.Time
+	err         error
+	output      []byte // SHA-256 digest of the task's output
+	size        int64  // length of the task's output
+}
+
+func withRecorder(ctx context.Context, conf *goplumber.PipelineConfig) (context.Context, *taskRecorder) {
+	rec := &taskRecorder{pipeline: conf.Name, names: make(map[*goplumber.Task]string, len(conf.Tasks))}
+	for name, task := range conf.Tasks {
+		rec.names[task] = name
+	}
+	return context.WithValue(ctx, recorderKey, rec), rec
+}
+
+// logTask logs that a task completed or failed. Like their spans, the
+// pipeline's own tasks are named as they are in its definition, and others by
+// their type.
+func (rec *taskRecorder) logTask(task *goplumber.Task, duration time.Duration, err error) {
+	if rec.log == nil {
+		return
+	}
+	name, ok := rec.names[task]
+	if !ok {
+		name = task.TaskType
+	}
+	entry := rec.log.WithFields(log.Fields{
+		"task":     name,
+		"type":     task.TaskType,
+		"duration": duration.String(),
+	})
+	if err != nil {
+		entry.WithError(err).Warn("Task failed.")
+	} else {
+		entry.Debug("Task completed.")
+	}
+}
+
+func (rec *taskRecorder) add(task *goplumber.Task, start, end time.Time, err error, output []byte, size int64) {
+	rec.mux.Lock()
+	rec.records = append(rec.records, taskRecord{
+		task:        task,
+		startedAt:   start,
+		completedAt: end,
+		err:         err,
+		output:      output,
+		size:        size,
+	})
+	rec.mux.Unlock()
+}
+
+// digest returns a digest of the output of the given pipeline: that of its
+// defaultOutput task, if it has one, or otherwise that of all its tasks.
+func (rec *taskRecorder) digest(conf *goplumber.PipelineConfig) string {
+	outputs := map[string][]byte{}
+	rec.mux.Lock()
+	for _, r := range rec.records {
+		for name, task := range conf.Tasks {
+			if task == r.task {
+				outputs[name] = r.output
+			}
+		}
+	}
+	rec.mux.Unlock()
+
+	names := make([]string, 0, len(outputs))
+	if conf.DefaultOutput != nil {
+		names = append(names, *conf.DefaultOutput)
+	} else {
+		for name := range outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%x;", name, outputs[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// results matches recorded executions with the tasks of the given pipeline.
+//
+// Tasks appear in the order they executed, followed by those that didn't run
+// (in name order), which have the Waiting state. Records for tasks that belong
+// to other pipelines, such as those used as custom task types, are ignored.
+func (rec *taskRecorder) results(conf *goplumber.PipelineConfig) []TaskResult {
+	names := make(map[*goplumber.Task]string, len(conf.Tasks))
+	for name, task := range conf.Tasks {
+		names[task] = name
+	}
+
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	results := make([]TaskResult, 0, len(conf.Tasks))
+	for _, r := range rec.records {
+		name, ok := names[r.task]
+		if !ok {
+			continue
+		}
+		delete(names, r.task)
+
+		tr := TaskResult{
+			Name:        name,
+			Type:        r.task.TaskType,
+			State:       goplumber.Success,
+			StartedAt:   r.startedAt,
+			CompletedAt: r.completedAt,
+			Duration:    r.completedAt.Sub(r.startedAt).String(),
+			OutputSize:  r.size,
+		}
+		if r.err != nil {
+			tr.State = goplumber.Failed
+			tr.Error = r.err.Error()
+		}
+		results = append(results, tr)
+	}
+
+	skipped := make([]TaskResult, 0, len(names))
+	for task, name := range names {
+		skipped = append(skipped, TaskResult{
+			Name:  name,
+			Type:  task.TaskType,
+			State: goplumber.Waiting,
+		})
+	}
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Name < skipped[j].Name
+	})
+	return append(results, skipped...)
+}
@@ -0,0 +1,127 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/tracing"
+)
+
+// startRunSpan starts the root span of an execution's trace, and records its
+// trace ID in the execution.
+func startRunSpan(ctx context.Context, exec *Execution) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, exec.Pipeline, tracing.KindInternal)
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttribute("pipeline.name", exec.Pipeline)
+	span.SetAttribute("pipeline.run_id", exec.ID)
+	span.SetAttribute("pipeline.trigger", string(exec.Trigger))
+	exec.TraceID = span.Context().TraceID.String()
+	return ctx, span
+}
+
+// endRunSpan records the result of an execution in its span and ends it.
+func endRunSpan(span *tracing.Span, result goplumber.Status) {
+	switch {
+	case result.State != goplumber.Failed:
+		span.SetStatus(tracing.StatusOK, "")
+	case result.Err != nil:
+		span.SetStatus(tracing.StatusError, result.Err.Error())
+	default:
+		span.SetStatus(tracing.StatusError, "pipeline failed")
+	}
+	span.End()
+}
+
+// startTaskSpan starts a span for a task, which is a child of the span of the
+// pipeline execution or, for tasks of pipelines used as custom task types, of
+// the task that uses them. The pipeline's own tasks are named as they are in
+// its definition; others are named by their type.
+func (rec *taskRecorder) startTaskSpan(ctx context.Context, task *goplumber.Task) (context.Context, *tracing.Span) {
+	name, ok := rec.names[task]
+	if !ok {
+		name = task.TaskType
+	}
+	kind := tracing.KindInternal
+	if task.TaskType == "http" {
+		kind = tracing.KindClient
+	}
+
+	ctx, span := tracing.Start(ctx, name, kind)
+	if ok {
+		span.SetAttribute("task.name", name)
+	}
+	span.SetAttribute("task.type", task.TaskType)
+	return ctx, span
+}
+
+// traceHTTP describes an "http" task's request in its span, and returns links
+// that add the span's context to the request's headers, so that the service
+// it calls can continue the trace.
+//
+// If the task's headers come from a link that isn't valid, the links are
+// returned unchanged, and the task will report the problem.
+func traceHTTP(span *tracing.Span, req *httpRequest, links map[string][]byte) map[string][]byte {
+	if span == nil {
+		return links
+	}
+
+	method, target := req.Method, req.URL
+	if data, ok := links["method"]; ok {
+		_ = json.Unmarshal(data, &method)
+	}
+	if data, ok := links["url"]; ok {
+		_ = json.Unmarshal(data, &target)
+	}
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", redactURL(target))
+
+	headers := make(map[string][]string, len(req.Headers)+1)
+	if data, ok := links["headers"]; ok {
+		if err := json.Unmarshal(data, &headers); err != nil {
+			return links
+		}
+	} else {
+		for key, values := range req.Headers {
+			headers[key] = values
+		}
+	}
+	for key := range headers {
+		if strings.EqualFold(key, tracing.TraceparentHeader) {
+			delete(headers, key)
+		}
+	}
+	headers[tracing.TraceparentHeader] = []string{span.Context().Traceparent()}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return links
+	}
+
+	traced := make(map[string][]byte, len(links)+1)
+	for key, value := range links {
+		traced[key] = value
+	}
+	traced["headers"] = data
+	return traced
+}
+
+// redactURL removes any credentials from a URL, so they're not exported.
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.User == nil {
+		return target
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,206 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+)
+
+// DefaultSignatureHeader is the request header that holds a webhook's
+// signature, unless its Webhook names a different one.
+const DefaultSignatureHeader = "X-Hub-Signature-256"
+
+var (
+	// ErrNoWebhook is returned when a request is posted to the hook of a
+	// pipeline that doesn't have a Webhook.
+	ErrNoWebhook = errors.New("pipeline doesn't have a webhook")
+
+	// ErrInvalidSignature is returned when a request posted to a pipeline's
+	// hook doesn't have a valid signature.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrPaused is returned when a request is posted to the hook of a paused
+	// pipeline.
+	ErrPaused = errors.New("pipeline is paused")
+
+	// ErrBreakerOpen is returned when a request is posted to the hook of a
+	// pipeline whose circuit breaker is open.
+	ErrBreakerOpen = errors.New("pipeline's circuit breaker is open")
+)
+
+// Webhook makes a pipeline execute each time a request is posted to its hook.
+type Webhook struct {
+	// Secret, if it's set, is the name of the secret holding the key used to
+	// verify requests' signatures; requests without a valid signature are
+	// rejected.
+	Secret string `json:"secret,omitempty"`
+	// Header holds the signature, which is the hex-encoded HMAC-SHA256 of
+	// the request body, optionally prefixed with "sha256=".
+	Header string `json:"header,omitempty"`
+	// Key is the contents of the Secret.
+	Key []byte `json:"-"`
+}
+
+// WebhookRequest is a request posted to a pipeline's hook.
+type WebhookRequest struct {
+	Body   []byte
+	Header http.Header
+}
+
+// ParseWebhook returns the Webhook described by the "webhook" of the given
+// pipeline configuration's trigger, or nil if it doesn't have one. Its
+// "secret" is optional, and its "header" defaults to DefaultSignatureHeader if
+// it has one. The caller must load the secret's Key.
+func ParseWebhook(pipelineConf []byte) (*Webhook, error) {
+	var tc triggerConfig
+	if err := json.Unmarshal(pipelineConf, &tc); err != nil {
+		return nil, errors.Wrap(err, "invalid trigger")
+	}
+
+	hook := tc.Trigger.Webhook
+	switch {
+	case hook == nil:
+		return nil, nil
+	case hook.Secret == "" && hook.Header != "":
+		return nil, errors.New("the trigger's webhook header requires a secret")
+	case hook.Secret != "" && hook.Header == "":
+		hook.Header = DefaultSignatureHeader
+	}
+	return hook, nil
+}
+
+// Verify returns ErrInvalidSignature if the Webhook has a Secret, but the
+// request isn't signed with its Key.
+func (hook *Webhook) Verify(req WebhookRequest) error {
+	if hook.Secret == "" {
+		return nil
+	}
+
+	signature := strings.TrimPrefix(req.Header.Get(hook.Header), "sha256=")
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) != sha256.Size {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, hook.Key)
+	_, _ = mac.Write(req.Body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Hook executes the pipeline for a request posted to its hook, and returns as
+// soon as the execution starts, so the returned Execution only has its ID,
+// State, and StartedAt fields set.
+//
+// If the pipeline doesn't have a Webhook, Hook returns ErrNoWebhook, and if the
+// request isn't signed with its Key, it returns ErrInvalidSignature. Like
+// other triggers, a request is rejected with ErrPaused or ErrBreakerOpen if the
+// pipeline is paused or its circuit breaker is open, but like Run, it isn't
+// limited by the Registry's concurrency limit. Otherwise, it returns the same
+// errors as Run.
+func (e *Entry) Hook(req WebhookRequest) (*Execution, error) {
+	e.mux.RLock()
+	hook := e.def.Webhook
+	paused := e.paused
+	breaker := e.breakerState(time.Now())
+	e.mux.RUnlock()
+
+	switch {
+	case hook == nil:
+		return nil, ErrNoWebhook
+	case hook.Verify(req) != nil:
+		return nil, ErrInvalidSignature
+	case paused:
+		return nil, ErrPaused
+	case breaker == BreakerOpen:
+		return nil, ErrBreakerOpen
+	}
+
+	ctx, exec, def, err := e.begin(TriggerWebhook)
+	if err != nil {
+		return nil, err
+	}
+	e.log().Debugf("Received a webhook request for pipeline %s.", e.Name())
+
+	started := *exec
+	go e.execute(withWebhookRequest(ctx, &req), exec, def)
+	return &started, nil
+}
+
+type webhookCtxKey int
+
+const webhookKey webhookCtxKey = 0
+
+// withWebhookRequest returns a context for an execution triggered by req.
+func withWebhookRequest(ctx context.Context, req *WebhookRequest) context.Context {
+	return context.WithValue(ctx, webhookKey, req)
+}
+
+// WebhookClient returns a Client for "webhook" tasks, which output the body or
+// headers of the request that triggered the pipeline's execution:
+//
+//	{"type": "webhook", "raw": {"field": "headers"}}
+//
+// The "field" defaults to "body". The headers are a JSON object that maps each
+// header's canonical name to its values, separated by commas. When the
+// execution wasn't triggered by a request, the task outputs its "default", if
+// it has one, or nothing.
+func WebhookClient() goplumber.Client {
+	return goplumber.PipeFunc(func(task *goplumber.Task) (goplumber.Pipe, error) {
+		wt := &webhookTask{Field: "body"}
+		if len(task.Raw) != 0 {
+			if err := json.Unmarshal(task.Raw, wt); err != nil {
+				return nil, errors.Wrap(err, "failed to create webhook task")
+			}
+		}
+		if wt.Field != "body" && wt.Field != "headers" {
+			return nil, errors.Errorf("invalid webhook field %q; "+
+				"it must be \"body\" or \"headers\"", wt.Field)
+		}
+		return wt, nil
+	})
+}
+
+type webhookTask struct {
+	Field   string          `json:"field"`
+	Default json.RawMessage `json:"default"`
+}
+
+func (wt *webhookTask) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	req, ok := ctx.Value(webhookKey).(*WebhookRequest)
+	if !ok {
+		_, err := w.Write(wt.Default)
+		return err
+	}
+	if wt.Field == "headers" {
+		headers := make(map[string]string, len(req.Header))
+		for name, values := range req.Header {
+			headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+		data, err := json.Marshal(headers)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	_, err := w.Write(req.Body)
+	return err
+}
@@ -0,0 +1,170 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package scheduler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+// capturePipe records the data linked to it by the last execution.
+type capturePipe struct {
+	mux   sync.Mutex
+	links map[string][]byte
+}
+
+func (cp *capturePipe) Execute(ctx context.Context, w io.Writer, links map[string][]byte) error {
+	cp.mux.Lock()
+	cp.links = map[string][]byte{}
+	for k, v := range links {
+		cp.links[k] = append([]byte(nil), v...)
+	}
+	cp.mux.Unlock()
+	return nil
+}
+
+func (cp *capturePipe) get(name string) string {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+	return string(cp.links[name])
+}
+
+// sign returns the signature of body using key, as a webhook's sender would.
+func sign(key, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// hookedPipeline passes its webhook request's body and headers to a "capture"
+// task.
+const hookedPipeline = `{
+  "name": "hooked",
+  "tasks": {
+    "body": { "type": "webhook" },
+    "headers": { "type": "webhook", "raw": { "field": "headers" } },
+    "capture": {
+      "type": "capture",
+      "links": { "body": { "from": "body" }, "headers": { "from": "headers" } }
+    }
+  }
+}`
+
+func TestParseWebhook(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+
+	hook, err := ParseWebhook([]byte(`{"trigger": {"interval": {"minutes": 1}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(hook)
+
+	hook = w.ShouldHaveResult(ParseWebhook([]byte(`{"trigger": {"webhook": {}}}`))).(*Webhook)
+	w.ShouldBeEqual(*hook, Webhook{})
+
+	hook = w.ShouldHaveResult(ParseWebhook([]byte(`{"trigger": {"webhook": {"secret": "asnHook"}}}`))).(*Webhook)
+	w.ShouldBeEqual(*hook, Webhook{Secret: "asnHook", Header: DefaultSignatureHeader})
+
+	hook = w.ShouldHaveResult(ParseWebhook([]byte(`{"trigger": {"webhook": {
+	  "secret": "asnHook", "header": "X-Signature"
+	}}}`))).(*Webhook)
+	w.ShouldBeEqual(*hook, Webhook{Secret: "asnHook", Header: "X-Signature"})
+
+	// pipelines may only run when requests are posted
+	sched, err := ParseTrigger([]byte(`{"trigger": {"webhook": {}}}`))
+	w.ShouldSucceed(err)
+	w.ShouldBeNil(sched)
+
+	for _, invalid := range []string{
+		`{"trigger": {"webhook": {"header": "X-Signature"}}}`,
+		`{"trigger": {"webhook": true}}`,
+	} {
+		_, err := ParseWebhook([]byte(invalid))
+		w.As(invalid).ShouldFail(err)
+	}
+}
+
+func TestWebhook_Verify(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	body := `{"asn": "123"}`
+	request := func(header, value string) WebhookRequest {
+		req := WebhookRequest{Body: []byte(body), Header: http.Header{}}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return req
+	}
+
+	// without a secret, requests aren't verified
+	w.ShouldSucceed((&Webhook{}).Verify(request("", "")))
+
+	hook := &Webhook{Secret: "asnHook", Header: DefaultSignatureHeader, Key: []byte("s3cret")}
+	signature := sign("s3cret", body)
+	w.ShouldSucceed(hook.Verify(request(DefaultSignatureHeader, signature)))
+	w.ShouldSucceed(hook.Verify(request(DefaultSignatureHeader, signature[len("sha256="):])))
+
+	for _, invalid := range []WebhookRequest{
+		request("", ""),
+		request("X-Signature", signature),
+		request(DefaultSignatureHeader, sign("wrong", body)),
+		request(DefaultSignatureHeader, "sha256=not-hex"),
+		request(DefaultSignatureHeader, signature[:20]),
+	} {
+		w.As(invalid.Header).ShouldBeEqual(hook.Verify(invalid), ErrInvalidSignature)
+	}
+}
+
+func TestEntry_Hook(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	reg := NewRegistry()
+	capture := &capturePipe{}
+	plumber := getTestPlumber(testClient{"webhook", WebhookClient()}, pipeClient("capture", capture))
+
+	plain := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "plain.json", namedPipeline("plain"), nil))).(*Entry)
+	_, err := plain.Hook(WebhookRequest{})
+	w.ShouldBeEqual(err, ErrNoWebhook)
+
+	hook := &Webhook{Secret: "asnHook", Header: DefaultSignatureHeader, Key: []byte("s3cret")}
+	e := w.ShouldHaveResult(reg.Add(newTestDefinition(w, plumber, "hooked.json", hookedPipeline, nil, withWebhook(hook)))).(*Entry)
+	w.ShouldBeEqual(e.Status().Webhook.Secret, "asnHook")
+
+	body := `{"asn": "123"}`
+	req := WebhookRequest{Body: []byte(body), Header: http.Header{}}
+	_, err = e.Hook(req)
+	w.ShouldBeEqual(err, ErrInvalidSignature)
+
+	// the request's body and headers are available to the pipeline
+	req.Header.Set(DefaultSignatureHeader, sign("s3cret", body))
+	req.Header.Set("x-request-id", "abc")
+	started := w.ShouldHaveResult(e.Hook(req)).(*Execution)
+	w.ShouldNotBeEmptyStr(started.ID)
+	exec := waitForExecution(w, e, nil)
+	w.ShouldBeEqual(exec.ID, started.ID)
+	w.ShouldBeEqual(exec.State.String(), "Success")
+	w.ShouldBeEqual(capture.get("body"), body)
+	w.ShouldContainStr(capture.get("headers"), `"X-Request-Id":"abc"`)
+
+	// on-demand executions have no request
+	w.ShouldHaveResult(e.Run(true))
+	w.ShouldBeEmptyStr(capture.get("body"))
+
+	w.ShouldSucceed(e.Pause())
+	_, err = e.Hook(req)
+	w.ShouldBeEqual(err, ErrPaused)
+	w.ShouldSucceed(e.Resume())
+
+	reg.Drain(time.Second)
+	_, err = e.Hook(req)
+	w.ShouldBeEqual(err, ErrDraining)
+}
@@ -0,0 +1,270 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCASAttempts is the number of times ConsulStore.Put tries to update a key
+// before giving up because other writers keep modifying it.
+const maxCASAttempts = 5
+
+// ConsulStore keeps data in Consul's KV store, so it's shared by every service
+// instance using the same key prefix and survives container replacement.
+//
+// Writes use Consul's check-and-set operation, so a Put never overwrites a
+// change made by another instance after the Put read the key's current state.
+type ConsulStore struct {
+	baseURL *url.URL
+	prefix  string
+	client  *http.Client
+}
+
+// consulKVPair is the subset of Consul's KV entries that ConsulStore uses.
+type consulKVPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+// NewConsulStore returns a ConsulStore using the Consul agent at the given
+// address, such as "http://edgex-core-consul:8500", that stores keys under
+// the given prefix.
+func NewConsulStore(address, prefix string) (*ConsulStore, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid consul address %q", address)
+	}
+
+	return &ConsulStore{
+		baseURL: baseURL,
+		prefix:  prefix,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// keyURL returns the URL of the KV endpoint for the given key.
+func (cs *ConsulStore) keyURL(key string, query url.Values) string {
+	segments := strings.Split(cs.prefix+key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	u := *cs.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/kv/" + strings.Join(segments, "/")
+	u.RawPath = ""
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// do sends a request to Consul and returns the response, which is only
+// returned if its status is 200 or 404; the caller must close its body.
+func (cs *ConsulStore) do(ctx context.Context, method, target string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create consul request")
+	}
+
+	response, err := cs.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "consul request failed")
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		msg, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		_ = response.Body.Close()
+		return nil, errors.Errorf("consul returned %s for %s %s: %s",
+			response.Status, method, request.URL.Path, msg)
+	}
+	return response, nil
+}
+
+// get returns the current KV pair for the key, or nil if it isn't present.
+func (cs *ConsulStore) get(ctx context.Context, key string) (*consulKVPair, error) {
+	response, err := cs.do(ctx, http.MethodGet, cs.keyURL(key, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close consul response.")
+		}
+	}()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var pairs []consulKVPair
+	if err := json.NewDecoder(response.Body).Decode(&pairs); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse consul response for %q", key)
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return &pairs[0], nil
+}
+
+// Get allows ConsulStore to act as a DataSource.
+func (cs *ConsulStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	pair, err := cs.get(ctx, key)
+	if err != nil || pair == nil {
+		return nil, false, err
+	}
+	if pair.Value == nil {
+		return []byte{}, true, nil
+	}
+	return pair.Value, true, nil
+}
+
+// Put allows ConsulStore to act as a Sink.
+//
+// If another writer modifies the key between reading its current state and
+// writing the new value, Put tries again, up to a limit.
+func (cs *ConsulStore) Put(ctx context.Context, key string, value []byte) error {
+	for attempt := 1; attempt <= maxCASAttempts; attempt++ {
+		pair, err := cs.get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		// an index of 0 only succeeds if the key doesn't exist
+		var index uint64
+		if pair != nil {
+			index = pair.ModifyIndex
+		}
+
+		ok, err := cs.cas(ctx, key, value, index)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debugf("Consul key %q was modified concurrently; retrying (attempt %d).",
+			key, attempt)
+	}
+	return errors.Errorf("unable to update consul key %q: it was modified "+
+		"concurrently %d times", key, maxCASAttempts)
+}
+
+// Delete removes a key from the store.
+func (cs *ConsulStore) Delete(ctx context.Context, key string) error {
+	response, err := cs.do(ctx, http.MethodDelete, cs.keyURL(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	if err := response.Body.Close(); err != nil {
+		log.WithError(err).Warn("Failed to close consul response.")
+	}
+	return nil
+}
+
+// List returns the entries whose keys have the given prefix, which is relative
+// to the store's key prefix.
+func (cs *ConsulStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	query := url.Values{"recurse": []string{"true"}}
+	response, err := cs.do(ctx, http.MethodGet, cs.keyURL(prefix, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close consul response.")
+		}
+	}()
+
+	entries := map[string][]byte{}
+	if response.StatusCode == http.StatusNotFound {
+		return entries, nil
+	}
+
+	var pairs []consulKVPair
+	if err := json.NewDecoder(response.Body).Decode(&pairs); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse consul response for %q", prefix)
+	}
+	for _, pair := range pairs {
+		if !strings.HasPrefix(pair.Key, cs.prefix) {
+			continue
+		}
+		if pair.Value == nil {
+			pair.Value = []byte{}
+		}
+		entries[strings.TrimPrefix(pair.Key, cs.prefix)] = pair.Value
+	}
+	return entries, nil
+}
+
+// Check returns an error if the Consul agent is unreachable or its cluster
+// has no leader, in which case the store can't be used.
+func (cs *ConsulStore) Check(ctx context.Context) error {
+	u := *cs.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/status/leader"
+	response, err := cs.do(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close consul response.")
+		}
+	}()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errors.New("consul returned 404 Not Found for its leader")
+	}
+	var leader string
+	if err := json.NewDecoder(response.Body).Decode(&leader); err != nil {
+		return errors.Wrap(err, "unable to parse consul's leader")
+	}
+	if leader == "" {
+		return errors.New("consul has no leader")
+	}
+	return nil
+}
+
+// cas writes the value if the key's ModifyIndex still matches the given index,
+// returning whether the write succeeded.
+func (cs *ConsulStore) cas(ctx context.Context, key string, value []byte, index uint64) (bool, error) {
+	query := url.Values{"cas": []string{strconv.FormatUint(index, 10)}}
+	response, err := cs.do(ctx, http.MethodPut, cs.keyURL(key, query), bytes.NewReader(value))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.WithError(err).Warn("Failed to close consul response.")
+		}
+	}()
+
+	if response.StatusCode == http.StatusNotFound {
+		return false, errors.Errorf("consul returned 404 Not Found for PUT %q", key)
+	}
+
+	var ok bool
+	if err := json.NewDecoder(response.Body).Decode(&ok); err != nil {
+		return false, errors.Wrapf(err, "unable to parse consul response for %q", key)
+	}
+	return ok, nil
+}
@@ -0,0 +1,178 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+var _ Store = (*ConsulStore)(nil)
+
+// fakeConsul implements the parts of Consul's KV API used by ConsulStore.
+type fakeConsul struct {
+	mux       sync.Mutex
+	index     uint64
+	pairs     map[string]*consulKVPair
+	beforeCAS func() // called before handling a CAS request, if set
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{pairs: map[string]*consulKVPair{}}
+}
+
+func (fc *fakeConsul) set(key string, value []byte) {
+	fc.index++
+	fc.pairs[key] = &consulKVPair{Key: key, Value: value, ModifyIndex: fc.index}
+}
+
+func (fc *fakeConsul) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/status/leader" {
+		_, _ = rw.Write([]byte(`"127.0.0.1:8300"`))
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+
+	switch r.Method {
+	case http.MethodGet:
+		fc.mux.Lock()
+		var pairs []consulKVPair
+		for k, pair := range fc.pairs {
+			if k == key || (r.URL.Query().Get("recurse") != "" && strings.HasPrefix(k, key)) {
+				pairs = append(pairs, *pair)
+			}
+		}
+		fc.mux.Unlock()
+		if len(pairs) == 0 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(pairs)
+		_, _ = rw.Write(data)
+
+	case http.MethodDelete:
+		fc.mux.Lock()
+		delete(fc.pairs, key)
+		fc.mux.Unlock()
+		_, _ = rw.Write([]byte("true"))
+
+	case http.MethodPut:
+		if fc.beforeCAS != nil {
+			fc.beforeCAS()
+		}
+		value, _ := ioutil.ReadAll(r.Body)
+		cas, err := strconv.ParseUint(r.URL.Query().Get("cas"), 10, 64)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fc.mux.Lock()
+		defer fc.mux.Unlock()
+		pair, exists := fc.pairs[key]
+		ok := (cas == 0 && !exists) || (exists && pair.ModifyIndex == cas)
+		if ok {
+			fc.set(key, value)
+		}
+		_, _ = rw.Write([]byte(strconv.FormatBool(ok)))
+
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestConsulStore_PutGet(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	fc := newFakeConsul()
+	server := httptest.NewServer(fc)
+	defer server.Close()
+
+	ctx := context.Background()
+	cs := w.ShouldHaveResult(NewConsulStore(server.URL, "data-provider/")).(*ConsulStore)
+
+	w.ShouldSucceed(cs.Check(ctx))
+	_, ok, err := cs.Get(ctx, "sku.lastUpdated")
+	w.ShouldSucceed(err)
+	w.ShouldBeFalse(ok)
+
+	w.ShouldSucceed(cs.Put(ctx, "sku.lastUpdated", []byte("1234")))
+	shouldHaveValue(w, cs, "sku.lastUpdated", "1234")
+	w.ShouldSucceed(cs.Put(ctx, "sku.lastUpdated", []byte("5678")))
+	shouldHaveValue(w, cs, "sku.lastUpdated", "5678")
+	w.ShouldSucceed(cs.Put(ctx, "empty", nil))
+	shouldHaveValue(w, cs, "empty", "")
+
+	// keys are stored under the prefix, so they're shared with other instances
+	w.ShouldContain(fc.pairs, []string{"data-provider/sku.lastUpdated"})
+	other := w.ShouldHaveResult(NewConsulStore(server.URL, "data-provider/")).(*ConsulStore)
+	shouldHaveValue(w, other, "sku.lastUpdated", "5678")
+
+	// but not with those using a different prefix
+	isolated := w.ShouldHaveResult(NewConsulStore(server.URL, "other/")).(*ConsulStore)
+	_, ok, err = isolated.Get(ctx, "sku.lastUpdated")
+	w.ShouldSucceed(err)
+	w.ShouldBeFalse(ok)
+	w.ShouldSucceed(isolated.Put(ctx, "asn.lastUpdated", []byte("1")))
+
+	testListDelete(w, cs)
+}
+
+func TestConsulStore_CAS(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	fc := newFakeConsul()
+	server := httptest.NewServer(fc)
+	defer server.Close()
+
+	ctx := context.Background()
+	cs := w.ShouldHaveResult(NewConsulStore(server.URL, "")).(*ConsulStore)
+
+	// another writer modifies the key once before our write
+	conflicts := 1
+	fc.beforeCAS = func() {
+		fc.mux.Lock()
+		defer fc.mux.Unlock()
+		if conflicts > 0 {
+			conflicts--
+			fc.set("key", []byte("theirs"))
+		}
+	}
+	w.ShouldSucceed(cs.Put(ctx, "key", []byte("ours")))
+	shouldHaveValue(w, cs, "key", "ours")
+
+	// if it always does so, the write eventually fails
+	conflicts = maxCASAttempts
+	w.ShouldFail(cs.Put(ctx, "key", []byte("ours again")))
+	shouldHaveValue(w, cs, "key", "theirs")
+}
+
+func TestConsulStore_Errors(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	cs := w.ShouldHaveResult(NewConsulStore(server.URL, "")).(*ConsulStore)
+	_, _, err := cs.Get(ctx, "key")
+	w.ShouldFail(err)
+	w.ShouldFail(cs.Put(ctx, "key", []byte("value")))
+	w.ShouldFail(cs.Check(ctx))
+}
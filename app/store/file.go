@@ -0,0 +1,276 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package store provides key/value stores that pipelines can use with their
+// `get` and `put` tasks.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// minCompactRecords is the smallest number of records a FileStore's log must
+// hold before it's considered for compaction.
+const minCompactRecords = 1000
+
+// FileStore is a concurrency-safe k/v store that persists data to disk.
+//
+// Every change is appended to a log file and synced before the call that made
+// it returns. When the store is opened, the log is replayed to rebuild its
+// state; if the service crashed while writing, the incomplete record at the end
+// of the log is discarded. The log is periodically compacted so that it only
+// holds the latest value of each key.
+type FileStore struct {
+	path    string
+	mux     sync.RWMutex
+	data    map[string][]byte
+	file    *os.File
+	size    int64 // size of the log, through its last complete record
+	records int   // number of records in the log
+}
+
+// logRecord is a single change, stored in the log as a line of JSON.
+type logRecord struct {
+	Key     string `json:"k"`
+	Value   []byte `json:"v"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+// OpenFileStore opens the FileStore backed by the log at the given path,
+// creating it if it doesn't exist.
+func OpenFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{path: path, data: map[string][]byte{}}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open store %q", path)
+	}
+
+	if err := fs.replay(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	fs.file = f
+
+	if err := fs.compact(); err != nil {
+		_ = fs.file.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// replay reads the log, rebuilding the store's data. If the log ends with an
+// incomplete record, it's truncated to the end of the last complete one.
+func (fs *FileStore) replay(f *os.File) error {
+	reader := bufio.NewReader(f)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				log.Warnf("Discarding incomplete record at the end of store %q.", fs.path)
+			}
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to read store %q", fs.path)
+		}
+
+		var rec logRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// anything after a corrupt record can't be trusted
+			log.WithError(err).Warnf("Discarding corrupt records at the "+
+				"end of store %q.", fs.path)
+			break
+		}
+
+		fs.apply(rec)
+		fs.records++
+		valid += int64(len(line))
+	}
+
+	fs.size = valid
+	return fs.truncate(f)
+}
+
+// truncate discards anything in the log after its last complete record,
+// leaving the file positioned for the next write.
+func (fs *FileStore) truncate(f *os.File) error {
+	if err := f.Truncate(fs.size); err != nil {
+		return errors.Wrapf(err, "unable to truncate store %q", fs.path)
+	}
+	_, err := f.Seek(fs.size, io.SeekStart)
+	return errors.Wrapf(err, "unable to seek in store %q", fs.path)
+}
+
+func (fs *FileStore) apply(rec logRecord) {
+	if rec.Deleted {
+		delete(fs.data, rec.Key)
+		return
+	}
+	if rec.Value == nil {
+		rec.Value = []byte{}
+	}
+	fs.data[rec.Key] = rec.Value
+}
+
+// Get allows FileStore to act as a DataSource.
+func (fs *FileStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	fs.mux.RLock()
+	v, ok := fs.data[key]
+	fs.mux.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+// Put allows FileStore to act as a Sink.
+//
+// The value is written to disk before Put returns.
+func (fs *FileStore) Put(ctx context.Context, key string, value []byte) error {
+	return fs.write(logRecord{Key: key, Value: value})
+}
+
+// Delete removes a key from the store.
+func (fs *FileStore) Delete(ctx context.Context, key string) error {
+	fs.mux.RLock()
+	_, ok := fs.data[key]
+	fs.mux.RUnlock()
+	if !ok {
+		return nil
+	}
+	return fs.write(logRecord{Key: key, Deleted: true})
+}
+
+// List returns the entries whose keys have the given prefix.
+func (fs *FileStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	fs.mux.RLock()
+	defer fs.mux.RUnlock()
+	return filterPrefix(fs.data, prefix), nil
+}
+
+func (fs *FileStore) write(rec logRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal record")
+	}
+	line = append(line, '\n')
+
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if fs.file == nil {
+		return errors.Errorf("store %q is closed", fs.path)
+	}
+	if _, err := fs.file.Write(line); err != nil {
+		fs.discardPartialWrite()
+		return errors.Wrapf(err, "unable to write to store %q", fs.path)
+	}
+	if err := fs.file.Sync(); err != nil {
+		fs.discardPartialWrite()
+		return errors.Wrapf(err, "unable to sync store %q", fs.path)
+	}
+	fs.apply(rec)
+	fs.records++
+	fs.size += int64(len(line))
+
+	if fs.records >= minCompactRecords && fs.records > 2*len(fs.data) {
+		if err := fs.compact(); err != nil {
+			// the data is safe; the log's just larger than it needs to be
+			log.WithError(err).Warnf("Failed to compact store %q.", fs.path)
+		}
+	}
+	return nil
+}
+
+// discardPartialWrite removes a record that failed to be completely written, so
+// that later records aren't appended after it.
+func (fs *FileStore) discardPartialWrite() {
+	if err := fs.truncate(fs.file); err != nil {
+		log.WithError(err).Errorf("Failed to discard partial write to store %q.", fs.path)
+	}
+}
+
+// compact rewrites the log so it only holds the current data. The new log is
+// written to a temporary file which then replaces the original, so that a
+// crash while compacting leaves the original log intact.
+//
+// The caller must hold the write lock, or have exclusive access to the store.
+func (fs *FileStore) compact() error {
+	if fs.records == len(fs.data) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for k, v := range fs.data {
+		if err := enc.Encode(logRecord{Key: k, Value: v}); err != nil {
+			return errors.Wrap(err, "unable to marshal record")
+		}
+	}
+
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "unable to compact store %q", fs.path)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "unable to compact store %q", fs.path)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "unable to compact store %q", fs.path)
+	}
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "unable to compact store %q", fs.path)
+	}
+	syncDir(filepath.Dir(fs.path))
+
+	_ = fs.file.Close()
+	fs.file = tmp
+	fs.size = int64(buf.Len())
+	fs.records = len(fs.data)
+	return nil
+}
+
+// syncDir syncs a directory so that a rename within it is durable.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// Close closes the store's log. The store can't be modified after it's closed.
+func (fs *FileStore) Close() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
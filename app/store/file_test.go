@@ -0,0 +1,131 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+)
+
+// the FileStore must be usable with the `get` and `put` tasks
+var _ goplumber.DataSource = (*FileStore)(nil)
+var _ goplumber.Sink = (*FileStore)(nil)
+
+func withTempDir(w *expect.TWrapper, f func(dir string)) {
+	w.Helper()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "store")).(string)
+	defer os.RemoveAll(dir)
+	f(dir)
+}
+
+func shouldHaveValue(w *expect.TWrapper, ds goplumber.DataSource, key, value string) {
+	w.Helper()
+	v, ok, err := ds.Get(context.Background(), key)
+	w.ShouldSucceed(err)
+	w.As(key).ShouldBeTrue(ok)
+	w.As(key).ShouldBeEqual(string(v), value)
+}
+
+func TestFileStore_PutGet(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	withTempDir(w, func(dir string) {
+		path := filepath.Join(dir, "kv.log")
+		ctx := context.Background()
+
+		fs := w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		_, ok, err := fs.Get(ctx, "asn.lastUpdated")
+		w.ShouldSucceed(err)
+		w.ShouldBeFalse(ok)
+
+		w.ShouldSucceed(fs.Put(ctx, "asn.lastUpdated", []byte("100")))
+		w.ShouldSucceed(fs.Put(ctx, "sku.lastUpdated", []byte("200")))
+		w.ShouldSucceed(fs.Put(ctx, "asn.lastUpdated", []byte("300")))
+		w.ShouldSucceed(fs.Put(ctx, "empty", nil))
+		shouldHaveValue(w, fs, "asn.lastUpdated", "300")
+		shouldHaveValue(w, fs, "empty", "")
+		w.ShouldSucceed(fs.Close())
+		w.ShouldFail(fs.Put(ctx, "closed", []byte("x")))
+
+		// reopening the store restores its data
+		fs = w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		shouldHaveValue(w, fs, "asn.lastUpdated", "300")
+		shouldHaveValue(w, fs, "sku.lastUpdated", "200")
+		shouldHaveValue(w, fs, "empty", "")
+
+		testListDelete(w, fs)
+		w.ShouldSucceed(fs.Close())
+
+		// deletions persist, too
+		fs = w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		defer w.ShouldSucceedLater(fs.Close)
+		entries := w.ShouldHaveResult(fs.List(ctx, "")).(map[string][]byte)
+		w.ShouldHaveLength(entries, 2)
+		w.ShouldContain(entries, []string{"asn.lastUpdated", "empty"})
+	})
+}
+
+func TestFileStore_Recovery(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	withTempDir(w, func(dir string) {
+		path := filepath.Join(dir, "kv.log")
+		ctx := context.Background()
+
+		fs := w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		w.ShouldSucceed(fs.Put(ctx, "a", []byte("1")))
+		w.ShouldSucceed(fs.Put(ctx, "b", []byte("2")))
+		w.ShouldSucceed(fs.Close())
+
+		// simulate a crash in the middle of writing a record
+		f := w.ShouldHaveResult(os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)).(*os.File)
+		w.ShouldHaveResult(f.WriteString(`{"k":"c","v":"Mw`))
+		w.ShouldSucceed(f.Close())
+
+		fs = w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		shouldHaveValue(w, fs, "a", "1")
+		shouldHaveValue(w, fs, "b", "2")
+		_, ok, _ := fs.Get(ctx, "c")
+		w.ShouldBeFalse(ok)
+
+		// new writes follow the last complete record
+		w.ShouldSucceed(fs.Put(ctx, "c", []byte("3")))
+		w.ShouldSucceed(fs.Close())
+
+		fs = w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		defer w.ShouldSucceedLater(fs.Close)
+		shouldHaveValue(w, fs, "a", "1")
+		shouldHaveValue(w, fs, "c", "3")
+	})
+}
+
+func TestFileStore_Compaction(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	withTempDir(w, func(dir string) {
+		path := filepath.Join(dir, "kv.log")
+		ctx := context.Background()
+
+		fs := w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		for i := 0; i < minCompactRecords*2; i++ {
+			w.ShouldSucceed(fs.Put(ctx, fmt.Sprintf("key%d", i%3), []byte(fmt.Sprint(i))))
+		}
+		w.ShouldBeTrue(fs.records < minCompactRecords)
+		w.ShouldSucceed(fs.Close())
+
+		fs = w.ShouldHaveResult(OpenFileStore(path)).(*FileStore)
+		defer w.ShouldSucceedLater(fs.Close)
+		w.ShouldBeEqual(fs.records, 3)
+		shouldHaveValue(w, fs, "key0", fmt.Sprint(minCompactRecords*2-2))
+		shouldHaveValue(w, fs, "key1", fmt.Sprint(minCompactRecords*2-1))
+		shouldHaveValue(w, fs, "key2", fmt.Sprint(minCompactRecords*2-3))
+	})
+}
@@ -0,0 +1,88 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+)
+
+// Store is a key/value store that serves the `get` and `put` tasks.
+type Store interface {
+	goplumber.DataSource
+	goplumber.Sink
+
+	// Delete removes a key from the store. Deleting a key that isn't present
+	// isn't an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns the keys and values of all entries whose keys start with
+	// the given prefix; an empty prefix lists every entry.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+}
+
+// MemoryStore is a concurrency-safe in-memory Store.
+type MemoryStore struct {
+	mux  sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string][]byte{}}
+}
+
+// Get allows MemoryStore to act as a DataSource.
+func (ms *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	ms.mux.RLock()
+	v, ok := ms.data[key]
+	ms.mux.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+// Put allows MemoryStore to act as a Sink.
+func (ms *MemoryStore) Put(ctx context.Context, key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	ms.mux.Lock()
+	ms.data[key] = value
+	ms.mux.Unlock()
+	return nil
+}
+
+// Delete removes a key from the store.
+func (ms *MemoryStore) Delete(ctx context.Context, key string) error {
+	ms.mux.Lock()
+	delete(ms.data, key)
+	ms.mux.Unlock()
+	return nil
+}
+
+// List returns the entries whose keys have the given prefix.
+func (ms *MemoryStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	ms.mux.RLock()
+	defer ms.mux.RUnlock()
+	return filterPrefix(ms.data, prefix), nil
+}
+
+// filterPrefix returns a new map with the entries whose keys have the prefix.
+func filterPrefix(data map[string][]byte, prefix string) map[string][]byte {
+	entries := map[string][]byte{}
+	for k, v := range data {
+		if strings.HasPrefix(k, prefix) {
+			entries[k] = v
+		}
+	}
+	return entries
+}
@@ -0,0 +1,51 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+var _ Store = (*MemoryStore)(nil)
+var _ Store = (*FileStore)(nil)
+
+// testListDelete checks List and Delete on a store which holds a value for
+// "sku.lastUpdated" and at least one other key not starting with "sku.".
+func testListDelete(w *expect.TWrapper, s Store) {
+	w.Helper()
+	ctx := context.Background()
+
+	w.ShouldSucceed(s.Put(ctx, "sku.other", []byte("other")))
+	entries := w.ShouldHaveResult(s.List(ctx, "sku.")).(map[string][]byte)
+	w.ShouldHaveLength(entries, 2)
+	w.ShouldBeEqual(string(entries["sku.other"]), "other")
+	w.ShouldContain(entries, []string{"sku.lastUpdated"})
+
+	all := w.ShouldHaveResult(s.List(ctx, "")).(map[string][]byte)
+	w.ShouldBeTrue(len(all) > len(entries))
+
+	w.ShouldSucceed(s.Delete(ctx, "sku.other"))
+	w.ShouldSucceed(s.Delete(ctx, "sku.lastUpdated"))
+	w.ShouldSucceed(s.Delete(ctx, "sku.lastUpdated"))
+	_, ok, err := s.Get(ctx, "sku.lastUpdated")
+	w.ShouldSucceed(err)
+	w.ShouldBeFalse(ok)
+	w.ShouldBeEmpty(w.ShouldHaveResult(s.List(ctx, "sku.")))
+}
+
+func TestMemoryStore(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	ctx := context.Background()
+	ms := NewMemoryStore()
+	w.ShouldSucceed(ms.Put(ctx, "sku.lastUpdated", []byte("1")))
+	w.ShouldSucceed(ms.Put(ctx, "asn.lastUpdated", []byte("2")))
+	shouldHaveValue(w, ms, "sku.lastUpdated", "1")
+	testListDelete(w, ms)
+}
@@ -3,11 +3,15 @@ module github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service
 go 1.12
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.2.0
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gorilla/mux v0.0.0-20181030152528-3d80bc801bb0
 	github.com/intel/rsp-sw-toolkit-im-suite-expect v1.1.4
 	github.com/intel/rsp-sw-toolkit-im-suite-goplumber v0.1.0
 	github.com/intel/rsp-sw-toolkit-im-suite-utilities v0.1.0
 	github.com/pborman/uuid v1.2.0
 	github.com/pkg/errors v0.8.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.4.1
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
 )
@@ -7,11 +7,14 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"os"
 
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/config"
 	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/healthcheck"
 	"github.com/intel/rsp-sw-toolkit-im-suite-utilities/go-metrics"
+	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
 )
@@ -28,12 +31,59 @@ func exitIfError(err error, errorGauge metrics.Gauge, args ...interface{}) {
 		}).Fatal(args...)
 }
 
+// healthFlag is the -isHealthy flag. It's a boolean flag, so -isHealthy checks
+// whether the service is live, but -isHealthy=ready checks whether it's ready.
+type healthFlag string
+
+func (hf *healthFlag) String() string { return string(*hf) }
+
+func (hf *healthFlag) IsBoolFlag() bool { return true }
+
+func (hf *healthFlag) Set(value string) error {
+	switch value {
+	case "true", "live":
+		*hf = "live"
+	case "false":
+		*hf = ""
+	case "ready":
+		*hf = "ready"
+	default:
+		return errors.New("must be true, false, live, or ready")
+	}
+	return nil
+}
+
 func healthCheck(port string) {
-	isHealthyPtr := flag.Bool("isHealthy", false, "a bool, runs a healthcheck")
+	var check healthFlag
+	flag.Var(&check, "isHealthy", "runs a healthcheck; use -isHealthy=ready to check readiness")
 	flag.Parse()
 
-	if *isHealthyPtr {
-		status := healthcheck.Healthcheck(port)
-		os.Exit(status)
+	if check == "" {
+		return
+	}
+	const readyPath = "/health/ready"
+	if !config.AppConfig.UseTLS() {
+		if check == "ready" {
+			os.Exit(healthcheck.CheckPath(port, readyPath))
+		}
+		os.Exit(healthcheck.Healthcheck(port))
+	}
+
+	// The check connects to the loopback address, which the server's
+	// certificate is unlikely to name, so it doesn't verify the server.
+	// If the server verifies clients, the check presents the server's own
+	// certificate, which must therefore be trusted by the client CAs.
+	tlsConfig := &tls.Config{InsecureSkipVerify: true} // nolint: gosec
+	if config.AppConfig.TLSClientCAFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.AppConfig.TLSCertFile, config.AppConfig.TLSKeyFile)
+		if err != nil {
+			logrus.WithError(err).Error("Unable to load TLS certificate for healthcheck.")
+			os.Exit(1)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if check == "ready" {
+		os.Exit(healthcheck.CheckPathTLS(port, readyPath, tlsConfig))
 	}
+	os.Exit(healthcheck.HealthcheckTLS(port, tlsConfig))
 }
@@ -8,17 +8,23 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/broker/logger"
 	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/routes"
-	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/tracing"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 
@@ -36,9 +42,27 @@ func main() {
 	// Load config variables
 	exitIfError(config.InitConfig(), mConfigurationError, "Unable to load configuration variables.")
 
-	setLogLevel(config.AppConfig.LoggingLevel)
+	// health checks run as separate processes, which shouldn't open the log file
 	healthCheck(config.AppConfig.Port)
+	logFile, err := initLogging()
+	exitIfError(err, mConfigurationError, "Failed to configure logging.")
 	initMetrics()
+	if logFile != nil {
+		defer func() {
+			if err := logFile.Close(); err != nil {
+				golog.Printf("Failed to close log file: %v\n", err)
+			}
+		}()
+	}
+	tracer, err := initTracing()
+	exitIfError(err, mConfigurationError, "Failed to configure tracing.")
+	if tracer != nil {
+		defer func() {
+			if err := tracer.Close(); err != nil {
+				log.WithError(err).Error("Failed to export traces.")
+			}
+		}()
+	}
 
 	logMain := func(args ...interface{}) {
 		log.WithFields(log.Fields{
@@ -52,10 +76,66 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	exitIfError(loadPipelines(ctx), mPipelineErr, "Failed to start pipelines.")
+	kvData, err := openKVStore()
+	exitIfError(err, mPipelineErr, "Failed to open key/value store.")
+	if closer, ok := kvData.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				log.WithError(err).Error("Failed to close key/value store.")
+			}
+		}()
+	}
+
+	registry := scheduler.NewRegistry()
+	registry.LimitConcurrency(config.AppConfig.MaxConcurrentPipelines)
+	registry.CaptureLogs(config.AppConfig.PipelineLogSize)
+	if config.AppConfig.DataDir != "" {
+		statePath, err := dataPath("pipelines.json")
+		exitIfError(err, mPipelineErr, "Failed to load pipeline state.")
+		exitIfError(registry.UseStateFile(statePath), mPipelineErr, "Failed to load pipeline state.")
+	}
+	runs, err := openRunStore()
+	exitIfError(err, mPipelineErr, "Failed to open run history.")
+	if closer, ok := runs.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				log.WithError(err).Error("Failed to close run history.")
+			}
+		}()
+	}
+	history, err := scheduler.NewHistory(runs,
+		config.AppConfig.RunHistorySize, config.AppConfig.RunHistoryMaxAge)
+	exitIfError(err, mPipelineErr, "Failed to load run history.")
+	registry.UseHistory(history)
+
+	loader := newPipelineLoader(config.AppConfig, registry, kvData)
+	registry.UseSubscriber(loader.subscribers)
+	defer loader.subscribers.Close()
+	exitIfError(loader.loadInitial(), mPipelineErr, "Failed to start pipelines.")
+	registry.Start(ctx)
+	go loader.watch(ctx)
+	go loader.reloadOnHangup(ctx)
+	if logFile != nil {
+		go reopenLogOnSignal(ctx, logFile)
+	}
 
-	router := routes.NewRouter()
-	startWebServer(router)
+	health := routes.HealthChecks{
+		StaleAfter:   config.AppConfig.ReadinessStaleAfter,
+		Dependencies: map[string]routes.Dependency{"mqtt": loader.subscribers.Check},
+	}
+	if checker, ok := kvData.(interface {
+		Check(ctx context.Context) error
+	}); ok {
+		health.Dependencies["kvStore"] = checker.Check
+	}
+	router := routes.NewRouter(registry, kvData, loader.reload, health)
+	startWebServer(router, func() {
+		// finish running pipelines before the server (and /health) goes away
+		aborted := registry.Drain(config.AppConfig.DrainTimeout)
+		if len(aborted) != 0 {
+			log.WithField("pipelines", aborted).Warn("Aborted running pipelines.")
+		}
+	})
 
 	log.WithField("Method", "main").Info("Completed.")
 }
@@ -67,109 +147,97 @@ func (uuidGen) Execute(ctx context.Context, w io.Writer, links map[string][]byte
 	return err
 }
 
-func loadPipelines(ctx context.Context) error {
-	log.Debug("Starting pipelines.")
-	plumber := goplumber.NewPlumber()
-
-	// load pipelines and templates from the filesystem
-	loader := goplumber.NewFileSystem(config.AppConfig.TemplatesDir)
-	plumber.SetTemplateSource("template", loader)
-
-	// add a task for getting Docker secrets
-	plumber.SetSource("secret",
-		goplumber.NewFileSystem(config.AppConfig.SecretsPath))
-
-	// just use memory for K/V data; later, use consul or a db
-	kvData := goplumber.NewMemoryStore()
-	plumber.SetSource("get", kvData)
-	plumber.SetSink("put", kvData)
-
-	// add uuid generator
-	plumber.SetClient("uuid", goplumber.PipeFunc(
-		func(task *goplumber.Task) (goplumber.Pipe, error) { return uuidGen{}, nil }))
-
-	log.Debug("Loading MQTT clients (if any).")
-	pipedata := goplumber.NewFileSystem(config.AppConfig.PipelinesDir)
-	for _, fn := range config.AppConfig.MQTTClients {
-		name := fn
-		if !strings.HasSuffix(fn, ".json") {
-			fn += ".json"
-		}
-		mqttConfData, err := pipedata.GetFile(fn)
-		if err != nil {
-			return errors.Wrapf(err, "unable to load mqtt config %q", fn)
-		}
-		mc := &goplumber.MQTTClient{}
-		if err := json.Unmarshal(mqttConfData, mc); err != nil {
-			return errors.Wrapf(err, "unable to unmarshal mqtt config for %q", fn)
-		}
-		plumber.SetSink(name, mc)
+// dataPath returns the path of a file with the given name in the data
+// directory, creating the directory if necessary.
+func dataPath(name string) (string, error) {
+	dir := config.AppConfig.DataDir
+	if dir == "" {
+		return "", errors.New("dataDir is not configured")
 	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "unable to create data directory %q", dir)
+	}
+	return filepath.Join(dir, name), nil
+}
 
-	log.Debug("Loading custom task types from pipelines.")
-	for _, name := range config.AppConfig.CustomTaskTypes {
-		data, err := pipedata.GetFile(name)
-		if err != nil {
-			return errors.Wrapf(err, "failed to load pipeline from file %q", name)
-		}
-
-		var pipelineConf goplumber.PipelineConfig
-		if err := json.Unmarshal(data, &pipelineConf); err != nil {
-			return errors.Wrapf(err, "failed to unmarshal pipeline config from %q", name)
-		}
+// openRunStore returns the store for the pipelines' run history, which is kept
+// in the data directory, if there is one, or otherwise in memory.
+func openRunStore() (store.Store, error) {
+	if config.AppConfig.DataDir == "" {
+		return store.NewMemoryStore(), nil
+	}
+	path, err := dataPath("runs.log")
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Using run history %q.", path)
+	return store.OpenFileStore(path)
+}
 
-		taskType, err := plumber.NewPipeline(&pipelineConf)
+// openKVStore returns the configured store for the `get` and `put` tasks.
+func openKVStore() (store.Store, error) {
+	switch config.AppConfig.KVStore {
+	case "memory":
+		return store.NewMemoryStore(), nil
+	case "file":
+		path, err := dataPath("kv.log")
 		if err != nil {
-			return errors.Wrapf(err, "failed to load pipeline %q", name)
+			return nil, errors.WithMessage(err, "the file kvStore requires a dataDir")
 		}
-		client, err := goplumber.NewTaskType(taskType)
-		if err != nil {
-			return errors.Wrapf(err, "failed to create client for %q", name)
-		}
-		plumber.SetClient(pipelineConf.Name, client)
+		log.Debugf("Using key/value store %q.", path)
+		return store.OpenFileStore(path)
+	case "consul":
+		log.Debugf("Using consul key/value store at %q with prefix %q.",
+			config.AppConfig.ConsulURL, config.AppConfig.ConsulKVPrefix)
+		return store.NewConsulStore(config.AppConfig.ConsulURL, config.AppConfig.ConsulKVPrefix)
+	default:
+		return nil, errors.Errorf("unknown kvStore %q", config.AppConfig.KVStore)
 	}
+}
 
-	// only load the configured names
-	log.Debug("Loading pipelines.")
-	plines := map[*goplumber.Pipeline]time.Duration{}
-	for _, name := range config.AppConfig.PipelineNames {
-		data, err := pipedata.GetFile(name)
-		if err != nil {
-			return errors.Wrapf(err, "failed to load pipeline %q", name)
-		}
+// serverTLSConfig returns the TLS configuration for the HTTP server, or nil if
+// it shouldn't use TLS.
+func serverTLSConfig() (*tls.Config, error) {
+	if !config.AppConfig.UseTLS() {
+		return nil, nil
+	}
 
-		var pipelineConf goplumber.PipelineConfig
-		if err := json.Unmarshal(data, &pipelineConf); err != nil {
-			return errors.Wrapf(err, "failed to unmarshal pipeline config from %q", name)
-		}
+	cert, err := tls.LoadX509KeyPair(config.AppConfig.TLSCertFile, config.AppConfig.TLSKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load TLS certificate")
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
 
-		p, err := plumber.NewPipeline(&pipelineConf)
+	if config.AppConfig.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(config.AppConfig.TLSClientCAFile)
 		if err != nil {
-			return errors.Wrapf(err, "failed to load pipeline %s", name)
+			return nil, errors.Wrap(err, "unable to load TLS client CAs")
 		}
-
-		d := pipelineConf.Trigger.Interval.Duration()
-		if d <= 0 {
-			old := d
-			d = time.Duration(2) * time.Minute
-			log.Warningf("setting pipeline %q interval from %s to %s",
-				pipelineConf.Name, old, d)
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %q",
+				config.AppConfig.TLSClientCAFile)
 		}
-		plines[p] = d
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
-
-	log.Debugf("Starting %d pipelines.", len(plines))
-	for p, d := range plines {
-		go goplumber.RunPipelineForever(ctx, p, d)
-	}
-	return nil
+	return tlsConfig, nil
 }
 
-func startWebServer(router http.Handler) {
+// startWebServer serves requests until the service receives SIGINT or SIGTERM,
+// then calls beforeShutdown and shuts down the server.
+func startWebServer(router http.Handler, beforeShutdown func()) {
+	mTLSError := metrics.GetOrRegisterGauge("DataProvider.Main.TLSConfigurationError", nil)
+	tlsConfig, err := serverTLSConfig()
+	exitIfError(err, mTLSError, "Failed to configure TLS.")
+
 	// Create a new server and set timeout values.
 	server := http.Server{
-		Addr:           ":8080",
+		Addr:           ":" + config.AppConfig.Port,
 		Handler:        router,
+		TLSConfig:      tlsConfig,
 		ReadTimeout:    900 * time.Second,
 		WriteTimeout:   900 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -181,17 +249,24 @@ func startWebServer(router http.Handler) {
 
 	// Start the listener.
 	go func() {
-		log.Infof("%s running!", config.AppConfig.ServiceName)
-		log.Infof("Listener closed: %v", server.ListenAndServe())
+		log.Infof("%s running on %s!", config.AppConfig.ServiceName, server.Addr)
+		if tlsConfig != nil {
+			// the certificate is already in the TLSConfig
+			log.Infof("Listener closed: %v", server.ListenAndServeTLS("", ""))
+		} else {
+			log.Infof("Listener closed: %v", server.ListenAndServe())
+		}
 		wg.Done()
 	}()
 
-	// Listen for an interrupt signal from the OS.
+	// Listen for an interrupt or terminate signal from the OS.
 	osSignals := make(chan os.Signal, 1)
-	signal.Notify(osSignals, os.Interrupt)
+	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
 
 	// Wait for a signal to shutdown.
-	<-osSignals
+	sig := <-osSignals
+	log.Infof("Received %s; shutting down.", sig)
+	beforeShutdown()
 
 	// Create a context to attempt a graceful 5 second shutdown.
 	const timeout = 5 * time.Second
@@ -222,19 +297,69 @@ func startWebServer(router http.Handler) {
 	wg.Wait()
 }
 
+// initLogging configures the format and output of the service's and
+// pipelines' logs, and sets their level. If they're written to a file, it
+// returns the file, which is rotated according to the configuration.
+func initLogging() (*logger.RotatingFile, error) {
+	format, err := logger.ParseLogFormat(config.AppConfig.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	var output io.Writer = os.Stderr
+	var logFile *logger.RotatingFile
+	if config.AppConfig.LogFile != "" {
+		logFile, err = logger.OpenRotatingFile(config.AppConfig.LogFile, logger.Rotation{
+			MaxSize:    int64(config.AppConfig.LogMaxSizeMB) << 20,
+			MaxAge:     config.AppConfig.LogMaxAge,
+			MaxBackups: config.AppConfig.LogMaxBackups,
+			Compress:   config.AppConfig.LogCompress,
+			OnCleanupError: func(err error) {
+				log.WithError(err).Error("Failed to clean up rotated log files.")
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		output = logFile
+	}
+
+	logger.Configure(format, output, logger.GlobalLevel())
+	setLogLevel(config.AppConfig.LoggingLevel)
+	return logFile, nil
+}
+
+// reopenLogOnSignal reopens the log file each time the service receives
+// SIGUSR1, so that it can be rotated by another program, until ctx is
+// canceled.
+func reopenLogOnSignal(ctx context.Context, logFile *logger.RotatingFile) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := logFile.Reopen(); err != nil {
+				// the log file isn't usable, so this goes to stderr
+				golog.Printf("Failed to reopen log file: %v\n", err)
+				continue
+			}
+			log.Info("Received SIGUSR1; reopened log file.")
+		}
+	}
+}
+
+// setLogLevel sets the level of the logs, except for those of pipelines whose
+// levels have been set via the API. Unknown levels are treated as "info".
 func setLogLevel(loggingLevel string) {
-	switch strings.ToLower(loggingLevel) {
-	case "error":
-		log.SetLevel(log.ErrorLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
-	case "info":
-		log.SetLevel(log.InfoLevel)
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	default:
-		log.SetLevel(log.InfoLevel)
+	level, err := logger.ParseLogLevel(loggingLevel)
+	if err != nil {
+		level = logger.InfoLevel
 	}
+	logger.SetGlobalLevel(level)
 
 	// Not using filtered func (Info, etc ) so that message is always logged
 	golog.Printf("Logging level set to %s\n", loggingLevel)
@@ -245,12 +370,46 @@ func initMetrics() {
 	if config.AppConfig.TelemetryEndpoint != "" {
 		go reporter.InfluxDBWithTags(
 			metrics.DefaultRegistry,
-			time.Second*10, // cfg.ReportingInterval,
+			config.AppConfig.TelemetryInterval,
 			config.AppConfig.TelemetryEndpoint,
 			config.AppConfig.TelemetryDataStoreName,
-			"",
-			"",
+			config.AppConfig.TelemetryUsername,
+			config.AppConfig.TelemetryPassword,
 			nil,
 		)
 	}
 }
+
+// initTracing sets the default tracer for pipeline executions according to the
+// configured tracingExporter, and returns it, or nil if tracing is disabled.
+func initTracing() (*tracing.Tracer, error) {
+	service := config.AppConfig.ServiceName
+	var exporter tracing.Exporter
+	switch config.AppConfig.TracingExporter {
+	case "none":
+		return nil, nil
+	case "otlp":
+		log.Debugf("Exporting traces to %q.", config.AppConfig.TracingEndpoint)
+		exporter = tracing.NewOTLPExporter(config.AppConfig.TracingEndpoint, service)
+	case "file":
+		path := config.AppConfig.TracingFile
+		if path == "" {
+			var err error
+			if path, err = dataPath("traces.json"); err != nil {
+				return nil, errors.WithMessage(err, "the file tracingExporter requires a tracingFile or dataDir")
+			}
+		}
+		log.Debugf("Exporting traces to %q.", path)
+		fe, err := tracing.NewFileExporter(path, service)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fe
+	default:
+		return nil, errors.Errorf("unknown tracingExporter %q", config.AppConfig.TracingExporter)
+	}
+
+	tracer := tracing.NewTracer(exporter)
+	tracing.SetDefault(tracer)
+	return tracer, nil
+}
@@ -0,0 +1,152 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package filewatch reports changes to files and directories.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSettleTime is how long a Watcher waits for changes to stop before
+// reporting them, so that a file written in several steps, or a group of files
+// copied together, results in a single notification.
+const DefaultSettleTime = 500 * time.Millisecond
+
+// Watcher reports changes to a set of files and directories, including the
+// contents of the directories and their subdirectories.
+//
+// It uses file system notifications when they're available, and also polls the
+// paths periodically, since notifications aren't delivered for some changes,
+// such as those made to a bind-mounted file from outside a container. If the
+// PollInterval isn't positive, the paths aren't polled.
+type Watcher struct {
+	Paths        []string
+	PollInterval time.Duration
+	SettleTime   time.Duration
+}
+
+// fileInfo is the part of a file's state used to detect changes.
+type fileInfo struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+}
+
+// Run calls onChange each time the watched paths change, until ctx is canceled.
+//
+// Changes are detected by comparing the paths' modification times and sizes,
+// so notifications that don't change them, such as a file being touched
+// without being modified, don't call onChange.
+func (w *Watcher) Run(ctx context.Context, onChange func()) {
+	settle := w.SettleTime
+	if settle <= 0 {
+		settle = DefaultSettleTime
+	}
+
+	var poll <-chan time.Time
+	if w.PollInterval > 0 {
+		ticker := time.NewTicker(w.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil && poll == nil {
+		log.WithError(err).Warn("File system notifications are unavailable, " +
+			"and polling is disabled; changes won't be detected.")
+	} else if err != nil {
+		log.WithError(err).Warn("File system notifications are unavailable; " +
+			"falling back to polling.")
+	} else {
+		defer func() {
+			if err := notifier.Close(); err != nil {
+				log.WithError(err).Warn("Failed to close file system watcher.")
+			}
+		}()
+		events, errs = notifier.Events, notifier.Errors
+	}
+
+	// the timer is only running while waiting for changes to settle
+	settled := time.NewTimer(settle)
+	if !settled.Stop() {
+		<-settled.C
+	}
+	defer settled.Stop()
+
+	snapshot := w.snapshot(notifier)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll:
+		case event := <-events:
+			log.Debugf("Watched file changed: %s.", event)
+			settled.Reset(settle)
+			continue
+		case err := <-errs:
+			log.WithError(err).Warn("File system watcher error.")
+			continue
+		case <-settled.C:
+		}
+
+		current := w.snapshot(notifier)
+		if reflect.DeepEqual(snapshot, current) {
+			continue
+		}
+		snapshot = current
+		onChange()
+	}
+}
+
+// snapshot returns the current state of every file and directory under the
+// watched paths. If notifier isn't nil, the directories are added to it, so
+// that notifications are received for new subdirectories.
+func (w *Watcher) snapshot(notifier *fsnotify.Watcher) map[string]fileInfo {
+	files := map[string]fileInfo{}
+	for _, root := range w.Paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// a path that doesn't exist yet may be created later
+				return nil
+			}
+
+			// follow symlinks, which are how Kubernetes updates mounted files
+			if info.Mode()&os.ModeSymlink != 0 {
+				if target, err := os.Stat(path); err == nil {
+					info = target
+				}
+			}
+			files[path] = fileInfo{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+
+			if notifier != nil && (info.IsDir() || path == root) {
+				watchPath := path
+				if !info.IsDir() {
+					// watch a file's directory, since editors often replace
+					// files rather than writing to them
+					watchPath = filepath.Dir(path)
+				}
+				if err := notifier.Add(watchPath); err != nil {
+					log.WithError(err).Debugf("Unable to watch %q.", watchPath)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.WithError(err).Warnf("Unable to check %q for changes.", root)
+		}
+	}
+	return files
+}
@@ -0,0 +1,75 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package filewatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+// waitForChange returns true if a value is received on changes within a few
+// seconds.
+func waitForChange(changes <-chan struct{}) bool {
+	select {
+	case <-changes:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestWatcher_Run(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "filewatch")).(string)
+	defer os.RemoveAll(dir)
+
+	pipelines := filepath.Join(dir, "pipelines")
+	w.ShouldSucceed(os.Mkdir(pipelines, 0700))
+	w.ShouldSucceed(ioutil.WriteFile(filepath.Join(pipelines, "a.json"), []byte("{}"), 0600))
+	confFile := filepath.Join(dir, "configuration.json")
+	w.ShouldSucceed(ioutil.WriteFile(confFile, []byte("{}"), 0600))
+
+	for _, pollInterval := range []time.Duration{0, 20 * time.Millisecond} {
+		ctx, cancel := context.WithCancel(context.Background())
+		changes := make(chan struct{}, 10)
+		watcher := &Watcher{
+			Paths:        []string{pipelines, confFile},
+			PollInterval: pollInterval,
+			SettleTime:   20 * time.Millisecond,
+		}
+		go watcher.Run(ctx, func() { changes <- struct{}{} })
+		time.Sleep(100 * time.Millisecond)
+
+		w.As("new file").ShouldSucceed(ioutil.WriteFile(
+			filepath.Join(pipelines, "b.json"), []byte("{}"), 0600))
+		w.As("new file").ShouldBeTrue(waitForChange(changes))
+
+		w.As("modified file").ShouldSucceed(ioutil.WriteFile(
+			confFile, []byte(`{"port": "8080"}`), 0600))
+		w.As("modified file").ShouldBeTrue(waitForChange(changes))
+
+		w.As("removed file").ShouldSucceed(os.Remove(filepath.Join(pipelines, "a.json")))
+		w.As("removed file").ShouldBeTrue(waitForChange(changes))
+
+		// files beside a watched file aren't watched
+		w.ShouldSucceed(ioutil.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0600))
+		time.Sleep(200 * time.Millisecond)
+		w.ShouldHaveLength(changes, 0)
+
+		cancel()
+		w.ShouldSucceed(ioutil.WriteFile(filepath.Join(pipelines, "a.json"), []byte("{}"), 0600))
+		w.ShouldSucceed(os.Remove(filepath.Join(pipelines, "b.json")))
+		w.ShouldSucceed(ioutil.WriteFile(confFile, []byte("{}"), 0600))
+		w.ShouldSucceed(os.Remove(filepath.Join(dir, "other")))
+	}
+}
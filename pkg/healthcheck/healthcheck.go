@@ -7,6 +7,7 @@
 package healthcheck
 
 import (
+	"crypto/tls"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
@@ -14,8 +15,31 @@ import (
 
 // Healthcheck performs check to see if server is up and running/responding
 func Healthcheck(port string) int {
-	resp, err := http.Get("http://127.0.0.1:" + port)
-	if err != nil || resp.StatusCode != 200 {
+	return CheckPath(port, "/")
+}
+
+// HealthcheckTLS performs the same check as Healthcheck, but over HTTPS using
+// the given TLS configuration.
+func HealthcheckTLS(port string, tlsConfig *tls.Config) int {
+	return CheckPathTLS(port, "/", tlsConfig)
+}
+
+// CheckPath performs the same check as Healthcheck, but requests the given
+// path, such as a readiness endpoint.
+func CheckPath(port, path string) int {
+	return check(http.DefaultClient, "http://127.0.0.1:"+port+path)
+}
+
+// CheckPathTLS performs the same check as CheckPath, but over HTTPS using the
+// given TLS configuration.
+func CheckPathTLS(port, path string, tlsConfig *tls.Config) int {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return check(client, "https://127.0.0.1:"+port+path)
+}
+
+func check(client *http.Client, url string) int {
+	resp, err := client.Get(url)
+	if err != nil {
 		return 1
 	}
 	defer func() {
@@ -25,5 +49,8 @@ func Healthcheck(port string) int {
 			}).Warning("Failed to close response.")
 		}
 	}()
+	if resp.StatusCode != 200 {
+		return 1
+	}
 	return 0
 }
@@ -7,8 +7,12 @@
 package healthcheck
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -61,3 +65,35 @@ func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	response.Body = ioutil.NopCloser(strings.NewReader("Service running"))
 	return response, nil
 }
+
+func TestHealthcheckTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if status == "healthy" {
+			rw.WriteHeader(http.StatusOK)
+		} else {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := serverURL.Port()
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+	tlsConfig.RootCAs.AddCert(server.Certificate())
+
+	status = "healthy"
+	if HealthcheckTLS(port, tlsConfig) != 0 {
+		t.Error("HealthcheckTLS healthy status should return 0")
+	}
+	if HealthcheckTLS(port, &tls.Config{}) == 0 {
+		t.Error("HealthcheckTLS should fail if the server's certificate isn't trusted")
+	}
+
+	status = "unhealthy"
+	if HealthcheckTLS(port, tlsConfig) == 0 {
+		t.Error("HealthcheckTLS unhealthy status should return 1")
+	}
+}
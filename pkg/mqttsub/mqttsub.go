@@ -0,0 +1,409 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package mqttsub subscribes to MQTT topics, and restores the subscriptions
+// whenever the connection to the broker is restored.
+package mqttsub
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config configures a Client. It has the same fields as the service's MQTT
+// client configuration files.
+type Config struct {
+	Endpoint       string
+	ClientID       string
+	Username       string
+	Password       string
+	TimeoutSecs    int
+	SkipCertVerify bool
+}
+
+// Message is a message published to a subscribed topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Handler is called with each message published to a subscribed topic.
+type Handler func(Message)
+
+// maxRetryInterval is the longest a Client waits between attempts to connect
+// to the broker.
+const maxRetryInterval = time.Minute
+
+// Client maintains a connection to an MQTT broker and the subscriptions made
+// with it.
+//
+// The Client subscribes to each distinct topic filter once, with the highest
+// QoS requested for it, and delivers each message to every Handler whose
+// filter matches the message's topic.
+type Client struct {
+	conf    Config
+	timeout time.Duration
+	client  mqtt.Client
+	changed chan struct{} // tells maintain the subscriptions may need changes
+	done    chan struct{}
+
+	mux        sync.Mutex
+	closed     bool
+	handlers   map[int]subscription
+	nextID     int
+	session    int             // incremented each time the client connects
+	subscribed map[string]byte // filters the broker has acknowledged this session
+}
+
+type subscription struct {
+	filter string
+	qos    byte
+	handle Handler
+}
+
+// Dial returns a Client that connects to the broker in the background,
+// retrying until it succeeds or the Client is closed. Subscriptions may be
+// made before the connection succeeds.
+func Dial(conf Config) (*Client, error) {
+	if conf.Endpoint == "" {
+		return nil, errors.New("missing MQTT endpoint")
+	}
+	endpoint := conf.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "tcp://" + endpoint
+	}
+	brokerURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid MQTT endpoint %q", conf.Endpoint)
+	}
+	if (conf.Username == "") != (conf.Password == "") {
+		return nil, errors.New("MQTT username and password must be used together")
+	}
+
+	c := &Client{
+		conf:       conf,
+		timeout:    time.Duration(conf.TimeoutSecs) * time.Second,
+		changed:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		handlers:   map[int]subscription{},
+		subscribed: map[string]byte{},
+	}
+	if c.timeout <= 0 {
+		c.timeout = 30 * time.Second
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(brokerURL.String()).
+		SetClientID(conf.ClientID).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectTimeout(c.timeout).
+		SetMaxReconnectInterval(maxRetryInterval).
+		SetTLSConfig(&tls.Config{InsecureSkipVerify: conf.SkipCertVerify}).
+		SetDefaultPublishHandler(c.dispatch).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.WithError(err).Warnf("MQTT subscriber disconnected from %s.", conf.Endpoint)
+		})
+	c.client = mqtt.NewClient(options)
+
+	go c.connect()
+	go c.maintain()
+	return c, nil
+}
+
+// Subscribe calls handle with each message published to a topic matching the
+// filter, which may include the wildcards "+" and "#", until the returned
+// function is called. The broker delivers the messages with the given QoS, or
+// the highest QoS requested for the same filter by another subscription.
+//
+// Handlers are called one at a time, in the order messages arrive, so they
+// shouldn't block.
+func (c *Client) Subscribe(filter string, qos byte, handle Handler) (func(), error) {
+	if err := ValidateFilter(filter); err != nil {
+		return nil, err
+	}
+	if qos > 2 {
+		return nil, errors.Errorf("invalid QoS %d; it must be 0, 1, or 2", qos)
+	}
+
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return nil, errors.New("the MQTT client is closed")
+	}
+	id := c.nextID
+	c.nextID++
+	c.handlers[id] = subscription{filter: filter, qos: qos, handle: handle}
+	c.mux.Unlock()
+	c.notify()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mux.Lock()
+			delete(c.handlers, id)
+			c.mux.Unlock()
+			c.notify()
+		})
+	}, nil
+}
+
+// Connected returns true if the Client is currently connected to the broker.
+func (c *Client) Connected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// Close disconnects from the broker. Subscriptions made with the Client stop
+// receiving messages.
+func (c *Client) Close() {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return
+	}
+	c.closed = true
+	c.handlers = map[int]subscription{}
+	c.mux.Unlock()
+
+	close(c.done)
+	c.client.Disconnect(250)
+}
+
+// connect connects to the broker, retrying with increasing delays until it
+// succeeds or the Client is closed. Once connected, the mqtt package handles
+// reconnecting.
+func (c *Client) connect() {
+	retry := time.Second
+	for {
+		token := c.client.Connect()
+		if !token.WaitTimeout(c.timeout) {
+			log.Warnf("Timed out connecting the MQTT subscriber to %s.", c.conf.Endpoint)
+		} else if err := token.Error(); err != nil {
+			log.WithError(err).Warnf("Failed to connect the MQTT subscriber to %s.", c.conf.Endpoint)
+		} else {
+			select {
+			case <-c.done: // closed while connecting
+				c.client.Disconnect(250)
+			default:
+			}
+			return
+		}
+
+		log.Debugf("Retrying MQTT connection to %s in %s.", c.conf.Endpoint, retry)
+		select {
+		case <-c.done:
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > maxRetryInterval {
+			retry = maxRetryInterval
+		}
+	}
+}
+
+// onConnect starts a new session; since it's a clean session, the broker
+// doesn't remember the previous one's subscriptions.
+func (c *Client) onConnect(mqtt.Client) {
+	log.Infof("MQTT subscriber connected to %s.", c.conf.Endpoint)
+	c.mux.Lock()
+	c.session++
+	c.subscribed = map[string]byte{}
+	c.mux.Unlock()
+	c.notify()
+}
+
+func (c *Client) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default: // a change is already pending
+	}
+}
+
+// maintain subscribes to and unsubscribes from filters as subscriptions are
+// added and removed, until the Client is closed.
+func (c *Client) maintain() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.changed:
+		}
+
+		for c.client.IsConnectionOpen() && c.syncOne() {
+		}
+	}
+}
+
+// syncOne makes a single change to bring the broker's subscriptions in line
+// with the Client's, returning false if there's nothing to change or the
+// change fails.
+func (c *Client) syncOne() bool {
+	c.mux.Lock()
+	session := c.session
+	want := c.filters()
+	var filter string
+	var qos byte
+	subscribe := false
+	for f, q := range want {
+		if have, ok := c.subscribed[f]; !ok || have != q {
+			filter, qos, subscribe = f, q, true
+			break
+		}
+	}
+	if !subscribe {
+		for f := range c.subscribed {
+			if _, ok := want[f]; !ok {
+				filter = f
+				break
+			}
+		}
+	}
+	c.mux.Unlock()
+
+	if filter == "" {
+		return false
+	}
+
+	var token mqtt.Token
+	if subscribe {
+		log.Debugf("Subscribing to MQTT topic %q with QoS %d.", filter, qos)
+		token = c.client.Subscribe(filter, qos, nil)
+	} else {
+		log.Debugf("Unsubscribing from MQTT topic %q.", filter)
+		token = c.client.Unsubscribe(filter)
+	}
+	if !token.WaitTimeout(c.timeout) {
+		log.Warnf("Timed out changing the subscription to MQTT topic %q; "+
+			"it will be retried when the connection is restored.", filter)
+		return false
+	}
+	if err := token.Error(); err != nil {
+		log.WithError(err).Warnf("Failed to change the subscription to MQTT topic %q; "+
+			"it will be retried when the connection is restored.", filter)
+		return false
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.session != session {
+		return true // the new session starts over
+	}
+	if !subscribe {
+		delete(c.subscribed, filter)
+		return true
+	}
+	if granted := token.(*mqtt.SubscribeToken).Result()[filter]; granted > 2 {
+		log.Errorf("The MQTT broker rejected the subscription to topic %q.", filter)
+	} else if granted < qos {
+		log.Warnf("The MQTT broker downgraded the subscription to topic %q "+
+			"from QoS %d to %d.", filter, qos, granted)
+	}
+	// record the requested QoS so a rejected subscription isn't retried
+	// until the next session
+	c.subscribed[filter] = qos
+	return true
+}
+
+// filters returns the highest QoS requested for each filter. The caller must
+// hold the lock.
+func (c *Client) filters() map[string]byte {
+	filters := make(map[string]byte, len(c.handlers))
+	for _, sub := range c.handlers {
+		if qos, ok := filters[sub.filter]; !ok || sub.qos > qos {
+			filters[sub.filter] = sub.qos
+		}
+	}
+	return filters
+}
+
+// dispatch delivers a message to the Handlers whose filters match its topic.
+func (c *Client) dispatch(_ mqtt.Client, m mqtt.Message) {
+	msg := Message{Topic: m.Topic(), Payload: m.Payload()}
+
+	c.mux.Lock()
+	var handlers []Handler
+	for _, sub := range c.handlers {
+		if Match(sub.filter, msg.Topic) {
+			handlers = append(handlers, sub.handle)
+		}
+	}
+	c.mux.Unlock()
+
+	if len(handlers) == 0 {
+		log.Debugf("Ignoring MQTT message on topic %q, which has no subscribers.", msg.Topic)
+		return
+	}
+	for _, handle := range handlers {
+		handle(msg)
+	}
+}
+
+// ValidateFilter returns an error if the topic filter isn't valid: a "#"
+// wildcard may only be used as the last level, and a "+" wildcard must be an
+// entire level.
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return errors.New("the topic filter is empty")
+	}
+	if strings.ContainsRune(filter, 0) {
+		return errors.Errorf("the topic filter %q contains a null character", filter)
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return errors.Errorf("invalid topic filter %q: "+
+				"the # wildcard must be the last level", filter)
+		case level != "#" && strings.Contains(level, "#"),
+			level != "+" && strings.Contains(level, "+"):
+			return errors.Errorf("invalid topic filter %q: "+
+				"wildcards must be an entire level", filter)
+		}
+	}
+	return nil
+}
+
+// Match returns true if the topic matches the filter. Wildcards at the start
+// of the filter don't match topics starting with "$", such as "$SYS/...".
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		// shared subscriptions are "$share/{group}/{filter}"
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) < 3 {
+			return false
+		}
+		filter = parts[2]
+	}
+
+	levels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (levels[0] == "#" || levels[0] == "+") {
+		return false
+	}
+
+	for i, level := range levels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(levels) == len(topicLevels)
+}
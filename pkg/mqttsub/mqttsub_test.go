@@ -0,0 +1,405 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package mqttsub
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+// testBroker is a minimal in-process MQTT broker. It supports QoS 0 and 1,
+// granting QoS 1 to subscriptions that request QoS 2, and doesn't retain
+// messages or keep sessions.
+type testBroker struct {
+	listener net.Listener
+
+	mux       sync.Mutex
+	conns     map[net.Conn]map[string]byte // each connection's filters
+	requested map[string]byte              // the QoS last requested for each filter
+}
+
+// startTestBroker starts a testBroker on the given address, such as
+// "127.0.0.1:0" to use any free port.
+func startTestBroker(w *expect.TWrapper, addr string) *testBroker {
+	w.Helper()
+	b := &testBroker{
+		listener:  w.ShouldHaveResult(net.Listen("tcp", addr)).(net.Listener),
+		conns:     map[net.Conn]map[string]byte{},
+		requested: map[string]byte{},
+	}
+	go func() {
+		for {
+			conn, err := b.listener.Accept()
+			if err != nil {
+				return
+			}
+			b.mux.Lock()
+			b.conns[conn] = map[string]byte{}
+			b.mux.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+// Close stops the broker and drops its connections.
+func (b *testBroker) Close() {
+	b.listener.Close()
+	b.mux.Lock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mux.Unlock()
+}
+
+// requestedQoS returns the QoS last requested for each filter.
+func (b *testBroker) requestedQoS() map[string]byte {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	requested := make(map[string]byte, len(b.requested))
+	for filter, qos := range b.requested {
+		requested[filter] = qos
+	}
+	return requested
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer func() {
+		b.mux.Lock()
+		delete(b.conns, conn)
+		b.mux.Unlock()
+		conn.Close()
+	}()
+
+	var wmux sync.Mutex
+	reply := func(p packets.ControlPacket) {
+		wmux.Lock()
+		p.Write(conn)
+		wmux.Unlock()
+	}
+
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			reply(packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			b.mux.Lock()
+			for i, filter := range p.Topics {
+				granted := p.Qoss[i]
+				if granted > 1 {
+					granted = 1
+				}
+				b.conns[conn][filter] = granted
+				b.requested[filter] = p.Qoss[i]
+				ack.ReturnCodes = append(ack.ReturnCodes, granted)
+			}
+			b.mux.Unlock()
+			reply(ack)
+		case *packets.UnsubscribePacket:
+			b.mux.Lock()
+			for _, filter := range p.Topics {
+				delete(b.conns[conn], filter)
+			}
+			b.mux.Unlock()
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			reply(ack)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				reply(ack)
+			}
+			b.publish(p)
+		case *packets.PingreqPacket:
+			reply(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// publish sends the message to each connection with a matching filter.
+func (b *testBroker) publish(p *packets.PublishPacket) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for conn, filters := range b.conns {
+		matched := false
+		var qos byte
+		for filter, granted := range filters {
+			if Match(filter, p.TopicName) {
+				matched = true
+				if granted > qos {
+					qos = granted
+				}
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		out.TopicName = p.TopicName
+		out.Payload = p.Payload
+		out.Qos = qos
+		if qos > p.Qos {
+			out.Qos = p.Qos
+		}
+		out.MessageID = 1
+		out.Write(conn)
+	}
+}
+
+// testEndpoint returns the address of the broker to use for tests: the one in
+// the MQTT_TEST_BROKER environment variable, such as "localhost:1883" for a
+// local mosquitto, or a new in-process broker. The broker is nil if it's
+// external.
+func testEndpoint(w *expect.TWrapper) (string, *testBroker) {
+	w.Helper()
+	if endpoint := os.Getenv("MQTT_TEST_BROKER"); endpoint != "" {
+		return endpoint, nil
+	}
+	b := startTestBroker(w, "127.0.0.1:0")
+	return b.addr(), b
+}
+
+// newPublisher returns a connected MQTT client for publishing test messages.
+func newPublisher(w *expect.TWrapper, endpoint string) mqtt.Client {
+	w.Helper()
+	pub := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + endpoint))
+	token := pub.Connect()
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+	return pub
+}
+
+// publish publishes a message and waits for the broker to accept it.
+func publish(w *expect.TWrapper, pub mqtt.Client, topic string, qos byte, retained bool, payload string) {
+	w.Helper()
+	token := pub.Publish(topic, qos, retained, payload)
+	w.ShouldBeTrue(token.WaitTimeout(5 * time.Second))
+	w.ShouldSucceed(token.Error())
+}
+
+// session returns the number of times the client has connected.
+func session(c *Client) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.session
+}
+
+// waitForSubscriptions waits until the broker has acknowledged the client's
+// subscription to each filter, and no others, in a session after the given one.
+func waitForSubscriptions(w *expect.TWrapper, c *Client, after int, filters ...string) {
+	w.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mux.Lock()
+		done := c.session > after && len(c.subscribed) == len(filters)
+		for _, filter := range filters {
+			if _, ok := c.subscribed[filter]; !ok {
+				done = false
+			}
+		}
+		c.mux.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Errorf("timed out waiting for subscriptions to %v", filters)
+	w.FailNow()
+}
+
+// receive returns the next message on messages, or fails if one doesn't
+// arrive within a few seconds.
+func receive(w *expect.TWrapper, messages <-chan Message) Message {
+	w.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		w.Errorf("timed out waiting for a message")
+		w.FailNow()
+		return Message{}
+	}
+}
+
+// shouldNotReceive fails if a message arrives on messages shortly.
+func shouldNotReceive(w *expect.TWrapper, messages <-chan Message) {
+	w.Helper()
+	select {
+	case msg := <-messages:
+		w.Errorf("unexpected message on %q: %s", msg.Topic, msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	w := expect.WrapT(t)
+	for _, valid := range []string{
+		"rfid/controller/command",
+		"rfid/gw/+/config/request",
+		"rfid/#",
+		"#",
+		"+",
+		"+/+",
+		"$share/providers/rfid/+",
+	} {
+		w.As(valid).ShouldSucceed(ValidateFilter(valid))
+	}
+	for _, invalid := range []string{
+		"",
+		"rfid/#/request",
+		"rfid/gw#",
+		"rfid/gw+/config",
+		"rfid/\x00",
+	} {
+		w.As(invalid).ShouldFail(ValidateFilter(invalid))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	w := expect.WrapT(t)
+	for _, tc := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"rfid/gw/+/config/request", "rfid/gw/gw1/config/request", true},
+		{"rfid/gw/+/config/request", "rfid/gw/config/request", false},
+		{"rfid/gw/+/config/request", "rfid/gw/gw1/config/request/extra", false},
+		{"rfid/#", "rfid", true},
+		{"rfid/#", "rfid/gw/gw1", true},
+		{"rfid/+", "rfid", false},
+		{"rfid/command", "rfid/command", true},
+		{"rfid/command", "rfid/commands", false},
+		{"#", "rfid/command", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"$share/providers/rfid/+", "rfid/command", true},
+	} {
+		w.As(tc.filter+" "+tc.topic).ShouldBeEqual(Match(tc.filter, tc.topic), tc.match)
+	}
+}
+
+func TestDial(t *testing.T) {
+	w := expect.WrapT(t)
+	w.ShouldHaveError(Dial(Config{}))
+	w.ShouldHaveError(Dial(Config{Endpoint: "localhost:1883", Username: "user"}))
+	w.ShouldHaveError(Dial(Config{Endpoint: "localhost:1883", Password: "secret"}))
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	endpoint, broker := testEndpoint(w)
+	if broker != nil {
+		defer broker.Close()
+	}
+
+	c := w.ShouldHaveResult(Dial(Config{Endpoint: endpoint, TimeoutSecs: 5})).(*Client)
+	defer c.Close()
+	pub := newPublisher(w, endpoint)
+	defer pub.Disconnect(250)
+
+	_, err := c.Subscribe("rfid/#/request", 0, func(Message) {})
+	w.ShouldFail(err)
+	_, err = c.Subscribe("rfid/controller/command", 3, func(Message) {})
+	w.ShouldFail(err)
+
+	requests := make(chan Message, 10)
+	all := make(chan Message, 10)
+	alsoAll := make(chan Message, 10)
+	unsubRequests := w.ShouldHaveResult(c.Subscribe("rfid/gw/+/config/request", 1,
+		func(msg Message) { requests <- msg })).(func())
+	unsubAll := w.ShouldHaveResult(c.Subscribe("rfid/#", 0,
+		func(msg Message) { all <- msg })).(func())
+	unsubAlsoAll := w.ShouldHaveResult(c.Subscribe("rfid/#", 1,
+		func(msg Message) { alsoAll <- msg })).(func())
+	waitForSubscriptions(w, c, 0, "rfid/gw/+/config/request", "rfid/#")
+	if broker != nil {
+		// the highest QoS for a filter is used
+		w.ShouldBeEqual(broker.requestedQoS(), map[string]byte{
+			"rfid/gw/+/config/request": 1,
+			"rfid/#":                   1,
+		})
+	}
+
+	publish(w, pub, "rfid/gw/gw1/config/request", 1, false, "please")
+	w.ShouldBeEqual(receive(w, requests), Message{Topic: "rfid/gw/gw1/config/request", Payload: []byte("please")})
+	w.ShouldBeEqual(receive(w, all).Topic, "rfid/gw/gw1/config/request")
+	w.ShouldBeEqual(receive(w, alsoAll).Topic, "rfid/gw/gw1/config/request")
+
+	publish(w, pub, "rfid/controller/notification", 0, false, "hi")
+	w.ShouldBeEqual(receive(w, all).Payload, []byte("hi"))
+	w.ShouldBeEqual(receive(w, alsoAll).Payload, []byte("hi"))
+	shouldNotReceive(w, requests)
+
+	// the filter stays subscribed while any of its handlers remain
+	unsubAll()
+	unsubAll()
+	unsubRequests()
+	waitForSubscriptions(w, c, 0, "rfid/#")
+	publish(w, pub, "rfid/gw/gw2/config/request", 1, false, "again")
+	w.ShouldBeEqual(receive(w, alsoAll).Payload, []byte("again"))
+	shouldNotReceive(w, requests)
+	shouldNotReceive(w, all)
+
+	unsubAlsoAll()
+	waitForSubscriptions(w, c, 0)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	broker := startTestBroker(w, "127.0.0.1:0")
+	addr := broker.addr()
+
+	// subscriptions made before connecting are made once connected
+	broker.Close()
+	c := w.ShouldHaveResult(Dial(Config{Endpoint: addr, TimeoutSecs: 5})).(*Client)
+	defer c.Close()
+	w.ShouldBeFalse(c.Connected())
+	messages := make(chan Message, 10)
+	w.ShouldHaveResult(c.Subscribe("rfid/gw/+/config/request", 1,
+		func(msg Message) { messages <- msg }))
+
+	broker = startTestBroker(w, addr)
+	waitForSubscriptions(w, c, 0, "rfid/gw/+/config/request")
+	w.ShouldBeTrue(c.Connected())
+	pub := newPublisher(w, addr)
+	publish(w, pub, "rfid/gw/gw1/config/request", 1, false, "first")
+	w.ShouldBeEqual(receive(w, messages).Payload, []byte("first"))
+	pub.Disconnect(250)
+
+	// subscriptions are restored when the connection is
+	prev := session(c)
+	broker.Close()
+	broker = startTestBroker(w, addr)
+	defer broker.Close()
+	waitForSubscriptions(w, c, prev, "rfid/gw/+/config/request")
+	pub = newPublisher(w, addr)
+	defer pub.Disconnect(250)
+	publish(w, pub, "rfid/gw/gw1/config/request", 1, false, "second")
+	w.ShouldBeEqual(receive(w, messages).Payload, []byte("second"))
+}
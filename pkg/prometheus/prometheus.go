@@ -0,0 +1,338 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package prometheus keeps labeled counters, gauges, and histograms, and writes
+// them in Prometheus' text exposition format.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ContentType is the media type of the text format written by Registry.Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of histograms' buckets, in seconds,
+// suitable for durations ranging from milliseconds to minutes.
+var DefaultBuckets = []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Family is a set of metrics that share a name and type, distinguished by the
+// values of their labels.
+type Family interface {
+	// Name returns the family's name.
+	Name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds the Families exposed together, such as by a /metrics route.
+type Registry struct {
+	mux      sync.RWMutex
+	families map[string]Family
+}
+
+// DefaultRegistry is the Registry used by MustRegister.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]Family{}}
+}
+
+// Register adds the Family to the Registry. Family names must be unique.
+func (r *Registry) Register(f Family) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, exists := r.families[f.Name()]; exists {
+		return errors.Errorf("a metric named %q is already registered", f.Name())
+	}
+	r.families[f.Name()] = f
+	return nil
+}
+
+// MustRegister adds the Families to the DefaultRegistry, and panics if any of
+// them can't be registered.
+func MustRegister(families ...Family) {
+	for _, f := range families {
+		if err := DefaultRegistry.Register(f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Write writes every metric in the Registry to w in the text format, sorted by
+// name and labels.
+func (r *Registry) Write(w io.Writer) error {
+	r.mux.RLock()
+	families := make([]Family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mux.RUnlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name() < families[j].Name()
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc describes a Family and tracks its series by their label values.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mux    sync.Mutex
+	series map[string]*series
+}
+
+// series is a single metric within a Family.
+type series struct {
+	values []string // of the Family's labels
+	value  float64  // of a counter or gauge
+	// histograms' cumulative bucket counts, sum, and count
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newDesc(name, help, kind string, labels []string) desc {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validName.MatchString(label) || strings.HasPrefix(label, "__") {
+			panic(fmt.Sprintf("invalid label name %q for metric %q", label, name))
+		}
+	}
+	return desc{name: name, help: help, kind: kind, labels: labels, series: map[string]*series{}}
+}
+
+// Name returns the Family's name.
+func (d *desc) Name() string {
+	return d.name
+}
+
+// with calls update with the series that has the given label values, creating
+// it if necessary, while holding the Family's lock. It panics if the number of
+// values doesn't match the Family's labels.
+func (d *desc) with(values []string, update func(s *series)) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %q has %d labels, but got %d values",
+			d.name, len(d.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	s, ok := d.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		d.series[key] = s
+	}
+	update(s)
+}
+
+// Delete removes the series with the given label values, such as when the
+// thing it measures no longer exists.
+func (d *desc) Delete(values ...string) {
+	d.mux.Lock()
+	delete(d.series, strings.Join(values, "\xff"))
+	d.mux.Unlock()
+}
+
+// snapshot returns copies of the Family's series, sorted by their labels.
+func (d *desc) snapshot() []series {
+	d.mux.Lock()
+	keys := make([]string, 0, len(d.series))
+	for key := range d.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snap := make([]series, len(keys))
+	for i, key := range keys {
+		snap[i] = *d.series[key]
+		snap[i].counts = append([]uint64(nil), snap[i].counts...)
+	}
+	d.mux.Unlock()
+	return snap
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escape(d.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// writeSample writes a line with the sample's name, the series' labels along
+// with any extra label, and its value.
+func (d *desc) writeSample(w *bufio.Writer, name string, s series, extra string, value float64) {
+	w.WriteString(name)
+	if len(d.labels) != 0 || extra != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escape(s.values[i], true))
+		}
+		if extra != "" {
+			if len(d.labels) != 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// CounterVec is a Family of counters, whose values only increase.
+type CounterVec struct {
+	desc
+}
+
+// NewCounterVec returns a Family of counters with the given labels. By
+// convention, counters' names end with "_total".
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{desc: newDesc(name, help, "counter", labels)}
+}
+
+// Add increases the counter with the given label values by v, which must not
+// be negative.
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %q can't decrease", c.name))
+	}
+	c.with(values, func(s *series) { s.value += v })
+}
+
+// Inc increases the counter with the given label values by 1.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	for _, s := range c.snapshot() {
+		c.writeSample(w, c.name, s, "", s.value)
+	}
+}
+
+// GaugeVec is a Family of gauges, whose values may go up and down.
+type GaugeVec struct {
+	desc
+}
+
+// NewGaugeVec returns a Family of gauges with the given labels.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{desc: newDesc(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge with the given label values to v.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.with(values, func(s *series) { s.value = v })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	for _, s := range g.snapshot() {
+		g.writeSample(w, g.name, s, "", s.value)
+	}
+}
+
+// HistogramVec is a Family of histograms, which count observations, such as
+// durations, in buckets.
+type HistogramVec struct {
+	desc
+	buckets []float64
+}
+
+// NewHistogramVec returns a Family of histograms with the given labels, whose
+// buckets have the given upper bounds. If buckets is empty, it uses the
+// DefaultBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	for _, label := range labels {
+		if label == "le" {
+			panic(fmt.Sprintf("histogram %q can't have an \"le\" label", name))
+		}
+	}
+	return &HistogramVec{desc: newDesc(name, help, "histogram", labels), buckets: buckets}
+}
+
+// Observe adds v to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.with(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.buckets))
+		}
+		for i, upper := range h.buckets {
+			if v <= upper {
+				s.counts[i]++
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	for _, s := range h.snapshot() {
+		for i, upper := range h.buckets {
+			le := `le="` + formatFloat(upper) + `"`
+			h.writeSample(w, h.name+"_bucket", s, le, float64(s.counts[i]))
+		}
+		h.writeSample(w, h.name+"_bucket", s, `le="+Inf"`, float64(s.count))
+		h.writeSample(w, h.name+"_sum", s, "", s.sum)
+		h.writeSample(w, h.name+"_count", s, "", float64(s.count))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes backslashes and newlines, as well as double quotes in label
+// values, as the text format requires.
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package prometheus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	r := NewRegistry()
+
+	runs := NewCounterVec("runs_total", "Completed runs.", "pipeline")
+	w.ShouldSucceed(r.Register(runs))
+	w.ShouldFail(r.Register(NewCounterVec("runs_total", "Again.")))
+	last := NewGaugeVec("last_success_seconds", "When it last\nsucceeded.", "pipeline")
+	w.ShouldSucceed(r.Register(last))
+	duration := NewHistogramVec("duration_seconds", "How long runs take.", []float64{1, 0.5}, "pipeline")
+	w.ShouldSucceed(r.Register(duration))
+	up := NewGaugeVec("up", "Whether it's up.")
+	w.ShouldSucceed(r.Register(up))
+
+	runs.Inc("SKU")
+	runs.Add(2, "SKU")
+	runs.Inc(`AS"N`)
+	last.Set(1500000000, "SKU")
+	duration.Observe(0.25, "SKU")
+	duration.Observe(0.75, "SKU")
+	duration.Observe(3, "SKU")
+	up.Set(1)
+
+	var buf bytes.Buffer
+	w.ShouldSucceed(r.Write(&buf))
+	w.ShouldBeEqual(buf.String(), `# HELP duration_seconds How long runs take.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{pipeline="SKU",le="0.5"} 1
+duration_seconds_bucket{pipeline="SKU",le="1"} 2
+duration_seconds_bucket{pipeline="SKU",le="+Inf"} 3
+duration_seconds_sum{pipeline="SKU"} 4
+duration_seconds_count{pipeline="SKU"} 3
+# HELP last_success_seconds When it last\nsucceeded.
+# TYPE last_success_seconds gauge
+last_success_seconds{pipeline="SKU"} 1.5e+09
+# HELP runs_total Completed runs.
+# TYPE runs_total counter
+runs_total{pipeline="AS\"N"} 1
+runs_total{pipeline="SKU"} 3
+# HELP up Whether it's up.
+# TYPE up gauge
+up 1
+`)
+
+	runs.Delete(`AS"N`)
+	buf.Reset()
+	w.ShouldSucceed(r.Write(&buf))
+	w.ShouldBeFalse(strings.Contains(buf.String(), "AS"))
+}
+
+func TestVec_Invalid(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	panics := func(f func()) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		f()
+		return false
+	}
+
+	w.ShouldBeTrue(panics(func() { NewCounterVec("bad-name", "") }))
+	w.ShouldBeTrue(panics(func() { NewGaugeVec("ok", "", "bad label") }))
+	w.ShouldBeTrue(panics(func() { NewHistogramVec("ok", "", nil, "le") }))
+	w.ShouldBeTrue(panics(func() { NewCounterVec("ok", "", "pipeline").Inc() }))
+	w.ShouldBeTrue(panics(func() { NewCounterVec("ok", "", "pipeline").Add(-1, "SKU") }))
+	w.ShouldBeFalse(panics(func() { NewCounterVec("ok", "", "pipeline").Inc("SKU") }))
+}
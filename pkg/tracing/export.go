@@ -0,0 +1,203 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// scopeName identifies this package as the source of the spans it exports.
+const scopeName = "github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/tracing"
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP's JSON
+// encoding over HTTP.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter returns an Exporter that posts spans from the named service
+// to the endpoint, which is usually a collector's /v1/traces path.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export posts the spans to the collector.
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	body, err := encode(e.service, spans)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "unable to export spans")
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("non-2xx status from %s: %d; body: %s",
+			e.endpoint, resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// FileExporter appends spans to a file, one OTLP JSON request per line, so
+// they can be viewed or sent to a collector later.
+type FileExporter struct {
+	service string
+
+	mux  sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter returns an Exporter that appends spans from the named
+// service to the file at path, creating it if necessary.
+func NewFileExporter(path, service string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open trace file %q", path)
+	}
+	return &FileExporter{service: service, file: f}, nil
+}
+
+// Export appends the spans to the file.
+func (e *FileExporter) Export(spans []SpanData) error {
+	line, err := encode(e.service, spans)
+	if err != nil {
+		return err
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	_, err = e.file.Write(append(line, '\n'))
+	return errors.Wrapf(err, "unable to write spans to %q", e.file.Name())
+}
+
+// Close closes the file.
+func (e *FileExporter) Close() error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.file.Close()
+}
+
+// These types match the JSON encoding of OTLP's ExportTraceServiceRequest.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    StatusCode `json:"code"`
+		Message string     `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    string   `json:"intValue,omitempty"` // 64-bit ints are strings
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// encode returns the spans as an OTLP JSON request from the named service.
+func encode(service string, spans []SpanData) ([]byte, error) {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+			Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			encoded[i].ParentSpanID = s.Parent.String()
+		}
+	}
+
+	data, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: attributes(map[string]interface{}{
+			"service.name": service,
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: encoded,
+		}},
+	}}})
+	return data, errors.Wrap(err, "unable to encode spans")
+}
+
+// attributes returns the attributes sorted by key.
+func attributes(attrs map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for key, value := range attrs {
+		kv := otlpKeyValue{Key: key}
+		switch v := value.(type) {
+		case bool:
+			kv.Value.BoolValue = &v
+		case int64:
+			kv.Value.IntValue = strconv.FormatInt(v, 10)
+		case float64:
+			kv.Value.DoubleValue = &v
+		case string:
+			kv.Value.StringValue = &v
+		default:
+			continue // SetAttribute only stores the types above
+		}
+		kvs = append(kvs, kv)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs
+}
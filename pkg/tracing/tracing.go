@@ -0,0 +1,380 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package tracing records spans of work, such as pipeline executions and the
+// tasks within them, and exports them as OpenTelemetry traces. Trace context
+// is propagated to other services using the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// TraceparentHeader is the HTTP header that carries a SpanContext.
+const TraceparentHeader = "traceparent"
+
+const (
+	// batchSize is how many ended spans a Tracer collects before exporting them.
+	batchSize = 512
+	// batchInterval is how long a Tracer waits before exporting a partial batch.
+	batchInterval = 5 * time.Second
+	// queueSize is how many ended spans may wait for export; more are dropped.
+	queueSize = 4 * batchSize
+)
+
+// TraceID identifies a trace: the spans of a single pipeline execution.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid returns true unless the TraceID is all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String returns the TraceID in lowercase hex.
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid returns true unless the SpanID is all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String returns the SpanID in lowercase hex.
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span, and is what's propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns true if both the TraceID and the SpanID are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns the SpanContext as the value of a traceparent header.
+// Every span this package records is sampled.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceparent parses the value of a traceparent header.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) || len(parts[3]) != 2 {
+		return sc, errors.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || len(parts[1]) != 32 {
+		return sc, errors.Errorf("invalid trace ID in traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || len(parts[2]) != 16 {
+		return sc, errors.Errorf("invalid span ID in traceparent %q", s)
+	}
+	if _, err := hex.DecodeString(parts[3]); err != nil || !sc.IsValid() {
+		return sc, errors.Errorf("invalid traceparent %q", s)
+	}
+	return sc, nil
+}
+
+// Kind describes a span's relationship to other services. Its values match
+// those of OTLP.
+type Kind int
+
+// Kinds of spans.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// StatusCode is the outcome of a span's work. Its values match those of OTLP.
+type StatusCode int
+
+// Span status codes.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// SpanData is what's exported for an ended span.
+type SpanData struct {
+	Name        string
+	Kind        Kind
+	SpanContext SpanContext
+	// Parent is the ID of the span's parent, which is invalid if it's the
+	// root of its trace.
+	Parent    SpanID
+	StartTime time.Time
+	EndTime   time.Time
+	// Attributes' values are strings, bools, ints, int64s, or float64s.
+	Attributes    map[string]interface{}
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span records a unit of work within a trace. A nil *Span, which is what's
+// started when tracing is disabled, ignores everything.
+type Span struct {
+	tracer *Tracer
+
+	mux   sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's SpanContext.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a value describing the span's work, replacing any
+// previous value with the same key.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string, bool, int64, float64:
+	case int:
+		value = int64(v)
+	case fmt.Stringer:
+		value = v.String()
+	default:
+		value = fmt.Sprint(v)
+	}
+	s.mux.Lock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+	s.mux.Unlock()
+}
+
+// SetStatus records the span's outcome.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	if !s.ended {
+		s.data.Status = code
+		s.data.StatusMessage = message
+	}
+	s.mux.Unlock()
+}
+
+// SetError records the outcome of the span's work: an error status if err
+// isn't nil, or otherwise OK.
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	} else {
+		s.SetStatus(StatusOK, "")
+	}
+}
+
+// End records the time the span's work completed and queues it for export.
+// Only the first call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now().UTC()
+	data := s.data
+	s.mux.Unlock()
+	s.tracer.enqueue(data)
+}
+
+type spanCtxKey int
+
+const spanKey spanCtxKey = 0
+
+// SpanFromContext returns the span in the context, or nil if there isn't one.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// Exporter sends ended spans somewhere they can be viewed.
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// Tracer starts spans, and exports them in batches once they've ended.
+type Tracer struct {
+	dropped  int64 // first, so it's aligned for atomic access
+	exporter Exporter
+	queue    chan SpanData
+	done     chan struct{}
+
+	closeOnce sync.Once
+	mux       sync.RWMutex // guards sending to the queue against closing it
+	closed    bool
+}
+
+// NewTracer returns a Tracer that exports spans with the given Exporter. It
+// must be closed to export the spans that haven't yet been exported.
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span with the given name, whose parent is the span in the
+// context, if there is one. It returns a context that includes the new span.
+// If the Tracer is nil, it returns the context and a nil Span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, data: SpanData{
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now().UTC(),
+		Attributes: map[string]interface{}{},
+	}}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.data.SpanContext.TraceID = parent.data.SpanContext.TraceID
+		s.data.Parent = parent.data.SpanContext.SpanID
+	} else {
+		randomID(s.data.SpanContext.TraceID[:])
+	}
+	randomID(s.data.SpanContext.SpanID[:])
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// randomID fills id with random bytes, ensuring they're not all zeros.
+func randomID(id []byte) {
+	for {
+		if _, err := rand.Read(id); err != nil {
+			panic(errors.Wrap(err, "unable to generate a random ID"))
+		}
+		for _, b := range id {
+			if b != 0 {
+				return
+			}
+		}
+	}
+}
+
+// enqueue adds an ended span to the next batch, unless the queue is full or
+// the Tracer is closed.
+func (t *Tracer) enqueue(data SpanData) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		if atomic.AddInt64(&t.dropped, 1) == 1 {
+			log.Warn("Dropping trace spans because they can't be exported quickly enough.")
+		}
+	}
+}
+
+// Dropped returns the number of ended spans that were discarded because too
+// many were waiting to be exported.
+func (t *Tracer) Dropped() int64 {
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// run exports batches of spans from the queue until it's closed.
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			log.WithError(err).WithField("spans", len(batch)).Warn("Failed to export trace spans.")
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// Close exports the spans that have ended, then closes the Exporter, if it's
+// an io.Closer. Spans that end afterwards are discarded.
+func (t *Tracer) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		t.mux.Lock()
+		t.closed = true
+		close(t.queue)
+		t.mux.Unlock()
+		<-t.done
+
+		if closer, ok := t.exporter.(interface{ Close() error }); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
+
+var defaultTracer atomic.Value // of *Tracer
+
+// SetDefault sets the Tracer used by Start. If it's nil, tracing is disabled.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the Tracer used by Start, which is nil if tracing is
+// disabled.
+func Default() *Tracer {
+	t, _ := defaultTracer.Load().(*Tracer)
+	return t
+}
+
+// Start starts a span with the default Tracer.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	return Default().Start(ctx, name, kind)
+}
@@ -0,0 +1,204 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-expect"
+	"github.com/pkg/errors"
+)
+
+// memoryExporter keeps the spans it exports.
+type memoryExporter struct {
+	mux   sync.Mutex
+	spans []SpanData
+}
+
+func (me *memoryExporter) Export(spans []SpanData) error {
+	me.mux.Lock()
+	me.spans = append(me.spans, spans...)
+	me.mux.Unlock()
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc := w.ShouldHaveResult(ParseTraceparent(tp)).(SpanContext)
+	w.ShouldBeEqual(sc.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	w.ShouldBeEqual(sc.SpanID.String(), "00f067aa0ba902b7")
+	w.ShouldBeEqual(sc.Traceparent(), tp)
+
+	// later versions may add fields
+	w.ShouldSucceed(ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-more"))
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-more",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		w.As(invalid).ShouldFail(ParseTraceparent(invalid))
+	}
+}
+
+func TestTracer(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "SKU", KindInternal)
+	w.ShouldBeEqual(SpanFromContext(ctx), root)
+	_, child := tracer.Start(ctx, "send", KindClient)
+	child.SetAttribute("http.method", "POST")
+	child.SetAttribute("task.output_bytes", 42)
+	child.SetError(errors.New("non-2xx status"))
+	child.End()
+	root.SetError(nil)
+	root.End()
+	root.SetAttribute("too", "late")
+	root.End()
+	_, other := tracer.Start(context.Background(), "ASN", KindInternal)
+	other.End()
+
+	w.ShouldSucceed(tracer.Close())
+	w.ShouldSucceed(tracer.Close())
+	_, closed := tracer.Start(context.Background(), "closed", KindInternal)
+	closed.End()
+
+	w.ShouldBeEqual(len(exporter.spans), 3)
+	sent, sku, asn := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+	w.ShouldBeEqual(sent.Name, "send")
+	w.ShouldBeEqual(sent.Kind, KindClient)
+	w.ShouldBeEqual(sent.SpanContext.TraceID, sku.SpanContext.TraceID)
+	w.ShouldBeEqual(sent.Parent, sku.SpanContext.SpanID)
+	w.ShouldBeEqual(sent.Attributes, map[string]interface{}{
+		"http.method":       "POST",
+		"task.output_bytes": int64(42),
+	})
+	w.ShouldBeEqual(sent.Status, StatusError)
+	w.ShouldBeEqual(sent.StatusMessage, "non-2xx status")
+	w.ShouldBeFalse(sent.EndTime.Before(sent.StartTime))
+
+	w.ShouldBeFalse(sku.Parent.IsValid())
+	w.ShouldBeEqual(sku.Status, StatusOK)
+	w.ShouldBeEqual(len(sku.Attributes), 0)
+	w.ShouldBeFalse(asn.SpanContext.TraceID == sku.SpanContext.TraceID)
+
+	// without a Tracer, spans are nil and ignore everything
+	var disabled *Tracer
+	ctx, span := disabled.Start(context.Background(), "SKU", KindInternal)
+	w.ShouldBeNil(span)
+	w.ShouldBeNil(SpanFromContext(ctx))
+	span.SetAttribute("ignored", true)
+	span.SetError(nil)
+	span.End()
+	w.ShouldBeFalse(span.Context().IsValid())
+}
+
+func TestFileExporter(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	dir := w.ShouldHaveResult(ioutil.TempDir("", "tracing")).(string)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+
+	for _, name := range []string{"SKU", "ASN"} {
+		exporter := w.ShouldHaveResult(NewFileExporter(path, "Data Provider")).(*FileExporter)
+		tracer := NewTracer(exporter)
+		_, span := tracer.Start(context.Background(), name, KindInternal)
+		span.SetAttribute("pipeline.name", name)
+		span.End()
+		w.ShouldSucceed(tracer.Close())
+	}
+
+	f := w.ShouldHaveResult(os.Open(path)).(*os.File)
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req otlpRequest
+		w.ShouldSucceed(json.Unmarshal(scanner.Bytes(), &req))
+		w.ShouldBeEqual(*req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue, "Data Provider")
+		span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		w.ShouldBeEqual(span.Kind, KindInternal)
+		w.ShouldBeEqual(len(span.TraceID), 32)
+		w.ShouldBeEmptyStr(span.ParentSpanID)
+		names = append(names, *span.Attributes[0].Value.StringValue)
+	}
+	w.ShouldSucceed(scanner.Err())
+	w.ShouldBeEqual(names, []string{"SKU", "ASN"})
+}
+
+func TestOTLPExporter(t *testing.T) {
+	w := expect.WrapT(t).StopOnMismatch()
+	// spans are decoded generically to check how they're encoded
+	var received []map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.ShouldBeEqual(r.URL.Path, "/v1/traces")
+		w.ShouldBeEqual(r.Header.Get("Content-Type"), "application/json")
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		w.ShouldSucceed(json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body.ResourceSpans[0].ScopeSpans[0].Spans...)
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/v1/traces", "Data Provider")
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	w.ShouldSucceed(exporter.Export([]SpanData{{
+		Name:        "send",
+		Kind:        KindClient,
+		SpanContext: sc,
+		Parent:      SpanID{3},
+		StartTime:   time.Unix(1500000000, 0),
+		EndTime:     time.Unix(1500000001, 5),
+		Attributes:  map[string]interface{}{"ok": true, "size": int64(5), "ratio": 0.5},
+		Status:      StatusError,
+	}}))
+	w.ShouldBeEqual(len(received), 1)
+	w.ShouldBeEqual(received[0], map[string]interface{}{
+		"traceId":           "01000000000000000000000000000000",
+		"spanId":            "0200000000000000",
+		"parentSpanId":      "0300000000000000",
+		"name":              "send",
+		"kind":              3.0,
+		"startTimeUnixNano": "1500000000000000000",
+		"endTimeUnixNano":   "1500000001000000005",
+		"attributes": []interface{}{
+			map[string]interface{}{"key": "ok", "value": map[string]interface{}{"boolValue": true}},
+			map[string]interface{}{"key": "ratio", "value": map[string]interface{}{"doubleValue": 0.5}},
+			map[string]interface{}{"key": "size", "value": map[string]interface{}{"intValue": "5"}},
+		},
+		"status": map[string]interface{}{"code": 2.0},
+	})
+
+	status = http.StatusServiceUnavailable
+	w.ShouldFail(exporter.Export([]SpanData{{Name: "send"}}))
+}
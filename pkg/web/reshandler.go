@@ -39,6 +39,14 @@ var (
 
 	// ErrInvalidInput occurs when the input data is invalid
 	ErrInvalidInput = errors.New("Invalid input data")
+
+	// ErrConflict occurs when the request conflicts with the current state
+	// of the target resource.
+	ErrConflict = errors.New("Conflicts with current state")
+
+	// ErrUnavailable occurs when the service can't handle the request right
+	// now, such as while it's shutting down.
+	ErrUnavailable = errors.New("Service unavailable")
 )
 
 // Error handles all error responses for the API.
@@ -65,6 +73,14 @@ func Error(ctx context.Context, writer http.ResponseWriter, err error) {
 	case ErrInvalidInput:
 		RespondError(ctx, writer, err, http.StatusBadRequest)
 		return
+
+	case ErrConflict:
+		RespondError(ctx, writer, err, http.StatusConflict)
+		return
+
+	case ErrUnavailable:
+		RespondError(ctx, writer, err, http.StatusServiceUnavailable)
+		return
 	}
 
 	// Handler server error
@@ -0,0 +1,423 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-goplumber"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/config"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/store"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/filewatch"
+)
+
+// pipelineLoader builds the service's pipelines from its configuration, and
+// rebuilds them when the configuration, pipelines, or templates change.
+type pipelineLoader struct {
+	registry *scheduler.Registry
+	kvData   store.Store
+
+	mux  sync.Mutex // held while loading
+	conf config.ServiceConfig
+	// MQTT clients are kept across reloads, since goplumber doesn't provide a
+	// way to disconnect the ones that are replaced.
+	mqttClients map[string]mqttClient
+	subscribers *mqttSubscribers
+}
+
+type mqttClient struct {
+	data   []byte
+	client *goplumber.MQTTClient
+}
+
+func newPipelineLoader(conf config.ServiceConfig, registry *scheduler.Registry, kvData store.Store) *pipelineLoader {
+	return &pipelineLoader{
+		registry:    registry,
+		kvData:      kvData,
+		conf:        conf,
+		mqttClients: map[string]mqttClient{},
+		subscribers: newMQTTSubscribers(),
+	}
+}
+
+// loadInitial loads the pipelines when the service starts. Unlike a reload,
+// it fails if any pipeline can't be loaded.
+func (pl *pipelineLoader) loadInitial() error {
+	pl.mux.Lock()
+	defer pl.mux.Unlock()
+
+	defs, failed, err := pl.load(pl.conf)
+	if err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		sources := make([]string, 0, len(failed))
+		for source := range failed {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		return failed[sources[0]]
+	}
+
+	reload := pl.registry.Update(defs, nil)
+	if len(reload.Failed) != 0 {
+		return errors.Errorf("failed to register pipelines: %v", reload.Failed)
+	}
+	return nil
+}
+
+// reload reads the configuration and rebuilds the pipelines, replacing those
+// in the registry only if every one of them loads. Otherwise, the pipelines
+// keep their previous definitions, and the previous configuration stays in
+// effect. It's used for reloads requested via the API or SIGHUP.
+func (pl *pipelineLoader) reload() scheduler.Reload {
+	return pl.reloadWith(pl.registry.Replace)
+}
+
+// reloadChanges is like reload, except that a pipeline that can't be loaded
+// keeps its previous definition while the others are replaced, so that a
+// pipeline file that's being edited doesn't hold back changes to the others.
+// It's used when the watched files change.
+func (pl *pipelineLoader) reloadChanges() scheduler.Reload {
+	return pl.reloadWith(pl.registry.Update)
+}
+
+// reloadWith reads the configuration, rebuilds the pipelines, and passes them
+// to update. If the configuration can't be loaded, the pipelines are left
+// alone, and unless update fails, the configuration is applied.
+func (pl *pipelineLoader) reloadWith(update func([]scheduler.Definition, map[string]error) scheduler.Reload) scheduler.Reload {
+	pl.mux.Lock()
+	defer pl.mux.Unlock()
+
+	log.Info("Reloading pipelines.")
+	conf, err := config.Load()
+	if err != nil {
+		return pl.registry.ReloadFailed(err)
+	}
+
+	defs, failed, err := pl.load(conf)
+	if err != nil {
+		return pl.registry.ReloadFailed(err)
+	}
+	reload := update(defs, failed)
+	if reload.Error != "" {
+		return reload
+	}
+
+	for _, name := range restartRequired(pl.conf, conf) {
+		log.Warnf("The configuration's %s changed, but the change won't take "+
+			"effect until the service restarts.", name)
+	}
+	if conf.LoggingLevel != pl.conf.LoggingLevel {
+		setLogLevel(conf.LoggingLevel)
+	}
+	pl.conf = conf
+	// AppConfig is only read while the service starts, but it's kept current
+	// so it matches the pipelines; settings that require a restart still
+	// don't take effect until then
+	config.AppConfig = conf
+	return reload
+}
+
+// restartRequired returns the names of the settings that differ between the
+// configurations but are only used when the service starts.
+func restartRequired(old, new config.ServiceConfig) []string {
+	var changed []string
+	for _, setting := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"serviceName", old.ServiceName, new.ServiceName},
+		{"logFormat", old.LogFormat, new.LogFormat},
+		{"logFile", old.LogFile, new.LogFile},
+		{"logMaxSizeMB", old.LogMaxSizeMB, new.LogMaxSizeMB},
+		{"logMaxAge", old.LogMaxAge, new.LogMaxAge},
+		{"logMaxBackups", old.LogMaxBackups, new.LogMaxBackups},
+		{"logCompress", old.LogCompress, new.LogCompress},
+		{"telemetryEndpoint", old.TelemetryEndpoint, new.TelemetryEndpoint},
+		{"telemetryDataStoreName", old.TelemetryDataStoreName, new.TelemetryDataStoreName},
+		{"telemetryUsername", old.TelemetryUsername, new.TelemetryUsername},
+		{"telemetryPassword", old.TelemetryPassword, new.TelemetryPassword},
+		{"telemetryInterval", old.TelemetryInterval, new.TelemetryInterval},
+		{"port", old.Port, new.Port},
+		{"dataDir", old.DataDir, new.DataDir},
+		{"kvStore", old.KVStore, new.KVStore},
+		{"consulURL", old.ConsulURL, new.ConsulURL},
+		{"consulKVPrefix", old.ConsulKVPrefix, new.ConsulKVPrefix},
+		{"tlsCertFile", old.TLSCertFile, new.TLSCertFile},
+		{"tlsKeyFile", old.TLSKeyFile, new.TLSKeyFile},
+		{"tlsClientCAFile", old.TLSClientCAFile, new.TLSClientCAFile},
+		{"reloadPollInterval", old.ReloadPollInterval, new.ReloadPollInterval},
+		{"maxConcurrentPipelines", old.MaxConcurrentPipelines, new.MaxConcurrentPipelines},
+		{"pipelineLogSize", old.PipelineLogSize, new.PipelineLogSize},
+		{"readinessStaleAfter", old.ReadinessStaleAfter, new.ReadinessStaleAfter},
+		{"tracingExporter", old.TracingExporter, new.TracingExporter},
+		{"tracingEndpoint", old.TracingEndpoint, new.TracingEndpoint},
+		{"tracingFile", old.TracingFile, new.TracingFile},
+		{"runHistorySize", old.RunHistorySize, new.RunHistorySize},
+		{"runHistoryMaxAge", old.RunHistoryMaxAge, new.RunHistoryMaxAge},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// load builds a Plumber from the configuration and uses it to load the
+// configured pipelines.
+//
+// It returns an error if anything shared by the pipelines, such as a custom
+// task type, fails to load. Otherwise, it returns the pipelines that loaded
+// successfully, and maps the file name of each that didn't to the reason why.
+func (pl *pipelineLoader) load(conf config.ServiceConfig) ([]scheduler.Definition, map[string]error, error) {
+	log.Debug("Loading pipelines.")
+	plumber := goplumber.NewPlumber()
+
+	// load pipelines and templates from the filesystem
+	loader := goplumber.NewFileSystem(conf.TemplatesDir)
+	plumber.SetTemplateSource("template", loader)
+
+	// add a task for getting Docker secrets
+	secrets := goplumber.NewFileSystem(conf.SecretsPath)
+	plumber.SetSource("secret", secrets)
+
+	plumber.SetSource("get", pl.kvData)
+	plumber.SetSink("put", pl.kvData)
+
+	// add uuid generator
+	plumber.SetClient("uuid", goplumber.PipeFunc(
+		func(task *goplumber.Task) (goplumber.Pipe, error) { return uuidGen{}, nil }))
+
+	// add a task for the MQTT message that triggered a pipeline
+	plumber.SetClient("message", scheduler.MessageClient())
+
+	// add a task for the webhook request that triggered a pipeline
+	plumber.SetClient("webhook", scheduler.WebhookClient())
+
+	// add a task for the drop folder file that triggered a pipeline
+	plumber.SetClient("file", scheduler.FileClient())
+
+	log.Debug("Loading MQTT clients (if any).")
+	pipedata := goplumber.NewFileSystem(conf.PipelinesDir)
+	for _, fn := range conf.MQTTClients {
+		name := fn
+		if !strings.HasSuffix(fn, ".json") {
+			fn += ".json"
+		}
+		mqttConfData, err := pipedata.GetFile(fn)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to load mqtt config %q", fn)
+		}
+
+		if existing, ok := pl.mqttClients[name]; ok {
+			if !bytes.Equal(existing.data, mqttConfData) {
+				log.Warnf("MQTT client config %q changed, but the change won't "+
+					"take effect until the service restarts.", fn)
+			}
+			plumber.SetSink(name, existing.client)
+			continue
+		}
+
+		mc := &goplumber.MQTTClient{}
+		if err := json.Unmarshal(mqttConfData, mc); err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to unmarshal mqtt config for %q", fn)
+		}
+		pl.mqttClients[name] = mqttClient{data: mqttConfData, client: mc}
+		pl.subscribers.configure(name, mqttConfData)
+		plumber.SetSink(name, mc)
+	}
+
+	// record per-task results for every task type
+	scheduler.Instrument(plumber)
+
+	log.Debug("Loading custom task types from pipelines.")
+	for _, name := range conf.CustomTaskTypes {
+		data, err := pipedata.GetFile(name)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to load pipeline from file %q", name)
+		}
+
+		var pipelineConf goplumber.PipelineConfig
+		if err := json.Unmarshal(data, &pipelineConf); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to unmarshal pipeline config from %q", name)
+		}
+
+		taskType, err := plumber.NewPipeline(&pipelineConf)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to load pipeline %q", name)
+		}
+		client, err := goplumber.NewTaskType(taskType)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to create client for %q", name)
+		}
+		plumber.SetClient(pipelineConf.Name, client)
+	}
+
+	scheduler.Instrument(plumber)
+
+	// only load the configured names
+	var defs []scheduler.Definition
+	failed := map[string]error{}
+	mqttNames := make(map[string]bool, len(conf.MQTTClients))
+	for _, name := range conf.MQTTClients {
+		mqttNames[name] = true
+	}
+	for _, name := range conf.PipelineNames {
+		def, err := loadPipeline(plumber, pipedata, secrets, mqttNames, name)
+		if err != nil {
+			failed[name] = err
+			continue
+		}
+		defs = append(defs, def)
+	}
+	return defs, failed, nil
+}
+
+// loadPipeline loads the named pipeline file. If it subscribes to an MQTT topic,
+// it must use one of the named MQTT clients, and if its webhook has a secret,
+// it's loaded from the secrets.
+func loadPipeline(plumber goplumber.Plumber, pipedata, secrets goplumber.FileSystem, mqttNames map[string]bool, name string) (scheduler.Definition, error) {
+	data, err := pipedata.GetFile(name)
+	if err != nil {
+		return scheduler.Definition{}, errors.Wrapf(err, "failed to load pipeline %q", name)
+	}
+
+	var pipelineConf goplumber.PipelineConfig
+	if err := json.Unmarshal(data, &pipelineConf); err != nil {
+		return scheduler.Definition{}, errors.Wrapf(err, "failed to unmarshal pipeline config from %q", name)
+	}
+
+	p, err := plumber.NewPipeline(&pipelineConf)
+	if err != nil {
+		return scheduler.Definition{}, errors.Wrapf(err, "failed to load pipeline %s", name)
+	}
+
+	schedule, err := scheduler.ParseTrigger(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+
+	policy, err := scheduler.ParseFailurePolicy(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+
+	after, err := scheduler.ParseDependencies(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+
+	subscription, err := scheduler.ParseSubscription(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+	if subscription != nil && !mqttNames[subscription.Client] {
+		return scheduler.Definition{}, errors.Errorf("failed to load pipeline %s: "+
+			"its trigger subscribes with mqtt client %q, which isn't one of the mqttClients",
+			name, subscription.Client)
+	}
+
+	webhook, err := scheduler.ParseWebhook(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+	if webhook != nil && webhook.Secret != "" {
+		key, err := secrets.GetFile(webhook.Secret)
+		if err != nil {
+			return scheduler.Definition{}, errors.Wrapf(err, "failed to load pipeline %s: "+
+				"unable to load its webhook secret %q", name, webhook.Secret)
+		}
+		// secrets written by hand usually end with a newline
+		webhook.Key = bytes.TrimSpace(key)
+	}
+
+	dropFolder, err := scheduler.ParseDropFolder(data)
+	if err != nil {
+		return scheduler.Definition{}, errors.WithMessagef(err, "failed to load pipeline %s", name)
+	}
+
+	return scheduler.Definition{
+		Source:        name,
+		Config:        &pipelineConf,
+		Pipeline:      p,
+		Schedule:      schedule,
+		After:         after,
+		Subscription:  subscription,
+		Webhook:       webhook,
+		DropFolder:    dropFolder,
+		FailurePolicy: policy,
+	}, nil
+}
+
+// watch reloads the pipelines when the configuration file, or anything in the
+// pipelines or templates directories, changes, until ctx is canceled.
+func (pl *pipelineLoader) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		pl.mux.Lock()
+		paths := watchedPaths(pl.conf)
+		pollInterval := pl.conf.ReloadPollInterval
+		pl.mux.Unlock()
+
+		log.Debugf("Watching %v for changes.", paths)
+		watchCtx, cancel := context.WithCancel(ctx)
+		watcher := &filewatch.Watcher{Paths: paths, PollInterval: pollInterval}
+		watcher.Run(watchCtx, func() {
+			pl.reloadChanges()
+
+			// restart the watcher if the directories changed
+			pl.mux.Lock()
+			changed := !reflect.DeepEqual(paths, watchedPaths(pl.conf))
+			pl.mux.Unlock()
+			if changed {
+				cancel()
+			}
+		})
+		cancel()
+	}
+}
+
+// reloadOnHangup reloads the pipelines each time the service receives SIGHUP,
+// until ctx is canceled.
+func (pl *pipelineLoader) reloadOnHangup(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			log.Info("Received SIGHUP.")
+			pl.reload()
+		}
+	}
+}
+
+// watchedPaths returns the files and directories from which the configuration
+// loads pipelines.
+func watchedPaths(conf config.ServiceConfig) []string {
+	paths := []string{conf.PipelinesDir, conf.TemplatesDir}
+	if confPath := config.Path(); confPath != "" {
+		paths = append(paths, confPath)
+	}
+	return paths
+}
@@ -0,0 +1,114 @@
+/* Apache v2 license
+*  Copyright (C) <2019> Intel Corporation
+*
+*  SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/app/scheduler"
+	"github.com/intel/rsp-sw-toolkit-im-suite-data-provider-service/pkg/mqttsub"
+)
+
+// mqttSubscribers subscribes pipelines to MQTT topics using the configured MQTT
+// clients. Each client's subscriber connects separately from the client used
+// to publish, the first time a pipeline subscribes with it, and like that
+// client, it's kept until the service stops.
+type mqttSubscribers struct {
+	mux     sync.Mutex
+	configs map[string][]byte
+	clients map[string]*mqttsub.Client
+}
+
+func newMQTTSubscribers() *mqttSubscribers {
+	return &mqttSubscribers{
+		configs: map[string][]byte{},
+		clients: map[string]*mqttsub.Client{},
+	}
+}
+
+// configure sets the configuration data of the named MQTT client.
+func (ms *mqttSubscribers) configure(name string, data []byte) {
+	ms.mux.Lock()
+	ms.configs[name] = data
+	ms.mux.Unlock()
+}
+
+// Subscribe implements scheduler.Subscriber.
+func (ms *mqttSubscribers) Subscribe(sub scheduler.Subscription, handle mqttsub.Handler) (func(), error) {
+	client, err := ms.client(sub.Client)
+	if err != nil {
+		return nil, err
+	}
+	return client.Subscribe(sub.Topic, sub.QoS, handle)
+}
+
+// client returns the subscriber for the named MQTT client, connecting it if
+// necessary.
+func (ms *mqttSubscribers) client(name string) (*mqttsub.Client, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if client, ok := ms.clients[name]; ok {
+		return client, nil
+	}
+
+	data, ok := ms.configs[name]
+	if !ok {
+		return nil, errors.Errorf("unknown mqtt client %q", name)
+	}
+	var conf mqttsub.Config
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal mqtt config for %q", name)
+	}
+	// brokers disconnect clients when another connects with the same ID
+	if conf.ClientID != "" {
+		conf.ClientID += "-subscriber"
+	}
+
+	log.Debugf("Connecting MQTT subscriber for %q to %s.", name, conf.Endpoint)
+	client, err := mqttsub.Dial(conf)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unable to create mqtt subscriber for %q", name)
+	}
+	ms.clients[name] = client
+	return client, nil
+}
+
+// Check returns an error listing the MQTT clients whose subscribers aren't
+// connected to their brokers. Subscribers only exist for clients that
+// pipelines subscribe with.
+func (ms *mqttSubscribers) Check(ctx context.Context) error {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	var disconnected []string
+	for name, client := range ms.clients {
+		if !client.Connected() {
+			disconnected = append(disconnected, name)
+		}
+	}
+	if len(disconnected) != 0 {
+		sort.Strings(disconnected)
+		return errors.Errorf("mqtt subscribers aren't connected for %s",
+			strings.Join(disconnected, ", "))
+	}
+	return nil
+}
+
+// Close disconnects the subscribers.
+func (ms *mqttSubscribers) Close() {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	for _, client := range ms.clients {
+		client.Close()
+	}
+}